@@ -0,0 +1,310 @@
+// Package alias groups interface addresses into routers.
+//
+// Two techniques are used, both from the alias resolution literature:
+//   - common source address (iffinder): a UDP probe to an unused port on one
+//     interface is often answered from a different interface of the same
+//     router, which names an alias directly.
+//   - IP-ID probing (Ally and MIDAR): many routers stamp every packet they
+//     send with one shared, increasing IP-ID counter. Interfaces whose
+//     replies fall into a single increasing sequence share a counter, and
+//     so a router.
+package alias
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/arieltraver/ari_traceroute/tracert"
+)
+
+const DEFAULT_PORT = 33533 //unlikely to be open, so probes draw port unreachable
+const DEFAULT_TIMEOUT_MS = 500
+const DEFAULT_ROUNDS = 5
+const DEFAULT_INTERVAL_MS = 100
+const DEFAULT_FUDGE = 200 //largest IP-ID step Ally accepts between probes
+const VELOCITY_RATIO = 1.5 //counters further apart than this are not compared
+
+// Reply is what came back from a single probe.
+type Reply struct {
+	From [4]byte   //address the reply was sent from
+	IPID uint16    //IP-ID of the reply
+	At   time.Time //when the reply arrived
+}
+
+// Prober sends one probe to addr and reports the reply.
+type Prober interface {
+	Probe(addr [4]byte) (Reply, error)
+}
+
+// SocketProber probes with a UDP datagram to an unused port and listens for
+// the ICMP port unreachable that comes back. It needs raw socket access.
+type SocketProber struct {
+	port      int
+	timeoutMs int
+}
+
+func NewSocketProber() *SocketProber {
+	return &SocketProber{port: DEFAULT_PORT, timeoutMs: DEFAULT_TIMEOUT_MS}
+}
+
+func (sp *SocketProber) SetPort(port int) {
+	sp.port = port
+}
+
+func (sp *SocketProber) SetTimeoutMs(timeoutMs int) {
+	sp.timeoutMs = timeoutMs
+}
+
+func (sp *SocketProber) Probe(addr [4]byte) (reply Reply, err error) {
+	recvSocket, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return
+	}
+	defer syscall.Close(recvSocket)
+	sendSocket, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil {
+		return
+	}
+	defer syscall.Close(sendSocket)
+
+	tv := syscall.NsecToTimeval(1000 * 1000 * int64(sp.timeoutMs))
+	syscall.SetsockoptTimeval(recvSocket, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	traceroute.EnableRecvTimestamps(recvSocket)
+
+	deadline := time.Now().Add(time.Duration(sp.timeoutMs) * time.Millisecond)
+	err = syscall.Sendto(sendSocket, []byte{0x0}, 0, &syscall.SockaddrInet4{Port: sp.port, Addr: addr})
+	if err != nil {
+		return
+	}
+	p := make([]byte, 64)
+	for time.Now().Before(deadline) {
+		n, _, at, err := traceroute.RecvTimestamped(recvSocket, p)
+		if err != nil {
+			return reply, err
+		}
+		from, ipid, ok := portUnreachable(p[:n], addr)
+		if ok {
+			return Reply{From: from, IPID: ipid, At: at}, nil
+		}
+	}
+	return reply, errors.New("no reply from " + addressString(addr))
+}
+
+// checks that pkt is an ICMP port unreachable quoting a packet sent to dest,
+// and returns its source address and IP-ID.
+func portUnreachable(pkt []byte, dest [4]byte) (from [4]byte, ipid uint16, ok bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != 1 {
+		return
+	}
+	headerLen := int(pkt[0]&0x0f) * 4
+	icmp := pkt[headerLen:]
+	if len(icmp) < 8+20 || icmp[0] != 3 || icmp[1] != 3 {
+		return
+	}
+	var quoted [4]byte
+	copy(quoted[:], icmp[8+16:8+20])
+	if quoted != dest {
+		return
+	}
+	copy(from[:], pkt[12:16])
+	return from, binary.BigEndian.Uint16(pkt[4:6]), true
+}
+
+func addressString(add [4]byte) string {
+	return fmt.Sprintf("%v.%v.%v.%v", add[0], add[1], add[2], add[3])
+}
+
+// CommonSource is the iffinder test: if a probe to addr is answered from
+// another address, the two are aliases.
+func CommonSource(prober Prober, addr [4]byte) ([4]byte, bool, error) {
+	reply, err := prober.Probe(addr)
+	if err != nil {
+		return addr, false, err
+	}
+	return reply.From, reply.From != addr, nil
+}
+
+// the step from one IP-ID to the next, allowing for the counter wrapping
+func idGap(from uint16, to uint16) int {
+	return int(to - from)
+}
+
+// Ally probes a, b and a again. They are aliases if the three IP-IDs come
+// back in order, each within fudge of the last.
+func Ally(prober Prober, a [4]byte, b [4]byte, fudge int) (bool, error) {
+	x, err := prober.Probe(a)
+	if err != nil {
+		return false, err
+	}
+	y, err := prober.Probe(b)
+	if err != nil {
+		return false, err
+	}
+	z, err := prober.Probe(a)
+	if err != nil {
+		return false, err
+	}
+	first, second := idGap(x.IPID, y.IPID), idGap(y.IPID, z.IPID)
+	return first > 0 && first <= fudge && second > 0 && second <= fudge, nil
+}
+
+// Sample is one IP-ID seen at a point in time.
+type Sample struct {
+	At time.Time
+	ID uint16
+}
+
+// Series is the IP-IDs collected from one address, oldest first.
+type Series []Sample
+
+// Velocity estimates how fast the counter behind s goes up, in IDs per
+// second. It returns false if s has too few samples, or never moves.
+func (s Series) Velocity() (float64, bool) {
+	if len(s) < 3 {
+		return 0, false
+	}
+	total := 0
+	for i := 1; i < len(s); i++ {
+		gap := idGap(s[i-1].ID, s[i].ID)
+		if gap == 0 || gap > 1<<15 { //constant, random or going backwards
+			return 0, false
+		}
+		total += gap
+	}
+	seconds := s[len(s)-1].At.Sub(s[0].At).Seconds()
+	if seconds <= 0 {
+		return 0, false
+	}
+	return float64(total) / seconds, true
+}
+
+// MonotonicBounds is MIDAR's test: a and b share a counter if, merged in
+// time order, their IP-IDs still form one increasing sequence where no step
+// is larger than the counter's velocity allows, plus fudge.
+func MonotonicBounds(a Series, b Series, velocity float64, fudge int) bool {
+	merged := make(Series, 0, len(a)+len(b))
+	merged = append(merged, a...)
+	merged = append(merged, b...)
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].At.Before(merged[j].At) })
+	for i := 1; i < len(merged); i++ {
+		gap := idGap(merged[i-1].ID, merged[i].ID)
+		allowed := int(velocity*VELOCITY_RATIO*merged[i].At.Sub(merged[i-1].At).Seconds()) + fudge
+		if gap <= 0 || gap > allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveOptions controls how hard Resolve probes.
+type ResolveOptions struct {
+	rounds     int
+	intervalMs int
+	fudge      int
+	confirm    bool
+}
+
+func (options *ResolveOptions) Rounds() int {
+	if options.rounds == 0 {
+		options.rounds = DEFAULT_ROUNDS
+	}
+	return options.rounds
+}
+
+func (options *ResolveOptions) SetRounds(rounds int) {
+	options.rounds = rounds
+}
+
+func (options *ResolveOptions) IntervalMs() int {
+	if options.intervalMs == 0 {
+		options.intervalMs = DEFAULT_INTERVAL_MS
+	}
+	return options.intervalMs
+}
+
+func (options *ResolveOptions) SetIntervalMs(intervalMs int) {
+	options.intervalMs = intervalMs
+}
+
+func (options *ResolveOptions) Fudge() int {
+	if options.fudge == 0 {
+		options.fudge = DEFAULT_FUDGE
+	}
+	return options.fudge
+}
+
+func (options *ResolveOptions) SetFudge(fudge int) {
+	options.fudge = fudge
+}
+
+// Confirm reports whether pairs passing the monotonic bounds test are
+// checked again with Ally before being joined.
+func (options *ResolveOptions) Confirm() bool {
+	return options.confirm
+}
+
+func (options *ResolveOptions) SetConfirm(confirm bool) {
+	options.confirm = confirm
+}
+
+// Resolve groups addrs into routers. Each round probes every address once,
+// which gives both the common source test and an IP-ID sample. Pairs whose
+// counters move at similar speeds then go through the monotonic bounds test.
+func Resolve(prober Prober, addrs [][4]byte, options *ResolveOptions) *Groups {
+	groups := NewGroups()
+	series := make(map[[4]byte]Series)
+	for _, addr := range addrs {
+		groups.Add(addr)
+	}
+	for round := 0; round < options.Rounds(); round++ {
+		if round > 0 {
+			time.Sleep(time.Duration(options.IntervalMs()) * time.Millisecond)
+		}
+		for _, addr := range addrs {
+			reply, err := prober.Probe(addr)
+			if err != nil {
+				continue
+			}
+			if reply.From != addr {
+				groups.Union(addr, reply.From)
+			}
+			series[addr] = append(series[addr], Sample{At: reply.At, ID: reply.IPID})
+		}
+	}
+
+	type counter struct {
+		addr     [4]byte
+		velocity float64
+	}
+	counters := []counter{}
+	for _, addr := range addrs {
+		if v, ok := series[addr].Velocity(); ok {
+			counters = append(counters, counter{addr, v})
+		}
+	}
+	sort.Slice(counters, func(i, j int) bool { return counters[i].velocity < counters[j].velocity })
+	for i, a := range counters {
+		for _, b := range counters[i+1:] {
+			if b.velocity > a.velocity*VELOCITY_RATIO {
+				break
+			}
+			if groups.Same(a.addr, b.addr) {
+				continue
+			}
+			if !MonotonicBounds(series[a.addr], series[b.addr], b.velocity, options.Fudge()) {
+				continue
+			}
+			if options.Confirm() {
+				if ok, err := Ally(prober, a.addr, b.addr, options.Fudge()); err != nil || !ok {
+					continue
+				}
+			}
+			groups.Union(a.addr, b.addr)
+		}
+	}
+	return groups
+}
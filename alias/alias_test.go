@@ -0,0 +1,87 @@
+package alias
+
+import (
+	"testing"
+	"time"
+)
+
+// a router with one IP-ID counter shared by all of its interfaces
+type fakeRouter struct {
+	reply    [4]byte //address every reply comes from, or zero for the probed one
+	counter  uint16
+	velocity float64 //IDs per second, on top of one per reply
+}
+
+// answers probes from a set of fake routers, on a clock that moves 5ms per probe
+type fakeProber struct {
+	routers map[[4]byte]*fakeRouter
+	now     time.Time
+}
+
+func (fp *fakeProber) Probe(addr [4]byte) (Reply, error) {
+	fp.now = fp.now.Add(5 * time.Millisecond)
+	router := fp.routers[addr]
+	router.counter += uint16(router.velocity*0.005) + 1
+	from := addr
+	if router.reply != ([4]byte{}) {
+		from = router.reply
+	}
+	return Reply{From: from, IPID: router.counter, At: fp.now}, nil
+}
+
+func TestResolve(t *testing.T) {
+	r1 := &fakeRouter{counter: 1000, velocity: 400}
+	r2 := &fakeRouter{counter: 30000, velocity: 450}
+	r3 := &fakeRouter{counter: 500, velocity: 300, reply: [4]byte{10, 3, 0, 1}}
+	prober := &fakeProber{now: time.Unix(0, 0), routers: map[[4]byte]*fakeRouter{
+		{10, 1, 0, 1}: r1,
+		{10, 1, 0, 2}: r1,
+		{10, 2, 0, 1}: r2,
+		{10, 2, 0, 2}: r2,
+		{10, 3, 0, 2}: r3,
+	}}
+	addrs := [][4]byte{{10, 1, 0, 1}, {10, 2, 0, 1}, {10, 1, 0, 2}, {10, 2, 0, 2}, {10, 3, 0, 2}}
+	options := &ResolveOptions{}
+	options.SetIntervalMs(1)
+	options.SetConfirm(true)
+
+	routers := Resolve(prober, addrs, options).Routers(1)
+	expected := [][][4]byte{
+		{{10, 1, 0, 1}, {10, 1, 0, 2}},
+		{{10, 2, 0, 1}, {10, 2, 0, 2}},
+		{{10, 3, 0, 1}, {10, 3, 0, 2}},
+	}
+	if len(routers) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, routers)
+	}
+	for i := range expected {
+		if len(routers[i]) != len(expected[i]) {
+			t.Fatalf("expected %v, got %v", expected, routers)
+		}
+		for j := range expected[i] {
+			if routers[i][j] != expected[i][j] {
+				t.Errorf("expected %v, got %v", expected, routers)
+			}
+		}
+	}
+}
+
+func TestAllyWraps(t *testing.T) {
+	r := &fakeRouter{counter: 65530}
+	prober := &fakeProber{now: time.Unix(0, 0), routers: map[[4]byte]*fakeRouter{
+		{10, 0, 0, 1}: r,
+		{10, 0, 0, 2}: r,
+	}}
+	ok, err := Ally(prober, [4]byte{10, 0, 0, 1}, [4]byte{10, 0, 0, 2}, DEFAULT_FUDGE)
+	if err != nil || !ok {
+		t.Errorf("expected a shared counter across the wrap, got %v %v", ok, err)
+	}
+}
+
+func TestGroupsMerge(t *testing.T) {
+	g := NewGroups()
+	g.Merge([][][4]byte{{{1, 0, 0, 3}, {1, 0, 0, 2}}, {{1, 0, 0, 4}, {1, 0, 0, 3}}})
+	if g.Find([4]byte{1, 0, 0, 4}) != [4]byte{1, 0, 0, 2} {
+		t.Errorf("expected routers sharing an interface to be merged under the smallest address")
+	}
+}
@@ -0,0 +1,120 @@
+package alias
+
+import (
+	"sort"
+	"sync"
+)
+
+// Groups is a union-find over interface addresses. Each group is one
+// router, and Find returns the address used to name it: the smallest
+// address in the group.
+type Groups struct {
+	parent map[[4]byte][4]byte
+	lock   sync.Mutex
+}
+
+func NewGroups() *Groups {
+	return &Groups{parent: make(map[[4]byte][4]byte)}
+}
+
+func less(a [4]byte, b [4]byte) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+//must hold the lock
+func (g *Groups) find(addr [4]byte) [4]byte {
+	root, ok := g.parent[addr]
+	if !ok {
+		g.parent[addr] = addr
+		return addr
+	}
+	if root == addr {
+		return addr
+	}
+	root = g.find(root)
+	g.parent[addr] = root //path compression
+	return root
+}
+
+// Find returns the name of the router that addr belongs to.
+func (g *Groups) Find(addr [4]byte) [4]byte {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.find(addr)
+}
+
+// Name returns the name of the router addr belongs to, or addr itself if it
+// is not known. Unlike Find, it does not register addr.
+func (g *Groups) Name(addr [4]byte) [4]byte {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if _, ok := g.parent[addr]; !ok {
+		return addr
+	}
+	return g.find(addr)
+}
+
+// Add registers an interface as its own router if it is not known yet.
+func (g *Groups) Add(addr [4]byte) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.find(addr)
+}
+
+// Union records that a and b are interfaces of the same router.
+func (g *Groups) Union(a [4]byte, b [4]byte) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	ra, rb := g.find(a), g.find(b)
+	if ra == rb {
+		return
+	}
+	if less(ra, rb) {
+		g.parent[rb] = ra
+	} else {
+		g.parent[ra] = rb
+	}
+}
+
+// Same reports whether a and b are known to be on the same router.
+func (g *Groups) Same(a [4]byte, b [4]byte) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.find(a) == g.find(b)
+}
+
+// Merge adds every router in routers to g, joining groups that share an interface.
+func (g *Groups) Merge(routers [][][4]byte) {
+	for _, router := range routers {
+		for _, addr := range router {
+			g.Union(router[0], addr)
+		}
+	}
+}
+
+// Routers lists every group with more than min interfaces, each sorted,
+// and sorted by their first address.
+func (g *Groups) Routers(min int) [][][4]byte {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	byRoot := make(map[[4]byte][][4]byte)
+	for addr := range g.parent {
+		root := g.find(addr)
+		byRoot[root] = append(byRoot[root], addr)
+	}
+	routers := [][][4]byte{}
+	for _, router := range byRoot {
+		if len(router) <= min {
+			continue
+		}
+		sort.Slice(router, func(i, j int) bool { return less(router[i], router[j]) })
+		routers = append(routers, router)
+	}
+	sort.Slice(routers, func(i, j int) bool { return less(routers[i][0], routers[j][0]) })
+	return routers
+}
@@ -3,3 +3,5 @@ module github.com/arieltraver/ari_traceroute
 go 1.20
 
 require github.com/aeden/traceroute v0.0.0-20210211061815-03f5f7cb7908
+
+require golang.org/x/sys v0.15.0
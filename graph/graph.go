@@ -0,0 +1,338 @@
+// Package graph keeps the interface-level topology the monitors discover.
+//
+// Monitors report links: two interfaces that answered at consecutive TTLs
+// of one trace. The leader adds them to a Graph, a directed graph from the
+// interface nearer the monitor to the one further away, with how often and
+// when each edge was seen. The Graph answers who follows an interface, the
+// shortest known path between two, and the part of the graph in a prefix.
+package graph
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Link is one observation of an edge by a monitor.
+type Link struct {
+	From    [4]byte       //the hop at TTL-1
+	To      [4]byte       //the hop at TTL
+	TTL     int           //TTL of the probe that found To
+	RTT     time.Duration //round trip time to To
+	Monitor string        //who saw it
+	At      time.Time     //when the probe was sent
+}
+
+// Edge is everything known about one directed edge.
+type Edge struct {
+	From      [4]byte
+	To        [4]byte
+	Count     int //observations
+	FirstSeen time.Time
+	LastSeen  time.Time
+	MinTTL    int
+	MaxTTL    int
+	MinRTT    time.Duration //0 if no observation had an RTT
+	Monitors  []string      //who saw it, sorted
+}
+
+// Graph is a directed interface graph. It is safe for concurrent use.
+type Graph struct {
+	out  map[[4]byte]map[[4]byte]*Edge
+	in   map[[4]byte]map[[4]byte]struct{}
+	lock sync.RWMutex
+}
+
+func New() *Graph {
+	return &Graph{out: make(map[[4]byte]map[[4]byte]*Edge), in: make(map[[4]byte]map[[4]byte]struct{})}
+}
+
+func less(a [4]byte, b [4]byte) bool {
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+func sortAddresses(addrs [][4]byte) {
+	sort.Slice(addrs, func(i, j int) bool { return less(addrs[i], addrs[j]) })
+}
+
+//must hold the lock
+func (g *Graph) add(link Link) {
+	if link.From == link.To {
+		return
+	}
+	next, ok := g.out[link.From]
+	if !ok {
+		next = make(map[[4]byte]*Edge)
+		g.out[link.From] = next
+	}
+	e, ok := next[link.To]
+	if !ok {
+		e = &Edge{From: link.From, To: link.To, FirstSeen: link.At, LastSeen: link.At, MinTTL: link.TTL, MaxTTL: link.TTL}
+		next[link.To] = e
+		prev, ok := g.in[link.To]
+		if !ok {
+			prev = make(map[[4]byte]struct{})
+			g.in[link.To] = prev
+		}
+		prev[link.From] = struct{}{}
+	}
+	e.Count++
+	if link.At.Before(e.FirstSeen) {
+		e.FirstSeen = link.At
+	}
+	if link.At.After(e.LastSeen) {
+		e.LastSeen = link.At
+	}
+	if link.TTL < e.MinTTL {
+		e.MinTTL = link.TTL
+	}
+	if link.TTL > e.MaxTTL {
+		e.MaxTTL = link.TTL
+	}
+	if link.RTT > 0 && (e.MinRTT == 0 || link.RTT < e.MinRTT) {
+		e.MinRTT = link.RTT
+	}
+	if link.Monitor != "" {
+		addMonitor(e, link.Monitor)
+	}
+}
+
+//adds monitor to the sorted monitors of e
+func addMonitor(e *Edge, monitor string) {
+	i := sort.SearchStrings(e.Monitors, monitor)
+	if i == len(e.Monitors) || e.Monitors[i] != monitor {
+		e.Monitors = append(e.Monitors, "")
+		copy(e.Monitors[i+1:], e.Monitors[i:])
+		e.Monitors[i] = monitor
+	}
+}
+
+// Add records one observation of a link.
+func (g *Graph) Add(links ...Link) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, link := range links {
+		g.add(link)
+	}
+}
+
+// Edge returns a copy of the edge from one interface to another.
+func (g *Graph) Edge(from [4]byte, to [4]byte) (Edge, bool) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	e, ok := g.out[from][to]
+	if !ok {
+		return Edge{}, false
+	}
+	return copyEdge(e), true
+}
+
+func copyEdge(e *Edge) Edge {
+	c := *e
+	c.Monitors = append([]string(nil), e.Monitors...)
+	return c
+}
+
+// Neighbours returns the interfaces seen right after addr, sorted.
+func (g *Graph) Neighbours(addr [4]byte) [][4]byte {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	next := make([][4]byte, 0, len(g.out[addr]))
+	for to := range g.out[addr] {
+		next = append(next, to)
+	}
+	sortAddresses(next)
+	return next
+}
+
+// Predecessors returns the interfaces seen right before addr, sorted.
+func (g *Graph) Predecessors(addr [4]byte) [][4]byte {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	prev := make([][4]byte, 0, len(g.in[addr]))
+	for from := range g.in[addr] {
+		prev = append(prev, from)
+	}
+	sortAddresses(prev)
+	return prev
+}
+
+// Path returns a shortest path of edges from one interface to another,
+// both included, or false if there is none.
+func (g *Graph) Path(from [4]byte, to [4]byte) ([][4]byte, bool) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	if from == to {
+		return [][4]byte{from}, true
+	}
+	parent := map[[4]byte][4]byte{from: from}
+	queue := [][4]byte{from}
+	for len(queue) > 0 {
+		at := queue[0]
+		queue = queue[1:]
+		for next := range g.out[at] {
+			if _, seen := parent[next]; seen {
+				continue
+			}
+			parent[next] = at
+			if next == to {
+				path := [][4]byte{to}
+				for at := to; at != from; {
+					at = parent[at]
+					path = append(path, at)
+				}
+				for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				return path, true
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil, false
+}
+
+// Subgraph returns the edges with both ends inside prefix, as a new Graph.
+func (g *Graph) Subgraph(prefix netip.Prefix) *Graph {
+	inside := func(addr [4]byte) bool {
+		return prefix.Contains(netip.AddrFrom4(addr))
+	}
+	sub := New()
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	for from, next := range g.out {
+		if !inside(from) {
+			continue
+		}
+		for to, e := range next {
+			if inside(to) {
+				c := copyEdge(e)
+				sub.put(&c)
+			}
+		}
+	}
+	return sub
+}
+
+// Collapse returns a new Graph with every interface replaced by name(addr),
+// such as the router it belongs to. Edges that end up joining the same pair
+// are merged, and edges within one name are dropped.
+func (g *Graph) Collapse(name func([4]byte) [4]byte) *Graph {
+	collapsed := New()
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	for from, next := range g.out {
+		for to, e := range next {
+			c := copyEdge(e)
+			c.From, c.To = name(from), name(to)
+			if c.From == c.To {
+				continue
+			}
+			if merged, ok := collapsed.out[c.From][c.To]; ok {
+				merge(merged, &c)
+			} else {
+				collapsed.put(&c)
+			}
+		}
+	}
+	return collapsed
+}
+
+//adds what is known of e to into, an edge between the same ends
+func merge(into *Edge, e *Edge) {
+	into.Count += e.Count
+	if e.FirstSeen.Before(into.FirstSeen) {
+		into.FirstSeen = e.FirstSeen
+	}
+	if e.LastSeen.After(into.LastSeen) {
+		into.LastSeen = e.LastSeen
+	}
+	if e.MinTTL < into.MinTTL {
+		into.MinTTL = e.MinTTL
+	}
+	if e.MaxTTL > into.MaxTTL {
+		into.MaxTTL = e.MaxTTL
+	}
+	if e.MinRTT > 0 && (into.MinRTT == 0 || e.MinRTT < into.MinRTT) {
+		into.MinRTT = e.MinRTT
+	}
+	for _, monitor := range e.Monitors {
+		addMonitor(into, monitor)
+	}
+}
+
+//adds a whole edge. must hold the lock, or own the graph.
+func (g *Graph) put(e *Edge) {
+	next, ok := g.out[e.From]
+	if !ok {
+		next = make(map[[4]byte]*Edge)
+		g.out[e.From] = next
+	}
+	next[e.To] = e
+	prev, ok := g.in[e.To]
+	if !ok {
+		prev = make(map[[4]byte]struct{})
+		g.in[e.To] = prev
+	}
+	prev[e.From] = struct{}{}
+}
+
+// Edges returns a copy of every edge, sorted by where they start and end.
+func (g *Graph) Edges() []Edge {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	edges := []Edge{}
+	for _, next := range g.out {
+		for _, e := range next {
+			edges = append(edges, copyEdge(e))
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return less(edges[i].From, edges[j].From)
+		}
+		return less(edges[i].To, edges[j].To)
+	})
+	return edges
+}
+
+// Size returns the number of interfaces and edges.
+func (g *Graph) Size() (int, int) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	nodes := map[[4]byte]struct{}{}
+	edges := 0
+	for from, next := range g.out {
+		nodes[from] = struct{}{}
+		for to := range next {
+			nodes[to] = struct{}{}
+			edges++
+		}
+	}
+	return len(nodes), edges
+}
+
+// GobEncode saves the graph as its list of edges.
+func (g *Graph) GobEncode() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(g.Edges())
+	return buf.Bytes(), err
+}
+
+func (g *Graph) GobDecode(data []byte) error {
+	var edges []Edge
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&edges); err != nil {
+		return err
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.out = make(map[[4]byte]map[[4]byte]*Edge)
+	g.in = make(map[[4]byte]map[[4]byte]struct{})
+	for i := range edges {
+		g.put(&edges[i])
+	}
+	return nil
+}
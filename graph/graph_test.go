@@ -0,0 +1,104 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func addr(last byte) [4]byte {
+	return [4]byte{10, 0, 0, last}
+}
+
+func TestGraphEdges(t *testing.T) {
+	g := New()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	g.Add(
+		Link{From: addr(1), To: addr(2), TTL: 2, RTT: 5 * time.Millisecond, Monitor: "mon2", At: start},
+		Link{From: addr(1), To: addr(2), TTL: 3, RTT: 3 * time.Millisecond, Monitor: "mon1", At: start.Add(time.Hour)},
+		Link{From: addr(1), To: addr(2), TTL: 2, Monitor: "mon2", At: start.Add(-time.Hour)},
+		Link{From: addr(2), To: addr(3), TTL: 3, At: start},
+		Link{From: addr(1), To: addr(4), TTL: 2, At: start},
+		Link{From: addr(4), To: addr(3), TTL: 3, At: start},
+		Link{From: addr(3), To: [4]byte{192, 0, 2, 1}, TTL: 4, At: start},
+	)
+	e, ok := g.Edge(addr(1), addr(2))
+	if !ok || e.Count != 3 || e.MinTTL != 2 || e.MaxTTL != 3 || e.MinRTT != 3*time.Millisecond {
+		t.Errorf("unexpected edge %+v", e)
+	}
+	if !e.FirstSeen.Equal(start.Add(-time.Hour)) || !e.LastSeen.Equal(start.Add(time.Hour)) {
+		t.Errorf("unexpected first and last seen %v %v", e.FirstSeen, e.LastSeen)
+	}
+	if !reflect.DeepEqual(e.Monitors, []string{"mon1", "mon2"}) {
+		t.Errorf("unexpected monitors %v", e.Monitors)
+	}
+	if next := g.Neighbours(addr(1)); !reflect.DeepEqual(next, [][4]byte{addr(2), addr(4)}) {
+		t.Errorf("unexpected neighbours %v", next)
+	}
+	if prev := g.Predecessors(addr(3)); !reflect.DeepEqual(prev, [][4]byte{addr(2), addr(4)}) {
+		t.Errorf("unexpected predecessors %v", prev)
+	}
+	path, ok := g.Path(addr(1), [4]byte{192, 0, 2, 1})
+	if !ok || len(path) != 4 || path[0] != addr(1) || path[3] != [4]byte{192, 0, 2, 1} {
+		t.Errorf("unexpected path %v", path)
+	}
+	if _, ok := g.Path(addr(3), addr(1)); ok {
+		t.Errorf("edges should only be followed forwards")
+	}
+	if nodes, edges := g.Subgraph(netip.MustParsePrefix("10.0.0.0/8")).Size(); nodes != 4 || edges != 4 {
+		t.Errorf("expected 4 interfaces and 4 edges inside 10/8, got %d and %d", nodes, edges)
+	}
+}
+
+func TestGraphCollapse(t *testing.T) {
+	g := New()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	g.Add(
+		Link{From: addr(1), To: addr(2), TTL: 2, RTT: 5 * time.Millisecond, Monitor: "mon2", At: start},
+		Link{From: addr(1), To: addr(3), TTL: 3, RTT: 3 * time.Millisecond, Monitor: "mon1", At: start.Add(time.Hour)},
+		Link{From: addr(2), To: addr(3), TTL: 3, At: start},
+		Link{From: addr(3), To: addr(4), TTL: 4, At: start},
+	)
+	//2 and 3 are interfaces of one router
+	routers := g.Collapse(func(a [4]byte) [4]byte {
+		if a == addr(3) {
+			return addr(2)
+		}
+		return a
+	})
+	if nodes, edges := routers.Size(); nodes != 3 || edges != 2 {
+		t.Errorf("expected 3 routers and 2 links, got %d and %d", nodes, edges)
+	}
+	e, ok := routers.Edge(addr(1), addr(2))
+	if !ok || e.Count != 2 || e.MinTTL != 2 || e.MaxTTL != 3 || e.MinRTT != 3*time.Millisecond || !e.LastSeen.Equal(start.Add(time.Hour)) {
+		t.Errorf("the edges into the router were not merged: %+v", e)
+	}
+	if !reflect.DeepEqual(e.Monitors, []string{"mon1", "mon2"}) {
+		t.Errorf("unexpected monitors %v", e.Monitors)
+	}
+	if _, ok := routers.Edge(addr(2), addr(4)); !ok {
+		t.Errorf("the edge out of the router is missing")
+	}
+	if _, edges := g.Size(); edges != 4 {
+		t.Errorf("collapsing changed the interface graph")
+	}
+}
+
+func TestGraphOverGob(t *testing.T) {
+	g := New()
+	g.Add(Link{From: addr(1), To: addr(2), TTL: 2, Monitor: "mon1", At: time.Unix(100, 0)})
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(g); err != nil {
+		t.Fatal(err)
+	}
+	back := New()
+	if err := gob.NewDecoder(buf).Decode(back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back.Edges(), g.Edges()) || len(back.Predecessors(addr(2))) != 1 {
+		t.Errorf("graph changed over gob: %+v", back.Edges())
+	}
+}
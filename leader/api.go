@@ -0,0 +1,536 @@
+package main
+
+//HTTP API
+//A JSON API beside the RPCs, on the same port:
+//  GET  /api/status               campaign progress
+//  GET  /api/ranges               every range, with its owner, lease and stop set
+//  GET  /api/ranges/{i}           one range, with its targets
+//  GET  /api/monitors             every monitor, with its progress and last contact
+//  POST /api/pause, /api/resume   stop and start lending ranges
+//  POST /api/ranges/{i}/revoke    take a range back from its monitor
+//  POST /api/ranges               add targets, as {"targets": "...", "range_size": n, "ranges": n}
+//  GET  /api/enrolments           every enrolled monitor
+//  POST /api/enrolments/{id}/revoke  refuse a monitor from now on
+//  POST /api/enrolments/token    reload the enrolment token from the -enroll file
+//  GET  /api/rounds               the current round and the ones that ended
+//  POST /api/rounds               start a new round, optionally as {"stops": "seed" or "empty"}
+//  GET  /api/graph/neighbours?addr=a        the interfaces seen right before and after a
+//  GET  /api/graph/path?from=a&to=b         a shortest known path from a to b
+//  GET  /api/graph/subgraph?prefix=p        the links with both ends in prefix p
+//The graph calls take routers=1 to ask about the router graph instead, where
+//every interface stands for the router alias resolution put it on.
+//The targets are in any format the -targets files can be. With -admin, every
+//call needs the admin token as an "Authorization: Bearer" header.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arieltraver/ari_traceroute/graph"
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+const MAX_API_BODY = 64 << 20 //largest request body accepted, for adding targets
+
+//when each monitor last called the leader, and where it is
+type monitorContacts struct {
+	last map[string]time.Time
+	region map[string]string
+	lock sync.Mutex
+}
+
+func newMonitorContacts() *monitorContacts {
+	return &monitorContacts{last: make(map[string]time.Time), region: make(map[string]string)}
+}
+
+func (c *monitorContacts) touch(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.last[id] = time.Now()
+}
+
+func (c *monitorContacts) get(id string) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.last[id]
+}
+
+func (c *monitorContacts) setRegion(id string, region string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.region[id] = region
+}
+
+//the monitors that called at or after t
+func (c *monitorContacts) since(t time.Time) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ids := []string{}
+	for id, last := range c.last {
+		if !last.Before(t) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+//the region a monitor said it is in, empty if it did not
+func (c *monitorContacts) regionOf(id string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.region[id]
+}
+
+type statusJSON struct {
+	Ranges int `json:"ranges"`
+	Leased int `json:"leased"`
+	Monitors int `json:"monitors"`
+	Probed int `json:"probed"` //ranges probed, counting each monitor's separately
+	ToProbe int `json:"to_probe"` //ranges times monitors
+	Progress float64 `json:"progress"` //probed over to_probe
+	Results int64 `json:"results"`
+	Interfaces int `json:"interfaces"`
+	Links int `json:"links"`
+	Paused bool `json:"paused"`
+	Round int `json:"round"`
+}
+
+type rangeJSON struct {
+	Index int `json:"index"`
+	Targets int `json:"targets"`
+	Addresses []string `json:"addresses,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	LeaseExpires *time.Time `json:"lease_expires,omitempty"`
+	StopEntries int `json:"stop_entries"`
+	StopVersion int `json:"stop_version"`
+}
+
+type monitorJSON struct {
+	Id string `json:"id"`
+	Region string `json:"region,omitempty"`
+	Probed int `json:"probed"`
+	Unseen int `json:"unseen"`
+	LastContact time.Time `json:"last_contact"`
+}
+
+type enrolmentJSON struct {
+	Id string `json:"id"`
+	Region string `json:"region,omitempty"`
+	Enrolled time.Time `json:"enrolled"`
+	LastContact time.Time `json:"last_contact"`
+	Revoked bool `json:"revoked"`
+}
+
+type roundsJSON struct {
+	Round int `json:"round"`
+	Started time.Time `json:"started"`
+	Stops string `json:"stops"`
+	Results int64 `json:"results"`
+	Past []roundSummary `json:"past"`
+}
+
+type startRoundJSON struct {
+	Stops string `json:"stops"` //-roundstops if empty
+}
+
+type edgeJSON struct {
+	From string `json:"from"`
+	To string `json:"to"`
+	Count int `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen time.Time `json:"last_seen"`
+	MinTTL int `json:"min_ttl"`
+	MaxTTL int `json:"max_ttl"`
+	MinRTT time.Duration `json:"min_rtt_ns,omitempty"`
+	Monitors []string `json:"monitors"`
+}
+
+type neighboursJSON struct {
+	Addr string `json:"addr"`
+	Previous []string `json:"previous"`
+	Next []string `json:"next"`
+}
+
+type addRangesJSON struct {
+	Targets string `json:"targets"`
+	RangeSize int `json:"range_size"`
+	Ranges int `json:"ranges"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+//a range as the API shows it. the range must be locked.
+func describeRange(index int, thisRange *ipRange, withAddresses bool) rangeJSON {
+	r := rangeJSON{
+		Index: index,
+		Targets: len(thisRange.addresses),
+		Owner: thisRange.currentProbe,
+		StopEntries: thisRange.stops.Set().Size(),
+		StopVersion: thisRange.stops.Version(),
+	}
+	if r.Owner != "" && !thisRange.leaseExpires.IsZero() {
+		expires := thisRange.leaseExpires
+		r.LeaseExpires = &expires
+	}
+	if withAddresses {
+		for _, addr := range thisRange.addresses {
+			r.Addresses = append(r.Addresses, net.IP(addr[:]).String())
+		}
+	}
+	return r
+}
+
+func listRanges() []rangeJSON {
+	tableLock.RLock()
+	defer tableLock.RUnlock()
+	ranges := make([]rangeJSON, len(ipTable))
+	for i, thisRange := range ipTable {
+		thisRange.lock.Lock()
+		ranges[i] = describeRange(i, thisRange, false)
+		thisRange.lock.Unlock()
+	}
+	return ranges
+}
+
+func listMonitors() []monitorJSON {
+	tableLock.RLock()
+	numRanges := len(ipTable)
+	tableLock.RUnlock()
+	seenRanges.lock.Lock()
+	monitors := make([]monitorJSON, 0, len(seenRanges.rangesSeenBy))
+	for id, unseen := range seenRanges.rangesSeenBy {
+		monitors = append(monitors, monitorJSON{Id: id, Unseen: unseen.Size(), Probed: numRanges - unseen.Size()})
+	}
+	seenRanges.lock.Unlock()
+	for i := range monitors {
+		monitors[i].LastContact = contacts.get(monitors[i].Id)
+		monitors[i].Region = contacts.regionOf(monitors[i].Id)
+	}
+	sort.Slice(monitors, func(i, j int) bool { return monitors[i].Id < monitors[j].Id })
+	return monitors
+}
+
+func campaignStatus() statusJSON {
+	ranges := listRanges()
+	monitors := listMonitors()
+	status := statusJSON{
+		Ranges: len(ranges),
+		Monitors: len(monitors),
+		ToProbe: len(ranges) * len(monitors),
+		Results: resultsAccepted.Load(),
+		Interfaces: allIPs.Size(),
+		Paused: assignPaused.Load(),
+		Round: rounds.current(),
+	}
+	for _, r := range ranges {
+		if r.Owner != "" {
+			status.Leased++
+		}
+	}
+	for _, m := range monitors {
+		status.Probed += m.Probed
+	}
+	if status.ToProbe > 0 {
+		status.Progress = float64(status.Probed) / float64(status.ToProbe)
+	}
+	_, status.Links = topology.Size()
+	return status
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, campaignStatus())
+}
+
+func handleMonitors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, listMonitors())
+}
+
+//pause or resume
+func handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+			return
+		}
+		assignPaused.Store(paused)
+		writeJSON(w, http.StatusOK, campaignStatus())
+	}
+}
+
+//the whole list, adding to it, and everything under /api/ranges/{i}
+func handleRanges(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/ranges"), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, listRanges())
+		case http.MethodPost:
+			handleAddRanges(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		}
+		return
+	}
+	indexText, action, _ := strings.Cut(rest, "/")
+	index, err := strconv.Atoi(indexText)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no range %q", indexText))
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		tableLock.RLock()
+		defer tableLock.RUnlock()
+		if index < 0 || index >= len(ipTable) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no range %d", index))
+			return
+		}
+		thisRange := ipTable[index]
+		thisRange.lock.Lock()
+		defer thisRange.lock.Unlock()
+		writeJSON(w, http.StatusOK, describeRange(index, thisRange, true))
+	case action == "revoke" && r.Method == http.MethodPost:
+		owner, err := revokeLease(index)
+		if err == errNoRange {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"index": index, "owner": owner})
+	case action == "" || action == "revoke":
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no action %q", action))
+	}
+}
+
+//the list of enrolments, and /api/enrolments/{id}/revoke
+func handleEnrolments(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/enrolments"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+			return
+		}
+		list := []enrolmentJSON{}
+		for _, e := range enrolled.list() {
+			list = append(list, enrolmentJSON{Id: e.Id, Region: e.Region, Enrolled: e.Enrolled, LastContact: contacts.get(e.Id), Revoked: e.Revoked})
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	if rest == "token" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+			return
+		}
+		if err := reloadEnrollToken(); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"reloaded": true})
+		return
+	}
+	id, action, _ := strings.Cut(rest, "/")
+	if action != "revoke" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no action %q", action))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	if err := revokeMonitor(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "revoked": true})
+}
+
+func handleAddRanges(w http.ResponseWriter, r *http.Request) {
+	var args addRangesJSON
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_API_BODY)).Decode(&args); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	found := set.NewSet[[4]byte]()
+	if _, err := readTargets(strings.NewReader(args.Targets), found); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	targets := notExcluded(found, exclude)
+	if len(targets) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no targets, or all of them are excluded"))
+		return
+	}
+	ranges := partition(targets, args.RangeSize, args.Ranges, rand.New(rand.NewSource(time.Now().UnixNano())))
+	first, err := addRanges(ranges)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]int{"first": first, "ranges": len(ranges), "targets": len(targets), "excluded": found.Size() - len(targets)})
+}
+
+//the graph a query asks about, the interfaces or, with routers=1, the routers
+func graphOf(r *http.Request) (*graph.Graph, func([4]byte) [4]byte) {
+	if r.URL.Query().Get("routers") == "1" {
+		return routerGraph(), routers.Name
+	}
+	return topology, func(addr [4]byte) [4]byte { return addr }
+}
+
+//the IPv4 address in query parameter name
+func addrParam(r *http.Request, name string) ([4]byte, error) {
+	addr, err := netip.ParseAddr(r.URL.Query().Get(name))
+	if err != nil || !addr.Is4() {
+		return [4]byte{}, fmt.Errorf("%s should be an IPv4 address, got %q", name, r.URL.Query().Get(name))
+	}
+	return addr.As4(), nil
+}
+
+func addrStrings(addrs [][4]byte) []string {
+	list := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		list = append(list, net.IP(addr[:]).String())
+	}
+	return list
+}
+
+//neighbours, path and subgraph under /api/graph
+func handleGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	g, name := graphOf(r)
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/graph"), "/") {
+	case "neighbours":
+		addr, err := addrParam(r, "addr")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		addr = name(addr)
+		writeJSON(w, http.StatusOK, neighboursJSON{
+			Addr: net.IP(addr[:]).String(),
+			Previous: addrStrings(g.Predecessors(addr)),
+			Next: addrStrings(g.Neighbours(addr)),
+		})
+	case "path":
+		from, err := addrParam(r, "from")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		to, err := addrParam(r, "to")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		path, ok := g.Path(name(from), name(to))
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no known path from %s to %s", net.IP(from[:]), net.IP(to[:])))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string][]string{"path": addrStrings(path)})
+	case "subgraph":
+		prefix, err := netip.ParsePrefix(r.URL.Query().Get("prefix"))
+		if err != nil || !prefix.Addr().Is4() {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("prefix should be an IPv4 prefix, got %q", r.URL.Query().Get("prefix")))
+			return
+		}
+		edges := []edgeJSON{}
+		for _, e := range g.Subgraph(prefix.Masked()).Edges() {
+			edges = append(edges, edgeJSON{
+				From: net.IP(e.From[:]).String(),
+				To: net.IP(e.To[:]).String(),
+				Count: e.Count,
+				FirstSeen: e.FirstSeen,
+				LastSeen: e.LastSeen,
+				MinTTL: e.MinTTL,
+				MaxTTL: e.MaxTTL,
+				MinRTT: e.MinRTT,
+				Monitors: e.Monitors,
+			})
+		}
+		writeJSON(w, http.StatusOK, edges)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no graph call %q", r.URL.Path))
+	}
+}
+
+//the current and past rounds, and starting the next
+func handleRounds(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rounds.lock.Lock()
+		list := roundsJSON{Round: rounds.number, Started: rounds.started, Stops: rounds.stops, Results: rounds.results, Past: append([]roundSummary{}, rounds.past...)}
+		rounds.lock.Unlock()
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		args := startRoundJSON{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_API_BODY)).Decode(&args); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if args.Stops == "" {
+			args.Stops = roundStops
+		}
+		if err := validRoundStops(args.Stops); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		ended, err := startRound(args.Stops)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"round": ended.Number + 1, "ended": ended})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+	}
+}
+
+//adds the API to mux
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/api/status", requireAdmin(handleStatus))
+	mux.HandleFunc("/api/monitors", requireAdmin(handleMonitors))
+	mux.HandleFunc("/api/pause", requireAdmin(handlePause(true)))
+	mux.HandleFunc("/api/resume", requireAdmin(handlePause(false)))
+	mux.HandleFunc("/api/ranges", requireAdmin(handleRanges))
+	mux.HandleFunc("/api/ranges/", requireAdmin(handleRanges))
+	mux.HandleFunc("/api/enrolments", requireAdmin(handleEnrolments))
+	mux.HandleFunc("/api/enrolments/", requireAdmin(handleEnrolments))
+	mux.HandleFunc("/api/rounds", requireAdmin(handleRounds))
+	mux.HandleFunc("/api/graph/", requireAdmin(handleGraph))
+}
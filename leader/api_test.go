@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/arieltraver/ari_traceroute/graph"
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+func apiCall(t *testing.T, mux *http.ServeMux, method string, path string, body string, want int, out any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if rec.Code != want {
+		t.Fatalf("%s %s: expected %d, got %d: %s", method, path, want, rec.Code, rec.Body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	setup(4)
+	mux := http.NewServeMux()
+	registerAPI(mux)
+
+	_, _, index, err := findNewRange("mon1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ranges []rangeJSON
+	apiCall(t, mux, "GET", "/api/ranges", "", http.StatusOK, &ranges)
+	if len(ranges) != 4 || ranges[index].Owner != "mon1" || ranges[index].LeaseExpires == nil {
+		t.Errorf("unexpected ranges %+v", ranges)
+	}
+	var status statusJSON
+	apiCall(t, mux, "GET", "/api/status", "", http.StatusOK, &status)
+	if status.Ranges != 4 || status.Leased != 1 || status.Monitors != 1 || status.ToProbe != 4 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	//revoking frees the range once, and only once
+	var revoked map[string]any
+	apiCall(t, mux, "POST", "/api/ranges/"+strconv.Itoa(index)+"/revoke", "", http.StatusOK, &revoked)
+	if revoked["owner"] != "mon1" || ipTable[index].currentProbe != "" {
+		t.Errorf("the lease was not revoked: %v", revoked)
+	}
+	apiCall(t, mux, "POST", "/api/ranges/"+strconv.Itoa(index)+"/revoke", "", http.StatusConflict, nil)
+	apiCall(t, mux, "POST", "/api/ranges/9/revoke", "", http.StatusNotFound, nil)
+	apiCall(t, mux, "GET", "/api/ranges/9", "", http.StatusNotFound, nil)
+	apiCall(t, mux, "DELETE", "/api/ranges", "", http.StatusMethodNotAllowed, nil)
+
+	//paused monitors get nothing
+	apiCall(t, mux, "POST", "/api/pause", "", http.StatusOK, &status)
+	reply := IpReply{}
+	if err := new(Leader).GetIPs(IpArgs{ProbeId: "mon2"}, &reply); err != nil || !reply.Paused || reply.Ips != nil {
+		t.Errorf("expected a paused reply, got %+v %v", reply, err)
+	}
+	apiCall(t, mux, "POST", "/api/resume", "", http.StatusOK, &status)
+	if status.Paused {
+		t.Errorf("still paused")
+	}
+
+	var added map[string]int
+	apiCall(t, mux, "POST", "/api/ranges", `{"targets": "10.1.0.0/22\n198.51.100.7\n", "range_size": 2}`, http.StatusCreated, &added)
+	if added["first"] != 4 || added["ranges"] != 3 || added["targets"] != 5 || len(ipTable) != 7 {
+		t.Errorf("unexpected result of adding %v, %d ranges", added, len(ipTable))
+	}
+	var one rangeJSON
+	apiCall(t, mux, "GET", "/api/ranges/4", "", http.StatusOK, &one)
+	if one.Targets == 0 || len(one.Addresses) != one.Targets {
+		t.Errorf("unexpected range %+v", one)
+	}
+	var monitors []monitorJSON
+	apiCall(t, mux, "GET", "/api/monitors", "", http.StatusOK, &monitors)
+	if len(monitors) != 1 || monitors[0].Id != "mon1" || monitors[0].Unseen != 7 {
+		t.Errorf("unexpected monitors %+v", monitors)
+	}
+	if contacts.get("mon2").IsZero() {
+		t.Errorf("the paused call of mon2 was not noted")
+	}
+	apiCall(t, mux, "POST", "/api/ranges", `{"targets": ""}`, http.StatusBadRequest, nil)
+
+	if exclude, err = set.ParsePrefixSet("192.0.2.0/24\n"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { exclude = nil }()
+	apiCall(t, mux, "POST", "/api/ranges", `{"targets": "192.0.2.0/30\n198.51.100.9\n"}`, http.StatusCreated, &added)
+	if added["targets"] != 1 || added["excluded"] != 1 || len(ipTable) != 8 {
+		t.Errorf("excluded targets were added: %v, %d ranges", added, len(ipTable))
+	}
+	apiCall(t, mux, "POST", "/api/ranges", `{"targets": "192.0.2.7\n"}`, http.StatusBadRequest, nil)
+}
+
+func TestGraphAPI(t *testing.T) {
+	setup(1)
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	topology.Add(
+		graph.Link{From: [4]byte{10, 0, 0, 1}, To: [4]byte{10, 0, 0, 2}, TTL: 2, Monitor: "mon1"},
+		graph.Link{From: [4]byte{10, 0, 0, 2}, To: [4]byte{10, 0, 1, 3}, TTL: 3, Monitor: "mon1"},
+		graph.Link{From: [4]byte{10, 0, 0, 1}, To: [4]byte{10, 0, 0, 4}, TTL: 2, Monitor: "mon2"},
+	)
+	routers.Union([4]byte{10, 0, 0, 2}, [4]byte{10, 0, 0, 4})
+
+	var near neighboursJSON
+	apiCall(t, mux, "GET", "/api/graph/neighbours?addr=10.0.0.2", "", http.StatusOK, &near)
+	if len(near.Previous) != 1 || near.Previous[0] != "10.0.0.1" || len(near.Next) != 1 || near.Next[0] != "10.0.1.3" {
+		t.Errorf("unexpected neighbours %+v", near)
+	}
+	apiCall(t, mux, "GET", "/api/graph/neighbours?addr=10.0.0.4&routers=1", "", http.StatusOK, &near)
+	if near.Addr != "10.0.0.2" || len(near.Next) != 1 {
+		t.Errorf("10.0.0.4 should stand for its router, got %+v", near)
+	}
+	var path map[string][]string
+	apiCall(t, mux, "GET", "/api/graph/path?from=10.0.0.1&to=10.0.1.3", "", http.StatusOK, &path)
+	if strings.Join(path["path"], " ") != "10.0.0.1 10.0.0.2 10.0.1.3" {
+		t.Errorf("unexpected path %v", path)
+	}
+	apiCall(t, mux, "GET", "/api/graph/path?from=10.0.0.4&to=10.0.1.3", "", http.StatusNotFound, nil)
+	apiCall(t, mux, "GET", "/api/graph/path?from=10.0.0.4&to=10.0.1.3&routers=1", "", http.StatusOK, &path)
+	var edges []edgeJSON
+	apiCall(t, mux, "GET", "/api/graph/subgraph?prefix=10.0.0.0/24", "", http.StatusOK, &edges)
+	if len(edges) != 2 || edges[0].To != "10.0.0.2" || edges[1].Monitors[0] != "mon2" {
+		t.Errorf("unexpected subgraph %+v", edges)
+	}
+	apiCall(t, mux, "GET", "/api/graph/subgraph?prefix=10.0.0.0/24&routers=1", "", http.StatusOK, &edges)
+	if len(edges) != 1 || edges[0].Count != 2 {
+		t.Errorf("unexpected router subgraph %+v", edges)
+	}
+	apiCall(t, mux, "GET", "/api/graph/neighbours?addr=nowhere", "", http.StatusBadRequest, nil)
+	apiCall(t, mux, "GET", "/api/graph/subgraph", "", http.StatusBadRequest, nil)
+	apiCall(t, mux, "GET", "/api/graph/cycles", "", http.StatusNotFound, nil)
+	apiCall(t, mux, "POST", "/api/graph/path", "", http.StatusMethodNotAllowed, nil)
+}
@@ -0,0 +1,241 @@
+package main
+
+//ASSIGNMENT
+//Which free range a monitor gets next is up to an assignPolicy, chosen with
+//-assign. findNewRange holds assignLock while it looks at the free ranges,
+//asks the policy and lends out the chosen one, so concurrent GetIPs calls see
+//each other's choices and never lend a range twice.
+//  random          any free range, the old behaviour
+//  roundrobin      the next free range after the last one lent, in table order
+//  leastrecent     the range whose results came back longest ago, unprobed ones first
+//  stopreuse       a range whose stop set the monitor already holds, fewest versions
+//                  behind, so the least is sent; otherwise the biggest stop set,
+//                  which saves the most probes
+//  region          the range probed least by monitors of the same -region, so each
+//                  range is seen from as many places as possible
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_POLICY = "random"
+
+var assignLock sync.Mutex //held while a range is chosen and lent
+var policy assignPolicy = newRandomPolicy() //set by -assign
+
+//what a policy knows of a monitor asking for a range
+type assignRequest struct {
+	id string
+	region string //empty if the monitor did not say
+	held map[int]int //range index to the stop set version it already has
+}
+
+//what a policy knows of a free range
+type candidate struct {
+	index int
+	stopsSize int
+	stopsVersion int
+	lastProbed time.Time //when results for it last came back, zero if never
+	regionProbes int //how many monitors of the request's region have probed it
+}
+
+//chooses which of the free ranges a monitor gets. they are in the monitor's
+//order for the round, which is index order in the first round.
+//it returns a position in free. calls are never concurrent.
+type assignPolicy interface {
+	choose(req assignRequest, free []candidate) int
+}
+
+//the policies -assign can name
+var policies = map[string]func() assignPolicy{
+	"random": newRandomPolicy,
+	"roundrobin": func() assignPolicy { return &roundRobinPolicy{} },
+	"leastrecent": func() assignPolicy { return leastRecentPolicy{} },
+	"stopreuse": func() assignPolicy { return stopReusePolicy{} },
+	"region": func() assignPolicy { return regionPolicy{} },
+}
+
+func policyNames() string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newPolicy(name string) (assignPolicy, error) {
+	makePolicy, ok := policies[name]
+	if !ok {
+		return nil, fmt.Errorf("no assignment policy %q, there are %s", name, policyNames())
+	}
+	return makePolicy(), nil
+}
+
+//the position of the candidate no other is better than, the first of equals
+func best(free []candidate, better func(a candidate, b candidate) bool) int {
+	chosen := 0
+	for i := 1; i < len(free); i++ {
+		if better(free[i], free[chosen]) {
+			chosen = i
+		}
+	}
+	return chosen
+}
+
+type randomPolicy struct {
+	rnd *rand.Rand
+}
+
+func newRandomPolicy() assignPolicy {
+	return randomPolicy{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (p randomPolicy) choose(req assignRequest, free []candidate) int {
+	return p.rnd.Intn(len(free))
+}
+
+type roundRobinPolicy struct {
+	next int //the index to start looking from
+}
+
+func (p *roundRobinPolicy) choose(req assignRequest, free []candidate) int {
+	//the lowest index from next on, or the lowest of all to wrap around
+	chosen, lowest := -1, 0
+	for i, c := range free {
+		if c.index >= p.next && (chosen < 0 || c.index < free[chosen].index) {
+			chosen = i
+		}
+		if c.index < free[lowest].index {
+			lowest = i
+		}
+	}
+	if chosen < 0 {
+		chosen = lowest
+	}
+	p.next = free[chosen].index + 1
+	return chosen
+}
+
+type leastRecentPolicy struct{}
+
+func lessRecent(a candidate, b candidate) bool {
+	return a.lastProbed.Before(b.lastProbed)
+}
+
+func (leastRecentPolicy) choose(req assignRequest, free []candidate) int {
+	return best(free, lessRecent)
+}
+
+type stopReusePolicy struct{}
+
+func (stopReusePolicy) choose(req assignRequest, free []candidate) int {
+	//how many versions behind the monitor's copy is, or -1 if it has none it can catch up from
+	behind := func(c candidate) int {
+		version, ok := req.held[c.index]
+		if !ok || version > c.stopsVersion {
+			return -1
+		}
+		return c.stopsVersion - version
+	}
+	return best(free, func(a candidate, b candidate) bool {
+		aBehind, bBehind := behind(a), behind(b)
+		switch {
+		case aBehind >= 0 && bBehind >= 0:
+			return aBehind < bBehind
+		case aBehind >= 0 || bBehind >= 0:
+			return aBehind >= 0
+		}
+		return a.stopsSize > b.stopsSize
+	})
+}
+
+type regionPolicy struct{}
+
+func (regionPolicy) choose(req assignRequest, free []candidate) int {
+	return best(free, func(a candidate, b candidate) bool {
+		if a.regionProbes != b.regionProbes {
+			return a.regionProbes < b.regionProbes
+		}
+		return lessRecent(a, b)
+	})
+}
+
+//copies the ranges a monitor has not probed yet, sorted
+func unseenBy(id string) []int {
+	indexes := seenBy(id)
+	seenRanges.lock.Lock()
+	defer seenRanges.lock.Unlock()
+	unseen := indexes.Items()
+	sort.Ints(unseen)
+	return unseen
+}
+
+//the ranges of unseen no monitor is probing, in the monitor's order for the
+//round. assignLock must be held.
+func freeRanges(req assignRequest, unseen []int) []candidate {
+	free := []candidate{}
+	for _, index := range unseen {
+		thisRange := ipTable[index]
+		thisRange.lock.Lock()
+		if thisRange.currentProbe == "" {
+			free = append(free, candidate{
+				index: index,
+				stopsSize: thisRange.stops.Set().Size(),
+				stopsVersion: thisRange.stops.Version(),
+				lastProbed: thisRange.lastProbed,
+				regionProbes: thisRange.regionProbes[req.region],
+			})
+		}
+		thisRange.lock.Unlock()
+	}
+	rounds.order(req.id, free)
+	return free
+}
+
+//given the id of a probe, finds an unseen range and returns its ip's and stop set,
+//or just the changes to it if the probe already holds an older version.
+//the table must be locked for reading.
+func findNewRange(id string, held map[int]int) ([][4]byte, stopUpdate, int, error) {
+	var update stopUpdate
+	assignLock.Lock()
+	defer assignLock.Unlock()
+	unseen := unseenBy(id)
+	if len(unseen) == 0 {
+		return nil, update, -1, fmt.Errorf("%s %w", id, errProbedAll)
+	}
+	req := assignRequest{id: id, region: contacts.regionOf(id), held: held}
+	free := freeRanges(req, unseen)
+	if len(free) == 0 { //everything in use
+		return nil, update, -1, errors.New("no free IPs")
+	}
+	index := free[policy.choose(req, free)].index
+
+	//no other call lends ranges while assignLock is held, so it is still free
+	thisRange := ipTable[index]
+	thisRange.lock.Lock()
+	defer thisRange.lock.Unlock()
+	leaseId := newLeaseId()
+	if err := state.log(walRecord{Op: OP_ASSIGN, Id: id, Index: index, LeaseId: leaseId}); err != nil {
+		return nil, update, -1, err
+	}
+	thisRange.lease(id, leaseId, time.Now()) //new owner
+	update.stops, update.full = thisRange.stops.Since(held[index])
+	update.version = thisRange.stops.Version()
+	return thisRange.addresses, update, index, nil
+}
+
+//notes that a monitor finished probing a range. the range must be locked.
+func (r *ipRange) probedBy(id string, now time.Time) {
+	r.lastProbed = now
+	if r.regionProbes == nil {
+		r.regionProbes = make(map[string]int)
+	}
+	r.regionProbes[contacts.regionOf(id)]++
+}
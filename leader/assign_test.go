@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAssignPolicies(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	free := []candidate{
+		{index: 1, stopsSize: 5, stopsVersion: 4, lastProbed: start.Add(time.Hour), regionProbes: 2},
+		{index: 3, stopsSize: 9, stopsVersion: 2, lastProbed: start, regionProbes: 1},
+		{index: 4, stopsSize: 1, stopsVersion: 7, lastProbed: start.Add(2 * time.Hour), regionProbes: 1},
+		{index: 6, stopsSize: 2, stopsVersion: 3, regionProbes: 2},
+	}
+	req := assignRequest{id: "mon1", held: map[int]int{1: 2, 4: 6, 6: 9}}
+
+	rr := &roundRobinPolicy{}
+	order := []int{}
+	for i := 0; i < 5; i++ {
+		order = append(order, free[rr.choose(req, free)].index)
+	}
+	if got := order; got[0] != 1 || got[1] != 3 || got[2] != 4 || got[3] != 6 || got[4] != 1 {
+		t.Errorf("round robin chose %v", order)
+	}
+	if got := free[leastRecentPolicy{}.choose(req, free)].index; got != 6 {
+		t.Errorf("least recent chose %d, not the unprobed range", got)
+	}
+	//range 4 is one version behind, range 1 two, and range 6 is held at a version it never had
+	if got := free[stopReusePolicy{}.choose(req, free)].index; got != 4 {
+		t.Errorf("stop reuse chose %d", got)
+	}
+	if got := free[stopReusePolicy{}.choose(assignRequest{id: "mon2"}, free)].index; got != 3 {
+		t.Errorf("stop reuse without held sets chose %d, not the biggest stop set", got)
+	}
+	if got := free[regionPolicy{}.choose(req, free)].index; got != 3 {
+		t.Errorf("region chose %d", got)
+	}
+	for i := 0; i < 20; i++ {
+		if got := newRandomPolicy().choose(req, free); got < 0 || got >= len(free) {
+			t.Fatalf("random chose position %d", got)
+		}
+	}
+}
+
+func TestAssignRegions(t *testing.T) {
+	setup(2)
+	defer func() { policy = newRandomPolicy() }()
+	policy = regionPolicy{}
+	contacts.setRegion("eu1", "eu")
+	contacts.setRegion("eu2", "eu")
+	contacts.setRegion("us1", "us")
+	probe := func(id string) int {
+		_, _, index, err := findNewRange(id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thisRange := ipTable[index]
+		thisRange.lock.Lock()
+		defer thisRange.lock.Unlock()
+		if err := acceptResult(thisRange, index, id, nil, nil, nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		return index
+	}
+
+	//range 0 was probed from the eu, then range 1 from the us. range 0 is the
+	//least recently probed, but eu2 should see range 1, not seen from the eu yet.
+	if index := probe("eu1"); index != 0 {
+		t.Fatalf("expected eu1 to get range 0, got %d", index)
+	}
+	if index := probe("us1"); index != 1 {
+		t.Fatalf("expected us1 to get range 1, got %d", index)
+	}
+	if index := probe("eu2"); index != 1 {
+		t.Errorf("eu2 was given range %d, already probed from the eu", index)
+	}
+}
+
+func TestAssignConcurrently(t *testing.T) {
+	defer func() { policy = newRandomPolicy() }()
+	for name := range policies {
+		setup(40)
+		policy, _ = newPolicy(name)
+		var wg sync.WaitGroup
+		got := make([][]int, 8)
+		for m := range got {
+			wg.Add(1)
+			go func(m int) {
+				defer wg.Done()
+				for {
+					reply := IpReply{}
+					if err := new(Leader).GetIPs(IpArgs{ProbeId: "mon" + strconv.Itoa(m), Region: strconv.Itoa(m % 2)}, &reply); err != nil {
+						return
+					}
+					got[m] = append(got[m], reply.Index)
+				}
+			}(m)
+		}
+		wg.Wait()
+		lent := map[int]bool{}
+		for _, indexes := range got {
+			for _, index := range indexes {
+				if lent[index] {
+					t.Errorf("%s: range %d was lent twice", name, index)
+				}
+				lent[index] = true
+			}
+		}
+		if len(lent) != 40 {
+			t.Errorf("%s: lent %d of 40 ranges", name, len(lent))
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+//AUTHENTICATION
+//With -enroll tokenfile, only enrolled monitors may call the leader. A monitor
+//enrolls by calling Register with the token in that file, shared with the
+//monitors out of band, and gets back its id, a session token and a secret of
+//its own. Every later RPC carries the session token, and is refused unless the
+//token is the latest one issued to the id the call claims to be from. An id
+//that is already enrolled can only enrol again with its secret, which gives
+//it a new session, so a monitor that lost its session enrolls again but no
+//one else can take its id. Revoking a monitor through the API refuses its
+//session and any new enrolment under its id. The shared token still lets
+//whoever holds it enrol under a new id, so after revoking a monitor, put a new
+//token in the -enroll file, hand it to the other monitors and reload it with
+//POST /api/enrolments/token. Monitors already enrolled keep working, since
+//their secret is all they need. Only hashes of sessions and secrets are
+//kept, and enrolments are saved with the rest of the leader's state.
+//Without -enroll, any caller is trusted, as before.
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const AUTH_FAILED = "not authenticated" //what the errors for a refused session start with
+
+var ErrAuthFailed = errors.New(AUTH_FAILED)
+
+var enrollToken []byte //nil unless -enroll is given, in which case monitors must enroll
+var enrollFile string //where enrollToken is reloaded from, set by -enroll
+var tokenLock sync.RWMutex //guards enrollToken, which the api can reload
+var adminToken []byte //nil unless -admin is given, in which case the API needs it
+var enrolled = newEnrolments()
+
+type RegisterArgs struct {
+	EnrollToken string
+	Name string //the id the monitor wants
+	Region string
+	Secret string //from the monitor's first enrolment, needed to enrol its id again
+}
+
+type RegisterReply struct {
+	Id string
+	Session string
+	Secret string //only on the first enrolment of an id, the monitor must keep it
+	Ok bool
+}
+
+//an enrolled monitor
+type enrolment struct {
+	Id string
+	Region string
+	Enrolled time.Time
+	Revoked bool
+	SessionHash [sha256.Size]byte
+	SecretHash [sha256.Size]byte //zero for enrolments from before secrets, the next enrolment sets it
+}
+
+type enrolments struct {
+	byId map[string]*enrolment
+	lock sync.Mutex
+}
+
+func newEnrolments() *enrolments {
+	return &enrolments{byId: make(map[string]*enrolment)}
+}
+
+func hashSession(session string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(session))
+}
+
+//a new session token, 256 random bits in hex
+func newSession() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+//reads a token from the first line of a file
+func readToken(path string) ([]byte, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	token := strings.TrimSpace(string(text))
+	if token == "" {
+		return nil, fmt.Errorf("%s holds no token", path)
+	}
+	return []byte(token), nil
+}
+
+func validName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '-' || r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+//the enrolment token, nil if monitors need not enroll
+func currentEnrollToken() []byte {
+	tokenLock.RLock()
+	defer tokenLock.RUnlock()
+	return enrollToken
+}
+
+//reads the enrolment token from the -enroll file again, so a revoked monitor
+//cannot use the old one to enrol under a new id
+func reloadEnrollToken() error {
+	if enrollFile == "" {
+		return errors.New("this leader does not enroll monitors")
+	}
+	token, err := readToken(enrollFile)
+	if err != nil {
+		return err
+	}
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+	enrollToken = token
+	return nil
+}
+
+//enrolls a monitor, or gives an enrolled one that proves it is the same
+//monitor a new session
+func (*Leader) Register(args RegisterArgs, reply *RegisterReply) error {
+	token := currentEnrollToken()
+	if token == nil {
+		return errors.New("this leader does not enroll monitors, call it without registering")
+	}
+	if !validName(args.Name) {
+		return fmt.Errorf("bad monitor name %q, use letters, digits, '-', '_' and '.'", args.Name)
+	}
+	session := newSession()
+	e := enrolment{Id: args.Name, Region: args.Region, Enrolled: time.Now(), SessionHash: hashSession(session)}
+	tableLock.RLock() //so no snapshot is taken between logging and applying
+	defer tableLock.RUnlock()
+	enrolled.lock.Lock()
+	defer enrolled.lock.Unlock()
+	old, known := enrolled.byId[e.Id]
+	secret := ""
+	switch {
+	case known && old.Revoked:
+		return fmt.Errorf("%w: %s was revoked", ErrAuthFailed, e.Id)
+	case known && old.SecretHash != [sha256.Size]byte{}:
+		//the same monitor again, which needs no token
+		given := hashSession(args.Secret)
+		if subtle.ConstantTimeCompare(given[:], old.SecretHash[:]) != 1 {
+			return fmt.Errorf("%w: %s is enrolled, and only its secret can enrol it again", ErrAuthFailed, e.Id)
+		}
+		e.Enrolled = old.Enrolled
+		e.SecretHash = old.SecretHash
+	default:
+		if subtle.ConstantTimeCompare([]byte(args.EnrollToken), token) != 1 {
+			return fmt.Errorf("%w: wrong enrolment token", ErrAuthFailed)
+		}
+		secret = newSession()
+		e.SecretHash = hashSession(secret)
+	}
+	if err := state.log(walRecord{Op: OP_ENROLL, Id: e.Id, Enrolment: &e}); err != nil {
+		return err
+	}
+	enrolled.byId[e.Id] = &e
+	contacts.touch(e.Id)
+	if e.Region != "" {
+		contacts.setRegion(e.Id, e.Region)
+	}
+	fmt.Println("enrolled", e.Id)
+	reply.Id = e.Id
+	reply.Session = session
+	reply.Secret = secret
+	reply.Ok = true
+	return nil
+}
+
+//checks that a call claiming to be from id carries id's session
+func authenticate(session string, id string) error {
+	if currentEnrollToken() == nil {
+		return nil
+	}
+	hash := hashSession(session)
+	enrolled.lock.Lock()
+	defer enrolled.lock.Unlock()
+	e, ok := enrolled.byId[id]
+	switch {
+	case !ok:
+		return fmt.Errorf("%w: %s is not enrolled", ErrAuthFailed, id)
+	case e.Revoked:
+		return fmt.Errorf("%w: %s was revoked", ErrAuthFailed, id)
+	case subtle.ConstantTimeCompare(hash[:], e.SessionHash[:]) != 1:
+		return fmt.Errorf("%w: not a session of %s", ErrAuthFailed, id)
+	}
+	return nil
+}
+
+//refuses a monitor's session and any new enrolment under its id
+func revokeMonitor(id string) error {
+	tableLock.RLock()
+	defer tableLock.RUnlock()
+	enrolled.lock.Lock()
+	defer enrolled.lock.Unlock()
+	e, ok := enrolled.byId[id]
+	if !ok {
+		return fmt.Errorf("%s is not enrolled", id)
+	}
+	if e.Revoked {
+		return fmt.Errorf("%s was already revoked", id)
+	}
+	if err := state.log(walRecord{Op: OP_REVOKE, Id: id}); err != nil {
+		return err
+	}
+	e.Revoked = true
+	return nil
+}
+
+//applies a logged enrolment or revocation
+func replayEnrolment(rec walRecord) {
+	enrolled.lock.Lock()
+	defer enrolled.lock.Unlock()
+	switch rec.Op {
+	case OP_ENROLL:
+		if rec.Enrolment != nil {
+			e := *rec.Enrolment
+			enrolled.byId[e.Id] = &e
+		}
+	case OP_REVOKE:
+		if e, ok := enrolled.byId[rec.Id]; ok {
+			e.Revoked = true
+		}
+	}
+}
+
+//a copy of every enrolment, sorted by id
+func (es *enrolments) list() []enrolment {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	list := make([]enrolment, 0, len(es.byId))
+	for _, e := range es.byId {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+//replaces every enrolment, from a snapshot
+func (es *enrolments) restore(list []enrolment) {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	es.byId = make(map[string]*enrolment, len(list))
+	for i := range list {
+		es.byId[list[i].Id] = &list[i]
+	}
+}
+
+//wraps an API handler so it needs the admin token, if there is one
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken != nil {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), adminToken) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, errors.New("the admin token is needed"))
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
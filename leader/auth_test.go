@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func register(t *testing.T, name string) string {
+	t.Helper()
+	return registerWith(t, RegisterArgs{EnrollToken: "letmein", Name: name}).Session
+}
+
+func registerWith(t *testing.T, args RegisterArgs) RegisterReply {
+	t.Helper()
+	reply := RegisterReply{}
+	if err := new(Leader).Register(args, &reply); err != nil || reply.Id != args.Name {
+		t.Fatalf("could not enroll %s: %v", args.Name, err)
+	}
+	return reply
+}
+
+func TestEnrolledMonitorsOnly(t *testing.T) {
+	setup(4)
+	enrollToken = []byte("letmein")
+	defer func() { enrollToken = nil }()
+
+	if err := new(Leader).Register(RegisterArgs{EnrollToken: "guess", Name: "mon1"}, &RegisterReply{}); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("enrolled with the wrong token: %v", err)
+	}
+	if err := new(Leader).Register(RegisterArgs{EnrollToken: "letmein", Name: "../mon"}, &RegisterReply{}); err == nil {
+		t.Errorf("enrolled under a bad name")
+	}
+	first := registerWith(t, RegisterArgs{EnrollToken: "letmein", Name: "mon1"})
+	session := first.Session
+	other := register(t, "mon2")
+	if first.Secret == "" {
+		t.Fatalf("no secret was given on the first enrolment")
+	}
+
+	if err := new(Leader).GetIPs(IpArgs{ProbeId: "mon1"}, &IpReply{}); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("expected a call without a session to be refused, got %v", err)
+	}
+	if err := new(Leader).GetIPs(IpArgs{ProbeId: "mon1", Session: other}, &IpReply{}); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("mon2 could call as mon1: %v", err)
+	}
+	reply := IpReply{}
+	if err := new(Leader).GetIPs(IpArgs{ProbeId: "mon1", Session: session}, &reply); err != nil || !reply.Ok {
+		t.Fatalf("an enrolled monitor was refused: %v", err)
+	}
+	renew := RenewArgs{Id: "mon1", Index: reply.Index, LeaseId: reply.LeaseId, Session: session}
+	if err := new(Leader).RenewLease(renew, &RenewReply{}); err != nil {
+		t.Errorf("could not renew: %v", err)
+	}
+
+	//only mon1's secret enrolls it again, and that replaces the session
+	if err := new(Leader).Register(RegisterArgs{EnrollToken: "letmein", Name: "mon1"}, &RegisterReply{}); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("the token alone took over mon1: %v", err)
+	}
+	again := registerWith(t, RegisterArgs{Name: "mon1", Secret: first.Secret})
+	if again.Secret != "" {
+		t.Errorf("a new secret was given to an enrolled monitor")
+	}
+	newer := again.Session
+	if err := new(Leader).RenewLease(renew, &RenewReply{}); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("an old session still works: %v", err)
+	}
+	renew.Session = newer
+	if err := new(Leader).RenewLease(renew, &RenewReply{}); err != nil {
+		t.Errorf("the new session was refused: %v", err)
+	}
+
+	if err := revokeMonitor("mon1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(Leader).RenewLease(renew, &RenewReply{}); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("a revoked monitor could still call: %v", err)
+	}
+	if err := new(Leader).Register(RegisterArgs{EnrollToken: "letmein", Name: "mon1", Secret: first.Secret}, &RegisterReply{}); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("a revoked monitor enrolled again: %v", err)
+	}
+	if list := enrolled.list(); len(list) != 2 || !list[0].Revoked || list[1].Revoked {
+		t.Errorf("unexpected enrolments %+v", list)
+	}
+}
+
+func TestEnrolmentsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	setup(2)
+	enrollToken = []byte("letmein")
+	defer func() { enrollToken = nil }()
+	var err error
+	if state, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { state = nil }()
+	session := register(t, "mon1")
+	register(t, "mon2")
+	if err := revokeMonitor("mon2"); err != nil {
+		t.Fatal(err)
+	}
+	state.wal.Close()
+
+	setup(2)
+	if state, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer state.wal.Close()
+	if err := authenticate(session, "mon1"); err != nil {
+		t.Errorf("mon1's session was lost: %v", err)
+	}
+	if list := enrolled.list(); len(list) != 2 || !list[1].Revoked {
+		t.Errorf("the revocation was lost: %+v", list)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	setup(1)
+	adminToken = []byte("s3cret")
+	defer func() { adminToken = nil }()
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	apiCall(t, mux, "GET", "/api/status", "", http.StatusUnauthorized, nil)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/enrolments", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("the admin token was refused: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRotateEnrollToken(t *testing.T) {
+	setup(1)
+	enrollFile = filepath.Join(t.TempDir(), "token")
+	defer func() { enrollFile, enrollToken = "", nil }()
+	if err := os.WriteFile(enrollFile, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadEnrollToken(); err != nil {
+		t.Fatal(err)
+	}
+	mon1 := registerWith(t, RegisterArgs{EnrollToken: "old", Name: "mon1"})
+
+	if err := os.WriteFile(enrollFile, []byte("new\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	apiCall(t, mux, "POST", "/api/enrolments/token", "", http.StatusOK, nil)
+	if err := new(Leader).Register(RegisterArgs{EnrollToken: "old", Name: "mon9"}, &RegisterReply{}); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("the old token still enrolls: %v", err)
+	}
+	registerWith(t, RegisterArgs{Name: "mon1", Secret: mon1.Secret})
+	registerWith(t, RegisterArgs{EnrollToken: "new", Name: "mon2"})
+}
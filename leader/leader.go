@@ -5,28 +5,60 @@ package main
 //For example, a monitor could be given [111 200 301] and it will map all IPS with those first 3 parts, "111.200.300.(...)"
 
 import (
+	"github.com/arieltraver/ari_traceroute/alias"
+	"github.com/arieltraver/ari_traceroute/graph"
 	"net/rpc"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"github.com/arieltraver/ari_traceroute/set"
+	"github.com/arieltraver/ari_traceroute/tlsconf"
+	"crypto/tls"
 	"time"
 	"log"
 	"errors"
+	"flag"
 	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
 const MONITORS int = 5 //number of chunks to divide file into
 const CHUNKS int = 10
-var allIPs *set.SafeSet
-var unlockPlease []chan bool
+var allIPs *set.SafeSet[[4]byte]
 var ipTable []*ipRange //here is where the global stop sets are stored
+var tableLock sync.RWMutex //held for reading while using ipTable, for writing to add ranges or save a snapshot
 var seenRanges *seenMap //keeps track of IPs and which has seen what
+var routers *alias.Groups //interfaces grouped into routers by the monitors
+var topology *graph.Graph //interfaces and the links between them, as the monitors saw them
+var stats *discoveryStats //estimates of what each monitor found, from their sketches
+var contacts = newMonitorContacts() //when each monitor last called
+var assignPaused atomic.Bool //no ranges are lent while set, by the API
+var resultsAccepted atomic.Int64
+var errNoRange = errors.New("no such range")
+var stateDir string //where the leader's state is saved, set by -state
+var snapshotEvery time.Duration //set by -snapshot
+var listenAddr string //where rpc and the api are served, set by -listen
+var tlsFlags tlsconf.Config //set by the -tls flags
+var serverTLS *tls.Config //nil unless -tlscert is given, in which case rpc and the api are served over TLS
+var exclude *set.PrefixSet //prefixes never to probe, from -exclude, nil if there are none
+var useBloom bool //stop sets are bloom filters instead of sets of keys, set by -bloom
+var useIPBitmap bool //allIPs is a 512 MB bitmap of IPv4 instead of a roaring set, set by -ipbitmap
+var stopPrefix int //destination prefix length of stop set keys, set by -stopprefix
+var stopPolicy set.AgePolicy //how long stop set entries last without being found again, set by -stopage
 
 //a pair: who's using an IP range (locked for concurrency), and also that range (locked)
 type ipRange struct {
 	addresses [][4]byte //must be the same length as stops, 1-1 correspondence.
 	currentProbe  string
-	stops *set.StringSet
+	leaseId string //the lease currentProbe holds the range under
+	leaseExpires time.Time //when currentProbe loses the range, unless it renews the lease
+	lastProbed time.Time //when results for it last came back
+	regionProbes map[string]int //how many monitors of each region have probed it
+	stops *set.VersionedSet[set.StopKey] //a Set of stop keys, a BloomFilter when -bloom is given, or an ExpiringSet when -stopage is
 	lock sync.Mutex
 }
 /*
@@ -40,10 +72,16 @@ func (i *ipRange) Size() int {
 type Leader int
 
 type ResultArgs struct {
-	NewGSS *set.StringSet
-	News *set.StringSet
+	NewGSS set.Container[set.StopKey]
+	News set.Container[[4]byte] //interfaces seen, usually a RoaringSet
+	Seen *set.HyperLogLog[[4]byte] //sketch of every interface the monitor has found, nil from older monitors
+	Sightings *set.CountMin[[4]byte] //replies per interface in this range, nil from older monitors
+	Links []graph.Link //consecutive hops the monitor saw since its last transfer
 	Id string
 	Index int
+	Session string //from Register, when the leader enrolls monitors
+	LeaseId string //empty from older monitors
+	Partial bool //the range was not finished, as its lease was lost
 }
 
 type ResultReply struct {
@@ -52,12 +90,32 @@ type ResultReply struct {
 
 type IpArgs struct {
 	ProbeId string
+	Held map[int]int //range index to the stop set version the monitor already has
+	Region string //where the monitor is, for -assign region. empty from older monitors
+	Session string
 }
 
 type IpReply struct {
 	Ips [][4]byte
-	Stops *set.StringSet
+	Stops set.Container[set.StopKey] //the whole stop set, or the changes since the held version
+	StopsFull bool
+	StopsVersion int
+	StopPrefix int //destination prefix length monitors key the stop set with
 	Index int
+	Paused bool //assignment is paused, the monitor should ask again later
+	LeaseId string
+	Deadline time.Time //when the lease runs out unless it is renewed
+	LeaseTerm time.Duration //how long the lease lasts from now
+	Ok bool
+}
+
+type AliasArgs struct {
+	Id string
+	Session string
+	Routers [][][4]byte //each entry is the interfaces of one router
+}
+
+type AliasReply struct {
 	Ok bool
 }
 
@@ -68,113 +126,279 @@ type seenMap struct {
 	lock sync.Mutex
 }
 
-//given the id of a probe, finds an unseen range and returns its ip's and stop set.
-func findNewRange(id string) ([][4]byte, *set.StringSet, int, error) {
+//the stop set of a range as a monitor will get it
+type stopUpdate struct {
+	stops set.Container[set.StopKey]
+	full bool
+	version int
+}
+
+//the ranges a monitor has not probed yet. a new monitor has all of them to probe.
+func seenBy(id string) *set.IntSet {
 	seenRanges.lock.Lock()
-	seenRanges.lock.Unlock() //TODO: lock the range but not the whole table.
-	//TODO: empty set check. return error "{id} has seen all ip ranges"
-	
+	defer seenRanges.lock.Unlock()
 	indexes, ok := seenRanges.rangesSeenBy[id]
-
-	//TODO: make registration separate?
-	if !ok { //this probe is New, register seen ip ranges for it
-		r := set.NewIntSet()
-		l := len(ipTable)
-		for i := 0; i < l; i++ {
-			r.Add(i)
+	if !ok {
+		indexes = set.NewIntSet()
+		for i := range ipTable {
+			indexes.Add(i)
 		}
-		seenRanges.rangesSeenBy[id] = r
-		indexes = seenRanges.rangesSeenBy[id]
+		seenRanges.rangesSeenBy[id] = indexes
 	}
+	return indexes
+}
 
-	//TODO make set iteratable
-	for index, _ := range(indexes.Mp) {
-		thisRange := ipTable[index] //check if each unseen range is in use
-		thisRange.lock.Lock()
-		if thisRange.currentProbe == "" { //no current owner
-			thisRange.currentProbe = id //new owner
-			stopSet := thisRange.stops //copy range of IP addresses from table
-			addressesToProbe := thisRange.addresses;
-			thisRange.lock.Unlock()
-			return addressesToProbe, stopSet, index, nil
+//adds what a monitor found in a range. the range must be locked.
+func applyResult(thisRange *ipRange, newGSS set.Container[set.StopKey], news set.Container[[4]byte], links []graph.Link) error {
+	if newGSS != nil {
+		if _, err := thisRange.stops.Apply(newGSS); err != nil { //register new (hop, dest) pairs as the range's next version
+			return err
 		}
-		thisRange.lock.Unlock()
 	}
-	//everything in use
-	return nil, nil, -1, errors.New("no free IPs")
+	if news != nil {
+		if err := allIPs.UnionWith(news); err != nil { //register all new, never-before-seen nodes
+			return err
+		}
+	}
+	topology.Add(links...)
+	return nil
+}
+
+//adds a monitor's results for a range and frees the range. the range must be locked.
+func acceptResult(thisRange *ipRange, index int, id string, newGSS set.Container[set.StopKey], news set.Container[[4]byte], links []graph.Link, now time.Time) error {
+	if err := applyResult(thisRange, newGSS, news, links); err != nil {
+		return err
+	}
+	resultsAccepted.Add(1)
+	rounds.finished()
+	thisRange.probedBy(id, now)
+	thisRange.release() //no id associated here anymore
+	indexes := seenBy(id)
+	seenRanges.lock.Lock()
+	defer seenRanges.lock.Unlock()
+	indexes.Remove(index) //done w this range!
+	return nil
 }
 
 //accepts results of a trace from a node.
 func (*Leader) TransferResults(args ResultArgs, reply *ResultReply) error {
-	
+	defer rpcDuration.Since(time.Now(), "TransferResults")
+	if err := authenticate(args.Session, args.Id); err != nil {
+		return err
+	}
+	contacts.touch(args.Id)
+	tableLock.RLock()
+	defer tableLock.RUnlock()
+	if args.Index < 0 || args.Index >= len(ipTable) {
+		return errors.New("no such range")
+	}
 	thisRange := ipTable[args.Index] //look in the table for the ip range
 	thisRange.lock.Lock()
 	defer thisRange.lock.Unlock()
 
-	//check if this node actually was registered with this range.
-	rangeOwner := thisRange.currentProbe
-	if rangeOwner != args.Id {
+	//check if this node still holds the lease on this range.
+	now := time.Now()
+	if err := thisRange.holds(args.Index, args.Id, args.LeaseId, now); err != nil && !args.Partial {
 		reply.Ok = false
-		if rangeOwner == "" {
-			return errors.New("you took too long")
+		return err
+	}
+	for i := range args.Links { //monitors only vouch for their own links
+		args.Links[i].Monitor = args.Id
+		if args.Links[i].At.IsZero() {
+			args.Links[i].At = now
 		}
-		return errors.New("ips in use by other probe")
 	}
-	fmt.Println("result:", args.NewGSS.ToCSV()) //TODO: remove this test
-	thisRange.currentProbe = "" //no id associated here anymore
-	thisRange.stops.UnionWith(args.NewGSS) //register new (hop, dest) pairs to this range of IPs
-	allIPs.UnionWith(args.News) //register all new, never-before-seen nodes
-	seenRanges.lock.Lock()
-	defer seenRanges.lock.Unlock()
-	seenRanges.rangesSeenBy[args.Id].Remove(args.Index) //done w this range!
-
-	//TODO register new edges in some kind of graph data structure
-
-	unlockPlease[args.Index] <- true //request to unlock this set, a routine is listening.
+	op := OP_RESULT
+	if args.Partial {
+		op = OP_PARTIAL
+	}
+	//saved before it is applied, so a result the monitor was told about is never lost
+	if err := state.log(walRecord{Op: op, Id: args.Id, Index: args.Index, LeaseId: args.LeaseId, NewGSS: args.NewGSS, News: args.News, Links: args.Links, Seen: args.Seen, Sightings: args.Sightings, At: now}); err != nil {
+		return err
+	}
+	if args.Partial {
+		if err := acceptPartial(thisRange, args.Index, args.Id, args.LeaseId, args.NewGSS, args.News, args.Links); err != nil {
+			return err
+		}
+		rangesPartial.Inc()
+	} else {
+		if err := acceptResult(thisRange, args.Index, args.Id, args.NewGSS, args.News, args.Links, now); err != nil {
+			return err
+		}
+		rangesCompleted.Inc()
+	}
+	//the result is in either way, so a bad sketch only costs the statistics
+	if err := stats.record(args.Id, args.Seen, args.Sightings); err != nil {
+		log.Printf("left out the sketches %s sent: %v\n", args.Id, err)
+	}
 	reply.Ok = true
 	return nil
 }
 
 //RPC which assigns a range of IP's to a monitor, depending on which are free.
 func (*Leader) GetIPs(args IpArgs, reply *IpReply) error {
-	ips, stops, index, er := findNewRange(args.ProbeId)
+	defer rpcDuration.Since(time.Now(), "GetIPs")
+	if err := authenticate(args.Session, args.ProbeId); err != nil {
+		return err
+	}
+	contacts.touch(args.ProbeId)
+	if args.Region != "" {
+		contacts.setRegion(args.ProbeId, args.Region)
+	}
+	if assignPaused.Load() {
+		reply.Paused = true
+		return nil
+	}
+	tableLock.RLock()
+	defer tableLock.RUnlock()
+	ips, update, index, er := findNewRange(args.ProbeId, args.Held)
+	if errors.Is(er, errProbedAll) { //there is more to probe next round
+		reply.Paused = true
+		return nil
+	}
 	if er != nil {
 		reply.Ok = false
 		return errors.New("could not find new IP range for that node.")
 	}
+	rangesAssigned.Inc()
 	fmt.Println("ip range is:", ips)
 	reply.Ips = ips //node gets this
-	reply.Stops = stops
+	reply.Stops = update.stops
+	reply.StopsFull = update.full
+	reply.StopsVersion = update.version
+	reply.StopPrefix = stopPrefix
 	reply.Index = index
+	thisRange := ipTable[index]
+	thisRange.lock.Lock()
+	reply.LeaseId = thisRange.leaseId
+	reply.Deadline = thisRange.leaseExpires
+	thisRange.lock.Unlock()
+	reply.LeaseTerm = leaseTerm
 	reply.Ok = true
-	fmt.Println("index selected:", index, "for", args.ProbeId)
-	go waitOnProbe(args.ProbeId, index) //wait for probe to either time out, or finish.
+	fmt.Println("index selected:", index, "for", args.ProbeId, "until", reply.Deadline.Format(time.TimeOnly))
 	return nil
 }
 
-/*Waits for a probe to return. If it doesn't return in time, it frees up its range.*/
-func waitOnProbe(probeId string, index int) error {
-	probeTimer := time.NewTimer(90 * time.Second)
-	for {
-		select {
-		case <- unlockPlease[index]: //second http request occured, result stored
-			fmt.Println(allIPs.ToCSV()) //TODO remove, this is test
-		case <- probeTimer.C:
-			log.Println("probe took too long")
-			go freeRange(index) //free the range, change the id in case the probe comes back later
-			return errors.New("probe timeout")
-		}
+//accepts routers found by a monitor's alias resolution, merging any that share an interface.
+func (*Leader) TransferAliases(args AliasArgs, reply *AliasReply) error {
+	if err := authenticate(args.Session, args.Id); err != nil {
+		return err
 	}
+	contacts.touch(args.Id)
+	tableLock.RLock() //so no snapshot is taken between logging and merging
+	defer tableLock.RUnlock()
+	if err := state.log(walRecord{Op: OP_ALIASES, Id: args.Id, Routers: args.Routers}); err != nil {
+		return err
+	}
+	routers.Merge(args.Routers)
+	fmt.Println(args.Id, "sent", len(args.Routers), "routers")
+	reply.Ok = true
+	return nil
+}
+
+//the topology with every interface replaced by the router it belongs to,
+//named by its lowest address. interfaces on no known router stand for themselves.
+func routerGraph() *graph.Graph {
+	return topology.Collapse(routers.Name)
 }
 
-//frees up a range lent to a monitor that timed out, removing that monitor's id.
-func freeRange(index int) {
-	thisRange := ipTable[index] //look in the table for the ip range
+//takes a range back from the monitor probing it, whose next renewal or results
+//will be told the lease was lost. it returns the monitor's id.
+func revokeLease(index int) (string, error) {
+	tableLock.RLock()
+	defer tableLock.RUnlock()
+	if index < 0 || index >= len(ipTable) {
+		return "", errNoRange
+	}
+	thisRange := ipTable[index]
 	thisRange.lock.Lock()
 	defer thisRange.lock.Unlock()
-	thisRange.currentProbe = ""
+	owner := thisRange.currentProbe
+	if owner == "" {
+		return "", errors.New("range is not lent out")
+	}
+	if err := state.log(walRecord{Op: OP_FREE, Id: owner, Index: index}); err != nil {
+		return "", err
+	}
+	thisRange.release()
+	rangesRevoked.Inc()
+	return owner, nil
+}
+
+//adds ranges to probe while the leader runs, returning the index of the first
+func addRanges(ranges [][][4]byte) (int, error) {
+	tableLock.Lock()
+	defer tableLock.Unlock()
+	if err := state.log(walRecord{Op: OP_ADD, Ranges: ranges}); err != nil {
+		return -1, err
+	}
+	return appendRanges(ranges), nil
 }
 
+//adds ranges to the table, which every monitor has yet to probe. the table must be
+//locked for writing, or not in use yet.
+func appendRanges(ranges [][][4]byte) int {
+	first := len(ipTable)
+	for _, addresses := range ranges {
+		stopz := set.NewVersionedSet(newStopSet(), set.DEFAULT_HISTORY)
+		ipTable = append(ipTable, &ipRange{addresses:addresses, stops:stopz})
+	}
+	seenRanges.lock.Lock()
+	defer seenRanges.lock.Unlock()
+	for _, indexes := range seenRanges.rangesSeenBy {
+		for i := first; i < len(ipTable); i++ {
+			indexes.Add(i)
+		}
+	}
+	return first
+}
+
+//an empty stop set in the representation chosen at startup.
+//a bloom filter keeps the transfer to a monitor under ~15 KB, as in the Doubletree paper.
+func newStopSet() set.Container[set.StopKey] {
+	if useBloom {
+		return set.NewStopSetBloom()
+	}
+	if stopPolicy != (set.AgePolicy{}) {
+		stops := set.NewExpiringSet[set.StopKey](stopPolicy)
+		stops.SetRound(rounds.current())
+		return stops
+	}
+	return set.NewSet[set.StopKey]()
+}
+
+//an empty set of interfaces in the representation chosen at startup
+func newIPSet() set.Container[[4]byte] {
+	if useIPBitmap {
+		return set.NewIPv4Set()
+	}
+	return set.NewRoaringSet()
+}
+
+//every so often, drops the stop set entries that have expired.
+//monitors holding a range whose entries expired get its whole stop set next time.
+func compactStops(every time.Duration) {
+	for range time.Tick(every) {
+		compactAll()
+	}
+}
+
+//drops expired entries from every stop set. the table stays locked so no snapshot encodes a set midway.
+func compactAll() int {
+	tableLock.RLock()
+	defer tableLock.RUnlock()
+	total := 0
+	for index, thisRange := range ipTable {
+		thisRange.lock.Lock()
+		dropped := thisRange.stops.Compact()
+		thisRange.lock.Unlock()
+		if dropped > 0 {
+			log.Printf("dropped %d expired stop set entries from range %d\n", dropped, index)
+		}
+		total += dropped
+	}
+	return total
+}
 
 //set up http server
 func connect(port string) {
@@ -184,30 +408,151 @@ func connect(port string) {
 		log.Fatal("error registering the RPCs", err)
 	}
 	rpc.HandleHTTP()
+	registerAPI(http.DefaultServeMux)
+	registerMetrics(http.DefaultServeMux)
+	if serverTLS != nil {
+		server := &http.Server{Addr: port, TLSConfig: serverTLS}
+		go func() {
+			log.Fatal(server.ListenAndServeTLS("", ""))
+		}()
+		log.Printf("serving rpc and the api over TLS on port " + port)
+		return
+	}
 	go http.ListenAndServe(port, nil)
-	log.Printf("serving rpc on port " + port)
+	log.Printf("serving rpc and the api on port " + port)
 }
 
-func test(numRanges int) {
-	ipTable = make([]*ipRange,numRanges)
+//numRanges ranges of one made up address each, for testing
+func dummyRanges(numRanges int) [][][4]byte {
+	ranges := make([][][4]byte, numRanges)
 	for i := 0; i < numRanges; i++ {
-		b := make([][4]byte, 1)
-		b[0] = [4]byte{byte(i), byte(i), byte(i), byte(i)}
-		stopz := set.NewStringSet()
-		ipTable[i] = &ipRange{addresses:b, stops:stopz, currentProbe:""}
+		ranges[i] = [][4]byte{{byte(i), byte(i), byte(i), byte(i)}}
+	}
+	return ranges
+}
+
+//fresh state for a campaign over numRanges ranges
+func setup(numRanges int) {
+	setupRanges(dummyRanges(numRanges))
+}
+
+//fresh state for a campaign over the given ranges of targets
+func setupRanges(ranges [][][4]byte) {
+	rounds = newRoundLog() //first, as new stop sets are in its round
+	contacts = newMonitorContacts()
+	numRanges := len(ranges)
+	ipTable = make([]*ipRange,numRanges)
+	for i, addresses := range ranges {
+		stopz := set.NewVersionedSet(newStopSet(), set.DEFAULT_HISTORY)
+		ipTable[i] = &ipRange{addresses:addresses, stops:stopz, currentProbe:""}
 	}
 	seen := make(map[string]*set.IntSet)
 	seenRanges = &seenMap{rangesSeenBy:seen} //TODO make this readable
-	allIPs = set.NewSafeStringSet()
-	unlockPlease = make([]chan bool, numRanges)
-	for i, _ := range(unlockPlease) {
-		unlockPlease[i] = make(chan bool, 1)
-	}
-	go connect("localhost:4000")
-	time.Sleep(120 * time.Second)
+	allIPs = set.NewSafeSet(newIPSet())
+	routers = alias.NewGroups()
+	topology = graph.New()
+	stats = newDiscoveryStats()
+	enrolled = newEnrolments()
+}
 
+func test(ranges [][][4]byte) {
+	setupRanges(ranges)
+	if stateDir != "" {
+		var err error
+		if state, err = openStore(stateDir); err != nil {
+			log.Fatal("could not restore the leader's state: ", err)
+		}
+		go state.snapshotEvery(snapshotEvery)
+	}
+	if stopPolicy.MaxAge > 0 {
+		go compactStops(stopPolicy.MaxAge / 4)
+	}
+	go reapLeases(REAP_EVERY)
+	go scheduleRounds(roundEvery)
+	go connect(listenAddr)
+	//serve until interrupted, then report what was found
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	sig := <-stop
+	log.Println("shutting down on", sig)
+	if state != nil {
+		if err := state.snapshot(); err != nil {
+			log.Println("could not save a last snapshot:", err)
+		}
+	}
+	fmt.Print(stats.report())
+	nodes, edges := topology.Size()
+	fmt.Println("the topology has", nodes, "interfaces and", edges, "links")
+	nodes, edges = routerGraph().Size()
+	fmt.Println("between routers, it has", nodes, "nodes and", edges, "links")
 }
 
 func main() {
-	test(10)
+	flag.BoolVar(&useBloom, "bloom", false, "send stop sets as bloom filters instead of sets of keys")
+	flag.IntVar(&stopPrefix, "stopprefix", set.EXACT_PREFIX, "key stop sets by this prefix of the destination, so one entry covers the whole prefix")
+	flag.BoolVar(&useIPBitmap, "ipbitmap", false, "keep discovered interfaces in a 512 MB bitmap with one bit per IPv4 address")
+	flag.DurationVar(&stopPolicy.MaxAge, "stopage", 0, "drop stop set entries not found again within this long, e.g. 6h. 0 keeps them forever")
+	flag.IntVar(&stopPolicy.MaxRounds, "stoprounds", 0, "drop stop set entries not found again for this many rounds. 0 keeps them forever")
+	flag.DurationVar(&roundEvery, "round", 0, "start a new round of the campaign this long after the last even if it is not done, e.g. 24h. 0 waits for every monitor to probe every range")
+	flag.StringVar(&roundStops, "roundstops", ROUND_SEED, "how a new round's stop sets start: "+ROUND_SEED+" with what earlier rounds found, or "+ROUND_EMPTY)
+	flag.StringVar(&archiveDir, "archive", "", "archive each round's state in this directory. the default is the rounds directory under -state, if it is given")
+	flag.StringVar(&listenAddr, "listen", "localhost:4000", "address to serve rpc and the api on")
+	tlsFlags.AddFlags(flag.CommandLine, "monitor")
+	flag.StringVar(&stateDir, "state", "", "save the leader's state in this directory and restore it on restart")
+	flag.StringVar(&enrollFile, "enroll", "", "file holding the token monitors must enroll with. without it, any caller is trusted")
+	adminFile := flag.String("admin", "", "file holding the bearer token the HTTP API needs. without it, the API is open")
+	policyName := flag.String("assign", DEFAULT_POLICY, "how to choose the range a monitor gets: "+policyNames())
+	flag.DurationVar(&leaseTerm, "lease", DEFAULT_LEASE_TERM, "how long a monitor holds a range without renewing its lease")
+	flag.DurationVar(&snapshotEvery, "snapshot", time.Minute, "with -state, how often to save a whole snapshot and start the log over")
+	targetFiles := flag.String("targets", "", "comma separated files of addresses, CIDR blocks, ISI hitlists or ZMap output to probe. without it, 10 made up ranges are used")
+	excludeFile := flag.String("exclude", "", "file of prefixes never to probe")
+	rangeSize := flag.Int("rangesize", 0, "targets per range. 0 splits them into -ranges ranges")
+	rangeCount := flag.Int("ranges", CHUNKS, "how many ranges to split the targets into, if -rangesize is 0")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed for shuffling the targets")
+	flag.Parse()
+	var err error
+	if policy, err = newPolicy(*policyName); err != nil {
+		log.Fatal(err)
+	}
+	if enrollFile != "" {
+		if enrollToken, err = readToken(enrollFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *adminFile != "" {
+		if adminToken, err = readToken(*adminFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if tlsFlags.Enabled() {
+		if serverTLS, err = tlsFlags.Server(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := validRoundStops(roundStops); err != nil {
+		log.Fatal(err)
+	}
+	if useBloom && stopPolicy != (set.AgePolicy{}) {
+		log.Fatal("-stopage and -stoprounds need sets of keys, a bloom filter cannot forget entries")
+	}
+	if *excludeFile != "" {
+		text, err := os.ReadFile(*excludeFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if exclude, err = set.ParsePrefixSet(string(text)); err != nil {
+			log.Fatal(*excludeFile, ": ", err)
+		}
+	}
+	if *targetFiles == "" {
+		test(dummyRanges(10))
+		return
+	}
+	targets, err := loadTargets(strings.Split(*targetFiles, ","), exclude)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ranges := partition(targets, *rangeSize, *rangeCount, rand.New(rand.NewSource(*seed)))
+	fmt.Println("probing", len(targets), "targets in", len(ranges), "ranges")
+	test(ranges)
 }
\ No newline at end of file
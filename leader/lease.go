@@ -0,0 +1,154 @@
+package main
+
+//LEASES
+//A monitor holds the range it probes under a lease. GetIPs gives it the
+//lease's id and deadline, and the monitor calls RenewLease well before the
+//deadline for as long as it is probing, so a large range is not taken away
+//from a monitor that is still working on it. A lease that is not renewed in
+//time is taken back by reapLeases and the range goes to the next monitor
+//that asks. Results sent under a lease that was lost get ErrLeaseLost, after
+//which the monitor may send them again as partial results: they add to the
+//range's stop set, interfaces and links, but the range is not finished.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/arieltraver/ari_traceroute/graph"
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+const DEFAULT_LEASE_TERM = 90 * time.Second //how long a lease lasts without being renewed
+const REAP_EVERY = time.Second //how often expired leases are looked for
+const LEASE_LOST = "lease lost" //what the errors monitors get for a lost lease start with
+
+var leaseTerm = DEFAULT_LEASE_TERM //set by -lease
+
+//the error for results or renewals under a lease the monitor no longer holds.
+//over RPC it reaches the monitor as a string starting with LEASE_LOST.
+var ErrLeaseLost = errors.New(LEASE_LOST)
+
+type RenewArgs struct {
+	Id string
+	Index int
+	LeaseId string
+	Session string
+}
+
+type RenewReply struct {
+	Deadline time.Time
+	Term time.Duration //how long the lease lasts from now, which does not depend on the clocks agreeing
+	Ok bool
+}
+
+func newLeaseId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+//lends a range to a monitor under the lease leaseId. the range must be locked.
+func (r *ipRange) lease(id string, leaseId string, now time.Time) {
+	r.currentProbe = id
+	r.leaseId = leaseId
+	r.leaseExpires = now.Add(leaseTerm)
+}
+
+//takes a range back from its monitor. the range must be locked.
+func (r *ipRange) release() {
+	r.currentProbe = ""
+	r.leaseId = ""
+	r.leaseExpires = time.Time{}
+}
+
+//whether id holds the range under leaseId, or under any lease if leaseId is empty. the range must be locked.
+func (r *ipRange) heldBy(id string, leaseId string) bool {
+	return r.currentProbe == id && (leaseId == "" || leaseId == r.leaseId)
+}
+
+//whether a monitor still holds the lease on a range. monitors from before
+//leases send no lease id and are only checked by their id. the range must be locked.
+func (r *ipRange) holds(index int, id string, leaseId string, now time.Time) error {
+	switch {
+	case r.currentProbe == "":
+		return fmt.Errorf("%w: range %d is not lent out, the lease ran out or was revoked", ErrLeaseLost, index)
+	case !r.heldBy(id, leaseId):
+		return fmt.Errorf("%w: range %d is lent to another monitor", ErrLeaseLost, index)
+	case now.After(r.leaseExpires):
+		return fmt.Errorf("%w: the lease on range %d ran out", ErrLeaseLost, index)
+	}
+	return nil
+}
+
+//extends a monitor's lease on the range it is probing
+func (*Leader) RenewLease(args RenewArgs, reply *RenewReply) error {
+	if err := authenticate(args.Session, args.Id); err != nil {
+		return err
+	}
+	contacts.touch(args.Id)
+	tableLock.RLock()
+	defer tableLock.RUnlock()
+	if args.Index < 0 || args.Index >= len(ipTable) {
+		return errNoRange
+	}
+	thisRange := ipTable[args.Index]
+	thisRange.lock.Lock()
+	defer thisRange.lock.Unlock()
+	now := time.Now()
+	if err := thisRange.holds(args.Index, args.Id, args.LeaseId, now); err != nil {
+		return err
+	}
+	thisRange.leaseExpires = now.Add(leaseTerm)
+	leasesRenewed.Inc()
+	reply.Deadline = thisRange.leaseExpires
+	reply.Term = leaseTerm
+	reply.Ok = true
+	return nil
+}
+
+//frees the ranges whose leases ran out before now, returning how many
+func reapExpired(now time.Time) int {
+	tableLock.RLock()
+	defer tableLock.RUnlock()
+	reaped := 0
+	for index, thisRange := range ipTable {
+		thisRange.lock.Lock()
+		if thisRange.currentProbe != "" && now.After(thisRange.leaseExpires) {
+			if err := state.log(walRecord{Op: OP_FREE, Id: thisRange.currentProbe, Index: index}); err != nil {
+				log.Println("could not save a freed range:", err)
+			}
+			log.Printf("the lease of %s on range %d ran out\n", thisRange.currentProbe, index)
+			thisRange.release()
+			rangesExpired.Inc()
+			reaped++
+		}
+		thisRange.lock.Unlock()
+	}
+	return reaped
+}
+
+//takes back expired leases every so often
+func reapLeases(every time.Duration) {
+	for range time.Tick(every) {
+		reapExpired(time.Now())
+	}
+}
+
+//adds what a monitor found in a range it did not finish. if it still held the
+//range under the same lease, the range is freed for another monitor, and stays
+//unprobed for this one. the range must be locked.
+func acceptPartial(thisRange *ipRange, index int, id string, leaseId string, newGSS set.Container[set.StopKey], news set.Container[[4]byte], links []graph.Link) error {
+	if err := applyResult(thisRange, newGSS, news, links); err != nil {
+		return err
+	}
+	if thisRange.heldBy(id, leaseId) {
+		thisRange.release()
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+func TestLeaseRenewAndExpire(t *testing.T) {
+	setup(2)
+	reply := IpReply{}
+	if err := new(Leader).GetIPs(IpArgs{ProbeId: "mon1"}, &reply); err != nil || reply.LeaseId == "" || reply.LeaseTerm != leaseTerm {
+		t.Fatalf("expected a lease, got %+v %v", reply, err)
+	}
+	renewed := RenewReply{}
+	if err := new(Leader).RenewLease(RenewArgs{Id: "mon1", Index: reply.Index, LeaseId: reply.LeaseId}, &renewed); err != nil || !renewed.Ok {
+		t.Fatalf("could not renew: %v", err)
+	}
+	if renewed.Deadline.Before(reply.Deadline) {
+		t.Errorf("renewing moved the deadline back from %v to %v", reply.Deadline, renewed.Deadline)
+	}
+	err := new(Leader).RenewLease(RenewArgs{Id: "mon1", Index: reply.Index, LeaseId: "stale"}, &RenewReply{})
+	if !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("a stale lease id should be lost, got %v", err)
+	}
+
+	if reaped := reapExpired(time.Now()); reaped != 0 {
+		t.Errorf("reaped %d leases before they ran out", reaped)
+	}
+	if reaped := reapExpired(renewed.Deadline.Add(time.Second)); reaped != 1 || ipTable[reply.Index].currentProbe != "" {
+		t.Errorf("the lease should have run out")
+	}
+	err = new(Leader).RenewLease(RenewArgs{Id: "mon1", Index: reply.Index, LeaseId: reply.LeaseId}, &RenewReply{})
+	if !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("renewing a reaped lease should fail, got %v", err)
+	}
+}
+
+func TestLateResultsArePartial(t *testing.T) {
+	setup(2)
+	reply := IpReply{}
+	if err := new(Leader).GetIPs(IpArgs{ProbeId: "mon1"}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := revokeLease(reply.Index); err != nil {
+		t.Fatal(err)
+	}
+	stops := set.NewSet[set.StopKey]()
+	stops.Add(set.NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 1}))
+	news := set.NewRoaringSet()
+	news.Add([4]byte{10, 0, 0, 1})
+	args := ResultArgs{NewGSS: stops, News: news, Id: "mon1", Index: reply.Index, LeaseId: reply.LeaseId}
+	if err := new(Leader).TransferResults(args, &ResultReply{}); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected the lease to be lost, got %v", err)
+	}
+
+	args.Partial = true
+	result := ResultReply{}
+	if err := new(Leader).TransferResults(args, &result); err != nil || !result.Ok {
+		t.Fatalf("partial results were refused: %v", err)
+	}
+	if !allIPs.Contains([4]byte{10, 0, 0, 1}) || !ipTable[reply.Index].stops.Set().Contains(stops.Items()[0]) {
+		t.Errorf("partial results were not added")
+	}
+	if !seenBy("mon1").Contains(reply.Index) {
+		t.Errorf("a partly probed range should be left for mon1 to finish")
+	}
+
+	//mon1 holds the range again, and the partial results of its old lease come late
+	thisRange := ipTable[reply.Index]
+	thisRange.lock.Lock()
+	thisRange.lease("mon1", newLeaseId(), time.Now())
+	thisRange.lock.Unlock()
+	if err := new(Leader).TransferResults(args, &result); err != nil {
+		t.Fatal(err)
+	}
+	if thisRange.currentProbe != "mon1" {
+		t.Errorf("late partial results freed a newer lease")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+//METRICS
+//The leader's metrics, served in the OpenMetrics format at /metrics on the
+//RPC port for Prometheus to scrape.
+
+import (
+	"net/http"
+
+	"github.com/arieltraver/ari_traceroute/metrics"
+)
+
+var (
+	rangesAssigned = metrics.Default.Counter("leader_ranges_assigned", "Ranges lent to monitors.")
+	rangesCompleted = metrics.Default.Counter("leader_ranges_completed", "Ranges whose results were accepted.")
+	rangesExpired = metrics.Default.Counter("leader_ranges_expired", "Leases that ran out before their results came back.")
+	rangesRevoked = metrics.Default.Counter("leader_ranges_revoked", "Leases taken back through the API.")
+	rangesPartial = metrics.Default.Counter("leader_ranges_partial", "Partial results sent for ranges whose leases were lost.")
+	leasesRenewed = metrics.Default.Counter("leader_leases_renewed", "Leases renewed by monitors still probing.")
+	rpcDuration = metrics.Default.Histogram("leader_rpc_duration_seconds", "Time taken to answer monitors' RPCs.", nil, "method")
+)
+
+func init() {
+	metrics.Default.GaugeFunc("leader_ranges", "Ranges in the campaign.", func() float64 {
+		tableLock.RLock()
+		defer tableLock.RUnlock()
+		return float64(len(ipTable))
+	})
+	metrics.Default.GaugeFunc("leader_stop_set_entries", "Entries in the stop sets of all ranges.", func() float64 {
+		total, _ := stopSetSizes()
+		return float64(total)
+	})
+	metrics.Default.GaugeFunc("leader_stop_set_largest_entries", "Entries in the largest stop set of a range.", func() float64 {
+		_, largest := stopSetSizes()
+		return float64(largest)
+	})
+	metrics.Default.GaugeFunc("leader_interfaces", "Distinct interfaces found by all monitors.", func() float64 {
+		if allIPs == nil {
+			return 0
+		}
+		return float64(allIPs.Size())
+	})
+	metrics.Default.GaugeFunc("leader_links", "Distinct links between interfaces.", func() float64 {
+		if topology == nil {
+			return 0
+		}
+		_, edges := topology.Size()
+		return float64(edges)
+	})
+	metrics.Default.GaugeFunc("leader_round", "The round of the campaign being probed.", func() float64 {
+		return float64(rounds.current())
+	})
+	metrics.Default.GaugeFunc("leader_assignment_paused", "1 while no ranges are lent out.", func() float64 {
+		if assignPaused.Load() {
+			return 1
+		}
+		return 0
+	})
+}
+
+//the total and largest sizes of the ranges' stop sets
+func stopSetSizes() (int, int) {
+	tableLock.RLock()
+	defer tableLock.RUnlock()
+	total, largest := 0, 0
+	for _, thisRange := range ipTable {
+		thisRange.lock.Lock()
+		size := thisRange.stops.Set().Size()
+		thisRange.lock.Unlock()
+		total += size
+		if size > largest {
+			largest = size
+		}
+	}
+	return total, largest
+}
+
+//adds /metrics to mux
+func registerMetrics(mux *http.ServeMux) {
+	mux.Handle("/metrics", metrics.Default.Handler())
+}
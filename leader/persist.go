@@ -0,0 +1,435 @@
+package main
+
+//PERSISTENCE
+//With -state dir, every change to the leader's state is appended to a
+//write-ahead log before it takes effect, and synced to disk before a monitor
+//is told its results were accepted. Every so often the whole state is written
+//to a snapshot and the log starts over. On restart the snapshot is loaded, the
+//log is replayed on top of it, and the leases that were open at the crash are
+//expired, since their monitors have no way to know the leader came back.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/arieltraver/ari_traceroute/alias"
+	"github.com/arieltraver/ari_traceroute/graph"
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+const WAL_FILE = "leader.wal"
+const SNAPSHOT_FILE = "leader.snap"
+const MAX_RECORD = 1 << 30 //longest log record read back, a sanity check on the length
+
+//what a log record does
+const (
+	OP_ASSIGN = "assign" //a range was lent to a monitor
+	OP_RESULT = "result" //a monitor's results for its range were accepted
+	OP_FREE = "free" //a lease ran out or was revoked
+	OP_PARTIAL = "partial" //a monitor's results for a range it did not finish were added
+	OP_ADD = "add" //ranges were added
+	OP_ENROLL = "enroll" //a monitor enrolled, or got a new session
+	OP_REVOKE = "revoke" //a monitor's enrolment was revoked
+	OP_ROUND = "round" //a new round of the campaign started
+	OP_ALIASES = "aliases" //a monitor sent routers found by alias resolution
+)
+
+//one change to the leader's state
+type walRecord struct {
+	Seq uint64
+	Op string
+	Id string
+	Index int
+	LeaseId string //for OP_ASSIGN and OP_PARTIAL
+	NewGSS set.Container[set.StopKey] //for OP_RESULT and OP_PARTIAL
+	News set.Container[[4]byte] //for OP_RESULT and OP_PARTIAL
+	Links []graph.Link //for OP_RESULT and OP_PARTIAL
+	Seen *set.HyperLogLog[[4]byte] //for OP_RESULT and OP_PARTIAL, the monitor's sketches
+	Sightings *set.CountMin[[4]byte] //for OP_RESULT and OP_PARTIAL
+	Ranges [][][4]byte //for OP_ADD
+	Routers [][][4]byte //for OP_ALIASES
+	Enrolment *enrolment //for OP_ENROLL
+	Round int //for OP_ROUND, the round started
+	Seed int64 //for OP_ROUND
+	Stops string //for OP_ROUND
+	Archive string //for OP_ROUND, where the last round was archived
+	At time.Time //for OP_ROUND, and for OP_RESULT when the results came back. zero in older records
+}
+
+//one range as it is saved
+type rangeSnapshot struct {
+	Addresses [][4]byte
+	Owner string
+	Stops set.Container[set.StopKey]
+	StopsVersion int
+	LastProbed time.Time
+	RegionProbes map[string]int
+}
+
+//the whole state as it is saved
+type snapshot struct {
+	Seq uint64 //the last log record included
+	Ranges []rangeSnapshot
+	SeenBy map[string][]int //monitor id to the ranges it has not probed yet
+	AllIPs set.Container[[4]byte]
+	Topology *graph.Graph
+	Routers [][][4]byte //interfaces grouped into routers, nil in snapshots from before they were saved
+	Enrolments []enrolment
+	Round int //0 in snapshots from before rounds
+	RoundSeed int64
+	RoundStarted time.Time
+	RoundStops string
+	RoundResults int64
+	Rounds []roundSummary //the rounds that ended
+	Found map[string]*set.HyperLogLog[[4]byte] //what each monitor found this round, nil in older snapshots
+	Sightings *set.CountMin[[4]byte] //replies per interface this round
+}
+
+//the log and snapshots in a directory
+type store struct {
+	dir string
+	wal *os.File
+	seq uint64
+	appendLock sync.Mutex //held while appending to the log
+}
+
+var state *store //nil unless -state is given, in which case nothing is saved
+
+//opens the state in dir, restoring the globals from it if there is any.
+//otherwise it saves the globals as they are.
+func openStore(dir string) (*store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &store{dir: dir}
+	restored, err := s.restore()
+	if err != nil {
+		return nil, err
+	}
+	s.wal, err = os.OpenFile(filepath.Join(dir, WAL_FILE), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if restored {
+		expireLeases()
+	}
+	//the log starts over from a snapshot of what was restored
+	if err := s.snapshot(); err != nil {
+		s.wal.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+//appends a record to the log and syncs it. nothing is saved without a store.
+func (s *store) log(rec walRecord) error {
+	if s == nil {
+		return nil
+	}
+	s.appendLock.Lock()
+	defer s.appendLock.Unlock()
+	rec.Seq = s.seq + 1
+	payload := &bytes.Buffer{}
+	if err := gob.NewEncoder(payload).Encode(&rec); err != nil {
+		return err
+	}
+	//each record is its length, a checksum, and a gob stream of its own,
+	//so a torn write at the end is found and later runs can append.
+	frame := binary.AppendUvarint(nil, uint64(payload.Len()))
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(payload.Bytes()))
+	frame = append(frame, payload.Bytes()...)
+	if _, err := s.wal.Write(frame); err != nil {
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.seq = rec.Seq
+	return nil
+}
+
+//reads the records of a log, stopping at the first that is cut short or
+//corrupt, which a crash in the middle of a write leaves. it returns how many
+//bytes were good.
+func readLog(r io.Reader, fn func(walRecord)) (int64, error) {
+	br := bufio.NewReader(r)
+	var good int64
+	for {
+		length, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return good, nil
+		}
+		if err != nil || length > MAX_RECORD {
+			return good, nil
+		}
+		var sum [4]byte
+		if _, err := io.ReadFull(br, sum[:]); err != nil {
+			return good, nil
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return good, nil
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(sum[:]) {
+			return good, nil
+		}
+		var rec walRecord
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec); err != nil {
+			return good, err
+		}
+		fn(rec)
+		good += int64(len(binary.AppendUvarint(nil, length))) + 4 + int64(length)
+	}
+}
+
+//loads the snapshot and replays the log, reporting whether there was anything to restore
+func (s *store) restore() (bool, error) {
+	restored := false
+	file, err := os.Open(filepath.Join(s.dir, SNAPSHOT_FILE))
+	if err == nil {
+		var snap snapshot
+		err = gob.NewDecoder(bufio.NewReader(file)).Decode(&snap)
+		file.Close()
+		if err != nil {
+			return false, fmt.Errorf("reading the snapshot: %v", err)
+		}
+		loadSnapshot(&snap)
+		s.seq = snap.Seq
+		restored = true
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	walPath := filepath.Join(s.dir, WAL_FILE)
+	file, err = os.Open(walPath)
+	if os.IsNotExist(err) {
+		return restored, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	replayed := 0
+	good, err := readLog(file, func(rec walRecord) {
+		if rec.Seq <= s.seq { //already in the snapshot
+			return
+		}
+		if err := replay(rec); err != nil {
+			log.Printf("skipping log record %d: %v\n", rec.Seq, err)
+		}
+		s.seq = rec.Seq
+		replayed++
+	})
+	file.Close()
+	if err != nil {
+		return false, fmt.Errorf("reading the log: %v", err)
+	}
+	if err := os.Truncate(walPath, good); err != nil { //drop a torn record at the end
+		return false, err
+	}
+	if replayed > 0 {
+		log.Printf("replayed %d log records\n", replayed)
+	}
+	return restored || replayed > 0, nil
+}
+
+//applies one log record to the globals
+func replay(rec walRecord) error {
+	switch rec.Op {
+	case OP_ADD:
+		appendRanges(rec.Ranges)
+		return nil
+	case OP_ENROLL, OP_REVOKE:
+		replayEnrolment(rec)
+		return nil
+	case OP_ROUND:
+		applyRound(rec)
+		return nil
+	case OP_ALIASES:
+		routers.Merge(rec.Routers)
+		return nil
+	}
+	if rec.Index < 0 || rec.Index >= len(ipTable) {
+		return fmt.Errorf("no range %d", rec.Index)
+	}
+	thisRange := ipTable[rec.Index]
+	thisRange.lock.Lock()
+	defer thisRange.lock.Unlock()
+	switch rec.Op {
+	case OP_ASSIGN:
+		seenBy(rec.Id)
+		thisRange.lease(rec.Id, rec.LeaseId, time.Now())
+	case OP_RESULT:
+		at := rec.At
+		if at.IsZero() {
+			at = time.Now()
+		}
+		if err := acceptResult(thisRange, rec.Index, rec.Id, rec.NewGSS, rec.News, rec.Links, at); err != nil {
+			return err
+		}
+		replayStats(rec)
+	case OP_PARTIAL:
+		if err := acceptPartial(thisRange, rec.Index, rec.Id, rec.LeaseId, rec.NewGSS, rec.News, rec.Links); err != nil {
+			return err
+		}
+		replayStats(rec)
+	case OP_FREE:
+		if thisRange.currentProbe == rec.Id {
+			thisRange.release()
+		}
+	default:
+		return errors.New("unknown operation " + rec.Op)
+	}
+	return nil
+}
+
+//adds the sketches a result came with to the statistics
+func replayStats(rec walRecord) {
+	if err := stats.record(rec.Id, rec.Seen, rec.Sightings); err != nil {
+		log.Printf("left out the sketches %s sent: %v\n", rec.Id, err)
+	}
+}
+
+//frees the ranges that were lent out when the leader stopped
+func expireLeases() {
+	for index, thisRange := range ipTable {
+		if thisRange.currentProbe != "" {
+			log.Printf("lease of range %d by %s expired while the leader was down\n", index, thisRange.currentProbe)
+			thisRange.release()
+			rangesExpired.Inc()
+		}
+	}
+}
+
+//replaces the globals with a snapshot
+func loadSnapshot(snap *snapshot) {
+	rounds = newRoundLog() //first, so stop sets made below are in the right round
+	if snap.Round > 0 {
+		rounds.number = snap.Round
+		rounds.seed = snap.RoundSeed
+		rounds.started = snap.RoundStarted
+		rounds.stops = snap.RoundStops
+		rounds.results = snap.RoundResults
+		rounds.past = snap.Rounds
+	}
+	ipTable = make([]*ipRange, len(snap.Ranges))
+	for i, r := range snap.Ranges {
+		stops := r.Stops
+		if stops == nil { //gob drops an interface holding nothing
+			stops = newStopSet()
+		}
+		ipTable[i] = &ipRange{
+			addresses: r.Addresses,
+			currentProbe: r.Owner,
+			stops: set.RestoreVersionedSet(stops, r.StopsVersion, set.DEFAULT_HISTORY),
+			lastProbed: r.LastProbed,
+			regionProbes: r.RegionProbes,
+		}
+	}
+	seenRanges = &seenMap{rangesSeenBy: make(map[string]*set.IntSet)}
+	for id, unseen := range snap.SeenBy {
+		indexes := set.NewIntSet()
+		for _, index := range unseen {
+			indexes.Add(index)
+		}
+		seenRanges.rangesSeenBy[id] = indexes
+	}
+	if snap.AllIPs != nil {
+		allIPs.ChangeSetTo(snap.AllIPs)
+	}
+	if snap.Topology != nil {
+		topology = snap.Topology
+	}
+	routers = alias.NewGroups()
+	routers.Merge(snap.Routers)
+	stats = restoreStats(snap.Found, snap.Sightings)
+	enrolled.restore(snap.Enrolments)
+}
+
+//the whole state as it is saved. the table must be locked for writing.
+func takeSnapshot(seq uint64) snapshot {
+	snap := snapshot{Seq: seq, SeenBy: make(map[string][]int), AllIPs: allIPs.Set(), Topology: topology, Routers: routers.Routers(1), Enrolments: enrolled.list()}
+	for _, thisRange := range ipTable {
+		snap.Ranges = append(snap.Ranges, rangeSnapshot{
+			Addresses: thisRange.addresses,
+			Owner: thisRange.currentProbe,
+			Stops: thisRange.stops.Set(),
+			StopsVersion: thisRange.stops.Version(),
+			LastProbed: thisRange.lastProbed,
+			RegionProbes: thisRange.regionProbes,
+		})
+	}
+	seenRanges.lock.Lock()
+	for id, indexes := range seenRanges.rangesSeenBy {
+		snap.SeenBy[id] = indexes.Items()
+	}
+	seenRanges.lock.Unlock()
+	rounds.lock.Lock()
+	snap.Round = rounds.number
+	snap.RoundSeed = rounds.seed
+	snap.RoundStarted = rounds.started
+	snap.RoundStops = rounds.stops
+	snap.RoundResults = rounds.results
+	snap.Rounds = append([]roundSummary{}, rounds.past...)
+	rounds.lock.Unlock()
+	snap.Found, snap.Sightings = stats.save()
+	return snap
+}
+
+//writes a snapshot beside path and renames it over path, so there is always a whole one
+func writeSnapshot(path string, snap *snapshot) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(file)
+	err = gob.NewEncoder(bw).Encode(snap)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//writes the whole state to a new snapshot, then empties the log
+func (s *store) snapshot() error {
+	tableLock.Lock() //no change is half done while the state is saved
+	defer tableLock.Unlock()
+	s.appendLock.Lock()
+	seq := s.seq
+	s.appendLock.Unlock()
+	snap := takeSnapshot(seq)
+	if err := writeSnapshot(filepath.Join(s.dir, SNAPSHOT_FILE), &snap); err != nil {
+		return err
+	}
+	//records up to snap.Seq are skipped on replay, so a crash before this is harmless
+	return s.wal.Truncate(0)
+}
+
+//takes a snapshot every so often
+func (s *store) snapshotEvery(every time.Duration) {
+	for range time.Tick(every) {
+		if err := s.snapshot(); err != nil {
+			log.Println("could not save a snapshot:", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arieltraver/ari_traceroute/graph"
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+func TestRestoreAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	setup(4)
+	var err error
+	if state, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { state = nil }()
+
+	//mon1 finishes a range, mon2 is still probing one when the leader dies
+	_, _, index, err := findNewRange("mon1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stops := set.NewSet[set.StopKey]()
+	stops.Add(set.NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 1}))
+	news := set.NewRoaringSet()
+	news.Add([4]byte{10, 0, 0, 1})
+	reply := ResultReply{}
+	links := []graph.Link{{From: [4]byte{10, 0, 0, 1}, To: [4]byte{10, 0, 0, 2}, TTL: 3}}
+	seen := set.NewHyperLogLog[[4]byte](set.HLL_PRECISION)
+	seen.Add([4]byte{10, 0, 0, 1})
+	if err := new(Leader).TransferResults(ResultArgs{NewGSS: stops, News: news, Links: links, Seen: seen, Id: "mon1", Index: index}, &reply); err != nil || !reply.Ok {
+		t.Fatal(err)
+	}
+	probed := ipTable[index].lastProbed
+	router := [][][4]byte{{{10, 0, 0, 2}, {10, 0, 0, 3}}}
+	if err := new(Leader).TransferAliases(AliasArgs{Id: "mon1", Routers: router}, &AliasReply{}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, leased, err := findNewRange("mon2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.wal.Close()
+	//a record cut off in the middle of being written
+	wal, _ := os.OpenFile(filepath.Join(dir, WAL_FILE), os.O_WRONLY|os.O_APPEND, 0644)
+	wal.Write([]byte{0x40, 1, 2})
+	wal.Close()
+
+	setup(4) //the restart
+	if state, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer state.wal.Close()
+	if !allIPs.Contains([4]byte{10, 0, 0, 1}) {
+		t.Errorf("lost the interfaces of an accepted result")
+	}
+	if got := ipTable[index].stops; got.Version() != 2 || !got.Set().Contains(stops.Items()[0]) {
+		t.Errorf("lost the stop set of an accepted result")
+	}
+	if e, ok := topology.Edge([4]byte{10, 0, 0, 1}, [4]byte{10, 0, 0, 2}); !ok || e.Count != 1 || e.Monitors[0] != "mon1" {
+		t.Errorf("lost the links of an accepted result")
+	}
+	if seenBy("mon1").Contains(index) || seenBy("mon1").Size() != 3 {
+		t.Errorf("mon1 should not be given its finished range again")
+	}
+	if ipTable[leased].currentProbe != "" {
+		t.Errorf("the lease open at the crash should have expired")
+	}
+	if info, _ := os.Stat(filepath.Join(dir, WAL_FILE)); info.Size() != 0 {
+		t.Errorf("the log should start over after a restore")
+	}
+	if !routers.Same([4]byte{10, 0, 0, 2}, [4]byte{10, 0, 0, 3}) {
+		t.Errorf("lost the routers a monitor sent")
+	}
+	if !ipTable[index].lastProbed.Equal(probed) || stats.found["mon1"] == nil {
+		t.Errorf("lost when the range was probed, %v for %v, or the sketch of mon1", ipTable[index].lastProbed, probed)
+	}
+	state.wal.Close()
+
+	setup(4) //from the snapshot alone
+	if state, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer state.wal.Close()
+	if !routers.Same([4]byte{10, 0, 0, 2}, [4]byte{10, 0, 0, 3}) {
+		t.Errorf("the routers were not in the snapshot")
+	}
+	if !ipTable[index].lastProbed.Equal(probed) || stats.found["mon1"] == nil {
+		t.Errorf("the probe time or the statistics were not in the snapshot")
+	}
+	if _, ok := routerGraph().Edge([4]byte{10, 0, 0, 1}, [4]byte{10, 0, 0, 2}); !ok {
+		t.Errorf("the router graph lost the link into the router")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+//ROUNDS
+//A campaign runs in rounds, and in each round every monitor probes every range
+//once. A new round starts as soon as every monitor heard from within a lease
+//term has probed every range and no range is still lent out. It also starts
+//-round after the last one did, if that comes first, or when it is asked for
+//with POST /api/rounds. Until then, a monitor that has probed every range is
+//told to wait.
+//Starting a round archives the state the last one ended with, as a snapshot
+//in -archive (the rounds directory under -state by default). It then takes
+//back every lent range, so results still on their way count as partial, and
+//gives every monitor every range to probe again. Each monitor sees the free
+//ranges in a new order every round, so the same monitor is not always first
+//into the same range. The interfaces found and the discovery estimates start
+//over; the topology and the routers keep growing, since edges record when
+//they were seen.
+//With -roundstops seed, the stop sets keep what earlier rounds found, and
+//monitors holding one catch up from the version they have. With -stoprounds n,
+//entries not found again for n rounds are dropped. With -roundstops empty,
+//every round starts from empty stop sets.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+const ROUND_SEED = "seed" //stop sets carry over into the next round
+const ROUND_EMPTY = "empty" //every round starts with empty stop sets
+const ARCHIVE_DIR = "rounds" //where rounds are archived under -state
+
+var roundEvery time.Duration //set by -round, 0 starts rounds only when the last one is done or through the api
+var roundStops = ROUND_SEED //set by -roundstops
+var archiveDir string //set by -archive
+
+var errProbedAll = errors.New("has probed every range")
+
+//a round that ended
+type roundSummary struct {
+	Number int `json:"number"`
+	Started time.Time `json:"started"`
+	Ended time.Time `json:"ended"`
+	Stops string `json:"stops"` //how its stop sets started
+	Results int64 `json:"results"` //ranges finished in it
+	Interfaces int `json:"interfaces"`
+	Links int `json:"links"`
+	Archive string `json:"archive,omitempty"` //the snapshot it was archived to
+}
+
+//the current round and the ones before it
+type roundLog struct {
+	number int
+	seed int64 //orders the free ranges for each monitor, 0 keeps table order
+	started time.Time
+	stops string
+	results int64
+	past []roundSummary
+	lock sync.Mutex
+}
+
+var rounds = newRoundLog()
+
+func newRoundLog() *roundLog {
+	return &roundLog{number: 1, started: time.Now(), stops: ROUND_SEED}
+}
+
+func validRoundStops(stops string) error {
+	if stops != ROUND_SEED && stops != ROUND_EMPTY {
+		return fmt.Errorf("no stop set policy %q for rounds, use %s or %s", stops, ROUND_SEED, ROUND_EMPTY)
+	}
+	return nil
+}
+
+//the number of the current round
+func (rl *roundLog) current() int {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return rl.number
+}
+
+func (rl *roundLog) startedAt() time.Time {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return rl.started
+}
+
+//counts a range finished in the current round
+func (rl *roundLog) finished() {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	rl.results++
+}
+
+//a copy of the rounds that ended, oldest first
+func (rl *roundLog) list() []roundSummary {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return append([]roundSummary{}, rl.past...)
+}
+
+//where the ranges fall in a monitor's order for a round
+func rank(seed int64, id string, index int) uint64 {
+	h := fnv.New64a()
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(seed))
+	binary.BigEndian.PutUint64(b[8:], uint64(index))
+	h.Write(b[:8])
+	h.Write([]byte(id))
+	h.Write(b[8:])
+	x := h.Sum64()
+	//fnv hardly mixes the last bytes, so finish like splitmix64
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+//puts free ranges in the monitor's order for this round. the first round keeps table order.
+func (rl *roundLog) order(id string, free []candidate) {
+	rl.lock.Lock()
+	seed := rl.seed
+	rl.lock.Unlock()
+	if seed == 0 {
+		return
+	}
+	sort.SliceStable(free, func(i, j int) bool {
+		return rank(seed, id, free[i].index) < rank(seed, id, free[j].index)
+	})
+}
+
+//where rounds are archived, empty if they are not
+func roundArchiveDir() string {
+	if archiveDir != "" {
+		return archiveDir
+	}
+	if state != nil {
+		return filepath.Join(state.dir, ARCHIVE_DIR)
+	}
+	return ""
+}
+
+func newRoundSeed() int64 {
+	for {
+		if seed := rand.Int63(); seed != 0 {
+			return seed
+		}
+	}
+}
+
+//archives the current round and starts the next, whose stop sets start as stops says.
+//it returns the round that ended.
+func startRound(stops string) (roundSummary, error) {
+	if err := validRoundStops(stops); err != nil {
+		return roundSummary{}, err
+	}
+	tableLock.Lock() //nothing is lent or accepted while the round changes
+	defer tableLock.Unlock()
+	return nextRound(stops)
+}
+
+//starts the next round if the current one is done, reporting whether it did
+func finishRound(stops string, now time.Time) (bool, error) {
+	tableLock.Lock()
+	defer tableLock.Unlock()
+	if !roundDone(now) {
+		return false, nil
+	}
+	_, err := nextRound(stops)
+	return err == nil, err
+}
+
+//whether every monitor heard from within a lease term has probed every range
+//this round, and every result is in. the table must be locked for writing.
+func roundDone(now time.Time) bool {
+	if len(ipTable) == 0 {
+		return false
+	}
+	for _, thisRange := range ipTable {
+		if thisRange.currentProbe != "" {
+			return false
+		}
+	}
+	active := contacts.since(now.Add(-leaseTerm))
+	if len(active) == 0 {
+		return false
+	}
+	seenRanges.lock.Lock()
+	defer seenRanges.lock.Unlock()
+	for _, id := range active {
+		unseen, ok := seenRanges.rangesSeenBy[id]
+		if !ok || unseen.Size() > 0 { //a monitor that has not asked yet this round has everything to probe
+			return false
+		}
+	}
+	return true
+}
+
+//archives the current round and starts the next. the table must be locked for writing.
+func nextRound(stops string) (roundSummary, error) {
+	number := rounds.current() + 1
+	archive := ""
+	if dir := roundArchiveDir(); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return roundSummary{}, err
+		}
+		archive = filepath.Join(dir, fmt.Sprintf("round-%d.snap", number-1))
+		snap := takeSnapshot(0)
+		if err := writeSnapshot(archive, &snap); err != nil {
+			return roundSummary{}, fmt.Errorf("could not archive round %d: %v", number-1, err)
+		}
+	}
+	rec := walRecord{Op: OP_ROUND, Round: number, Seed: newRoundSeed(), Stops: stops, Archive: archive, At: time.Now()}
+	if err := state.log(rec); err != nil {
+		return roundSummary{}, err
+	}
+	ended := applyRound(rec)
+	log.Printf("round %d ended with %d results, round %d started with %s stop sets\n", ended.Number, ended.Results, number, stops)
+	return ended, nil
+}
+
+//moves the globals to a new round. the table must be locked for writing, or not in use yet.
+func applyRound(rec walRecord) roundSummary {
+	rounds.lock.Lock()
+	ended := roundSummary{
+		Number: rounds.number,
+		Started: rounds.started,
+		Ended: rec.At,
+		Stops: rounds.stops,
+		Results: rounds.results,
+		Interfaces: allIPs.Size(),
+		Archive: rec.Archive,
+	}
+	_, ended.Links = topology.Size()
+	rounds.past = append(rounds.past, ended)
+	rounds.number = rec.Round
+	rounds.seed = rec.Seed
+	rounds.started = rec.At
+	rounds.stops = rec.Stops
+	rounds.results = 0
+	rounds.lock.Unlock()
+
+	for index, thisRange := range ipTable {
+		thisRange.lock.Lock()
+		if thisRange.currentProbe != "" {
+			log.Printf("range %d was taken back from %s for the new round\n", index, thisRange.currentProbe)
+			thisRange.release()
+		}
+		if rec.Stops == ROUND_EMPTY {
+			//a version past every one handed out, so holders are sent the whole, empty set
+			thisRange.stops = set.RestoreVersionedSet(newStopSet(), thisRange.stops.Version()+1, set.DEFAULT_HISTORY)
+		} else if expiring, ok := thisRange.stops.Set().(*set.ExpiringSet[set.StopKey]); ok {
+			expiring.SetRound(rec.Round)
+			thisRange.stops.Compact() //drops what was not found again for -stoprounds rounds
+		}
+		thisRange.lock.Unlock()
+	}
+	seenRanges.lock.Lock()
+	seenRanges.rangesSeenBy = make(map[string]*set.IntSet)
+	seenRanges.lock.Unlock()
+	allIPs.ChangeSetTo(newIPSet())
+	stats.reset()
+	return ended
+}
+
+//starts a round whenever the current one is done, or, if every is not 0, has lasted every
+func scheduleRounds(every time.Duration) {
+	for now := range time.Tick(REAP_EVERY) {
+		if every > 0 && now.Sub(rounds.startedAt()) >= every {
+			if _, err := startRound(roundStops); err != nil {
+				log.Println("could not start a new round:", err)
+			}
+			continue
+		}
+		if _, err := finishRound(roundStops, now); err != nil {
+			log.Println("could not start a new round:", err)
+		}
+	}
+}
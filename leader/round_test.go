@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+//lends mon1 a range and sends back one stop key for it, returning the range
+func probeOnce(t *testing.T, id string, hop byte) int {
+	t.Helper()
+	reply := IpReply{}
+	if err := new(Leader).GetIPs(IpArgs{ProbeId: id}, &reply); err != nil || !reply.Ok {
+		t.Fatalf("%s got no range: %v", id, err)
+	}
+	stops := set.NewSet[set.StopKey]()
+	stops.Add(set.NewStopKey([4]byte{10, 0, 0, hop}, reply.Ips[0]))
+	args := ResultArgs{NewGSS: stops, Id: id, Index: reply.Index, LeaseId: reply.LeaseId}
+	if err := new(Leader).TransferResults(args, &ResultReply{}); err != nil {
+		t.Fatal(err)
+	}
+	return reply.Index
+}
+
+func TestRounds(t *testing.T) {
+	setup(3)
+	for i := 0; i < 3; i++ {
+		probeOnce(t, "mon1", 1)
+	}
+	if err := findNewRangeErr("mon1"); !errors.Is(err, errProbedAll) {
+		t.Fatalf("expected mon1 to have probed every range, got %v", err)
+	}
+	reply := IpReply{}
+	if err := new(Leader).GetIPs(IpArgs{ProbeId: "mon1"}, &reply); err != nil || !reply.Paused {
+		t.Errorf("mon1 should be told to wait for the next round: %+v %v", reply, err)
+	}
+	version := ipTable[0].stops.Version()
+
+	ended, err := startRound(ROUND_SEED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ended.Number != 1 || ended.Results != 3 || rounds.current() != 2 {
+		t.Errorf("unexpected round %+v, now in %d", ended, rounds.current())
+	}
+	if ipTable[0].stops.Set().Size() != 1 || ipTable[0].stops.Version() != version {
+		t.Errorf("the stop set was not carried over")
+	}
+	index := probeOnce(t, "mon1", 2)
+	if ipTable[index].stops.Set().Size() != 2 {
+		t.Errorf("the new round did not add to the seeded stop set")
+	}
+
+	if _, err := startRound(ROUND_EMPTY); err != nil {
+		t.Fatal(err)
+	}
+	stops, full := ipTable[index].stops.Since(ipTable[index].stops.Version() - 1)
+	if stops.Size() != 0 || !full {
+		t.Errorf("holders of the old stop set should get the whole empty one, got %d entries, full %v", stops.Size(), full)
+	}
+	if list := rounds.list(); len(list) != 2 || list[1].Results != 1 || list[1].Stops != ROUND_SEED {
+		t.Errorf("unexpected rounds %+v", list)
+	}
+	if _, err := startRound("sometimes"); err == nil {
+		t.Errorf("started a round with an unknown stop set policy")
+	}
+}
+
+func TestRoundsFinish(t *testing.T) {
+	setup(2)
+	probeOnce(t, "mon1", 1)
+	contacts.touch("mon2") //mon2 has called, but not asked for a range this round
+	now := time.Now()
+	if started, err := finishRound(ROUND_SEED, now); err != nil || started {
+		t.Fatalf("a round was started with ranges left to probe: %v", err)
+	}
+	probeOnce(t, "mon1", 2)
+	if started, _ := finishRound(ROUND_SEED, now); started {
+		t.Fatalf("a round was started before mon2 probed every range")
+	}
+	probeOnce(t, "mon2", 3)
+	reply := IpReply{}
+	if err := new(Leader).GetIPs(IpArgs{ProbeId: "mon2"}, &reply); err != nil || !reply.Ok {
+		t.Fatalf("mon2 got no range: %v", err)
+	}
+	if started, _ := finishRound(ROUND_SEED, time.Now()); started {
+		t.Fatalf("a round was started while a range was lent out")
+	}
+	args := ResultArgs{NewGSS: set.NewSet[set.StopKey](), Id: "mon2", Index: reply.Index, LeaseId: reply.LeaseId}
+	if err := new(Leader).TransferResults(args, &ResultReply{}); err != nil {
+		t.Fatal(err)
+	}
+	//nobody has called within a lease term, so nobody is probing
+	if started, err := finishRound(ROUND_SEED, time.Now().Add(2*leaseTerm)); err != nil || started {
+		t.Fatalf("a round was started with no monitor heard from: %v", err)
+	}
+	if started, err := finishRound(ROUND_SEED, time.Now()); err != nil || !started || rounds.current() != 2 {
+		t.Fatalf("the round was not finished once every monitor probed every range: %v, round %d", err, rounds.current())
+	}
+}
+
+//what findNewRange says to id, without keeping the range
+func findNewRangeErr(id string) error {
+	tableLock.RLock()
+	defer tableLock.RUnlock()
+	_, _, _, err := findNewRange(id, nil)
+	return err
+}
+
+func TestStopsAgeByRound(t *testing.T) {
+	stopPolicy = set.AgePolicy{MaxRounds: 1}
+	defer func() { stopPolicy = set.AgePolicy{} }()
+	setup(1)
+	probeOnce(t, "mon1", 1)
+	if _, err := startRound(ROUND_SEED); err != nil {
+		t.Fatal(err)
+	}
+	if ipTable[0].stops.Set().Size() != 1 {
+		t.Fatalf("an entry from the last round was dropped")
+	}
+	if _, err := startRound(ROUND_SEED); err != nil {
+		t.Fatal(err)
+	}
+	if ipTable[0].stops.Set().Size() != 0 {
+		t.Errorf("an entry not found for two rounds was kept")
+	}
+}
+
+func TestRoundsReshuffle(t *testing.T) {
+	setup(20)
+	orderOf := func(id string) []int {
+		free := []candidate{}
+		for i := range ipTable {
+			free = append(free, candidate{index: i})
+		}
+		rounds.order(id, free)
+		indexes := []int{}
+		for _, c := range free {
+			indexes = append(indexes, c.index)
+		}
+		return indexes
+	}
+	same := func(a []int, b []int) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	first := orderOf("mon1")
+	for i, index := range first {
+		if i != index {
+			t.Fatalf("the first round should keep table order, got %v", first)
+		}
+	}
+	if _, err := startRound(ROUND_SEED); err != nil {
+		t.Fatal(err)
+	}
+	mon1, mon2 := orderOf("mon1"), orderOf("mon2")
+	if same(mon1, first) || same(mon1, mon2) {
+		t.Errorf("the order was not reshuffled per monitor: %v %v", mon1, mon2)
+	}
+	if !same(mon1, orderOf("mon1")) {
+		t.Errorf("a monitor's order changed within a round")
+	}
+	if _, err := startRound(ROUND_SEED); err != nil {
+		t.Fatal(err)
+	}
+	if same(mon1, orderOf("mon1")) {
+		t.Errorf("mon1's order did not change between rounds")
+	}
+}
+
+func TestRoundsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	setup(2)
+	var err error
+	if state, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { state = nil }()
+	probeOnce(t, "mon1", 1)
+	ended, err := startRound(ROUND_EMPTY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ended.Archive); err != nil {
+		t.Errorf("round 1 was not archived: %v", err)
+	}
+	probeOnce(t, "mon1", 2)
+	state.wal.Close()
+
+	setup(2)
+	if state, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	if rounds.current() != 2 || len(rounds.list()) != 1 || rounds.list()[0].Results != 1 {
+		t.Errorf("the rounds were lost: %d %+v", rounds.current(), rounds.list())
+	}
+	if seenBy("mon1").Size() != 1 {
+		t.Errorf("mon1 should have one range left this round")
+	}
+	if err := state.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	state.wal.Close()
+
+	setup(2)
+	if state, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer state.wal.Close()
+	if rounds.current() != 2 || rounds.list()[0].Archive != ended.Archive {
+		t.Errorf("the rounds were not in the snapshot: %d %+v", rounds.current(), rounds.list())
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+//discovery statistics kept from the sketches monitors send with their results.
+//memory is constant per monitor no matter how many interfaces they find.
+type discoveryStats struct {
+	found map[string]*set.HyperLogLog[[4]byte] //monitor id to a sketch of what it found
+	sightings *set.CountMin[[4]byte] //replies per interface, from every monitor
+	lock sync.Mutex
+}
+
+func newDiscoveryStats() *discoveryStats {
+	return &discoveryStats{
+		found: make(map[string]*set.HyperLogLog[[4]byte]),
+		sightings: set.NewCountMin[[4]byte](set.COUNTMIN_WIDTH, set.COUNTMIN_DEPTH),
+	}
+}
+
+//forgets everything recorded, for a new round
+func (d *discoveryStats) reset() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.found = make(map[string]*set.HyperLogLog[[4]byte])
+	d.sightings = set.NewCountMin[[4]byte](set.COUNTMIN_WIDTH, set.COUNTMIN_DEPTH)
+}
+
+//copies of the sketches, to be saved
+func (d *discoveryStats) save() (map[string]*set.HyperLogLog[[4]byte], *set.CountMin[[4]byte]) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	found := make(map[string]*set.HyperLogLog[[4]byte], len(d.found))
+	for id, seen := range d.found {
+		found[id] = seen.Clone()
+	}
+	sightings := set.NewCountMin[[4]byte](set.COUNTMIN_WIDTH, set.COUNTMIN_DEPTH)
+	sightings.Merge(d.sightings) //same size, so it cannot fail
+	return found, sightings
+}
+
+//statistics from saved sketches. ones that are malformed, or missing from older snapshots, start over.
+func restoreStats(found map[string]*set.HyperLogLog[[4]byte], sightings *set.CountMin[[4]byte]) *discoveryStats {
+	d := newDiscoveryStats()
+	for id, seen := range found {
+		if validSketches(seen, nil) == nil {
+			d.found[id] = seen
+		}
+	}
+	if sightings != nil && validSketches(nil, sightings) == nil {
+		d.sightings = sightings
+	}
+	return d
+}
+
+//checks that sketches from a monitor are sized as the leader's are, so they can be merged
+func validSketches(seen *set.HyperLogLog[[4]byte], sightings *set.CountMin[[4]byte]) error {
+	if seen != nil && (seen.Precision != set.HLL_PRECISION || len(seen.Registers) != 1<<set.HLL_PRECISION) {
+		return fmt.Errorf("a HyperLogLog of precision %d with %d registers, expected precision %d", seen.Precision, len(seen.Registers), set.HLL_PRECISION)
+	}
+	if sightings != nil && (sightings.Width != set.COUNTMIN_WIDTH || sightings.Depth != set.COUNTMIN_DEPTH || len(sightings.Counts) != set.COUNTMIN_WIDTH*set.COUNTMIN_DEPTH) {
+		return fmt.Errorf("a count-min sketch of %dx%d with %d counters, expected %dx%d", sightings.Width, sightings.Depth, len(sightings.Counts), set.COUNTMIN_WIDTH, set.COUNTMIN_DEPTH)
+	}
+	return nil
+}
+
+//adds the sketches of one transfer. either may be nil, from an older monitor.
+//if either is malformed, neither is added.
+func (d *discoveryStats) record(id string, seen *set.HyperLogLog[[4]byte], sightings *set.CountMin[[4]byte]) error {
+	if err := validSketches(seen, sightings); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if seen != nil {
+		if mine, ok := d.found[id]; ok {
+			if err := mine.Merge(seen); err != nil {
+				return err
+			}
+		} else {
+			d.found[id] = seen
+		}
+	}
+	if sightings != nil {
+		return d.sightings.Merge(sightings)
+	}
+	return nil
+}
+
+//what one monitor contributed
+type contribution struct {
+	id string
+	found uint64 //distinct interfaces it found
+	unique uint64 //of those, ones no other monitor found
+}
+
+//one pair of monitors and how many interfaces both found
+type overlap struct {
+	a, b string
+	both uint64
+}
+
+//estimates each monitor's contribution and the overlap of every pair.
+//the estimates are off by about 1% of the sets involved.
+func (d *discoveryStats) estimate() (uint64, []contribution, []overlap, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	ids := make([]string, 0, len(d.found))
+	for id := range d.found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	all := set.NewHyperLogLog[[4]byte](set.HLL_PRECISION)
+	for _, id := range ids {
+		if err := all.Merge(d.found[id]); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	total := all.Count()
+	contributions := make([]contribution, 0, len(ids))
+	for _, id := range ids {
+		others := set.NewHyperLogLog[[4]byte](set.HLL_PRECISION)
+		for _, other := range ids {
+			if other != id {
+				others.Merge(d.found[other])
+			}
+		}
+		c := contribution{id: id, found: d.found[id].Count()}
+		if n := others.Count(); n < total {
+			c.unique = total - n
+		}
+		contributions = append(contributions, c)
+	}
+	overlaps := []overlap{}
+	for i, a := range ids {
+		for _, b := range ids[i+1:] {
+			both, err := set.Overlap(d.found[a], d.found[b])
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			overlaps = append(overlaps, overlap{a: a, b: b, both: both})
+		}
+	}
+	return total, contributions, overlaps, nil
+}
+
+//the interfaces in found that answered most often, at most k of them
+func (d *discoveryStats) mostSeen(found *set.SafeSet[[4]byte], k int) [][4]byte {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	counts := map[[4]byte]uint64{}
+	top := [][4]byte{}
+	found.Each(func(addr [4]byte) bool {
+		counts[addr] = d.sightings.Count(addr)
+		top = append(top, addr)
+		sort.Slice(top, func(i, j int) bool { return counts[top[i]] > counts[top[j]] })
+		if len(top) > k {
+			delete(counts, top[k])
+			top = top[:k]
+		}
+		return true
+	})
+	return top
+}
+
+//a readable summary of the estimates
+func (d *discoveryStats) report() string {
+	total, contributions, overlaps, err := d.estimate()
+	if err != nil {
+		return "no discovery statistics: " + err.Error()
+	}
+	top := d.mostSeen(allIPs, 5)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "about %d distinct interfaces found, %d replies\n", total, d.sightings.Total)
+	for _, c := range contributions {
+		fmt.Fprintf(b, "%s: found about %d, %d of them found by no other monitor\n", c.id, c.found, c.unique)
+	}
+	for _, o := range overlaps {
+		fmt.Fprintf(b, "%s and %s both found about %d\n", o.a, o.b, o.both)
+	}
+	for _, addr := range top {
+		fmt.Fprintf(b, "%v answered about %d times\n", net.IP(addr[:]), d.sightings.Count(addr))
+	}
+	return b.String()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+func TestBadSketchesAreLeftOut(t *testing.T) {
+	setup(1)
+	reply := IpReply{}
+	if err := new(Leader).GetIPs(IpArgs{ProbeId: "mon1"}, &reply); err != nil || !reply.Ok {
+		t.Fatalf("mon1 got no range: %v", err)
+	}
+	seen := set.NewHyperLogLog[[4]byte](set.HLL_PRECISION)
+	seen.Add([4]byte{10, 0, 0, 1})
+	huge := set.NewCountMin[[4]byte](set.COUNTMIN_WIDTH*4, set.COUNTMIN_DEPTH)
+	args := ResultArgs{NewGSS: set.NewSet[set.StopKey](), Id: "mon1", Index: reply.Index, LeaseId: reply.LeaseId, Seen: seen, Sightings: huge}
+	result := ResultReply{}
+	if err := new(Leader).TransferResults(args, &result); err != nil || !result.Ok {
+		t.Fatalf("a result with a bad sketch was refused: %v", err)
+	}
+	if len(stats.found) != 0 {
+		t.Errorf("the sketches of a transfer with a bad one were kept")
+	}
+
+	small := set.NewHyperLogLog[[4]byte](4)
+	if err := stats.record("mon1", small, nil); err == nil {
+		t.Errorf("a HyperLogLog of the wrong precision was recorded")
+	}
+	seen.Registers = seen.Registers[:10]
+	if err := stats.record("mon1", seen, nil); err == nil {
+		t.Errorf("a HyperLogLog missing registers was recorded")
+	}
+	sightings := set.NewCountMin[[4]byte](set.COUNTMIN_WIDTH, set.COUNTMIN_DEPTH)
+	sightings.Counts = sightings.Counts[:1]
+	if err := stats.record("mon1", nil, sightings); err == nil {
+		t.Errorf("a count-min sketch missing counters was recorded")
+	}
+	if err := stats.record("mon1", set.NewHyperLogLog[[4]byte](set.HLL_PRECISION), set.NewCountMin[[4]byte](set.COUNTMIN_WIDTH, set.COUNTMIN_DEPTH)); err != nil {
+		t.Errorf("good sketches were refused: %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+//TARGETS
+//The addresses to probe come from files, one target per line, in any of:
+//  plain addresses: 192.0.2.1
+//  CIDR blocks: 192.0.2.0/24, one target per /24 inside (the .1), or one for a smaller block
+//  ISI hitlists: fsdb files with hex "ip" and "score" columns, keeping addresses that answered
+//  ZMap output: addresses one per line, or CSV with a "saddr" column
+//Lines starting with # are comments, except fsdb headers. IPv6 targets are skipped.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+const TARGET_PREFIX = 24 //a CIDR block gives one target per block of this size
+
+//the columns of a file in a table format
+type columns struct {
+	addr int //the address column
+	score int //-1 if there is none
+	hexAddr bool //hitlist addresses are 8 hex digits
+	sep string //"" splits on whitespace, as fsdb files do
+}
+
+//reads targets from r into found, returning how many lines were skipped
+func readTargets(r io.Reader, found *set.Set[[4]byte]) (int, error) {
+	sc := bufio.NewScanner(r)
+	var table *columns //nil until a header says the file is a table
+	skipped := 0
+	first := true
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#fsdb") {
+			cols, err := fsdbColumns(line)
+			if err != nil {
+				return skipped, err
+			}
+			table = cols
+			first = false
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if first && strings.Contains(line, "saddr") { //a ZMap CSV header
+			table = csvColumns(line)
+			first = false
+			continue
+		}
+		first = false
+		if table != nil {
+			addr, ok := table.parse(line)
+			if !ok {
+				skipped++
+				continue
+			}
+			found.Add(addr)
+			continue
+		}
+		if !addLine(line, found) {
+			skipped++
+		}
+	}
+	return skipped, sc.Err()
+}
+
+//a plain address or CIDR block
+func addLine(line string, found *set.Set[[4]byte]) bool {
+	if strings.Contains(line, "/") {
+		prefix, err := netip.ParsePrefix(line)
+		if err != nil || !prefix.Addr().Unmap().Is4() {
+			return false
+		}
+		for _, addr := range blockTargets(prefix) {
+			found.Add(addr)
+		}
+		return true
+	}
+	if i := strings.IndexAny(line, " \t,"); i >= 0 { //anything after the address
+		line = line[:i]
+	}
+	addr, ok := set.ParseAddress(line)
+	if ok {
+		found.Add(addr)
+	}
+	return ok
+}
+
+//one target in each /TARGET_PREFIX of a block: the .1 address, as most
+//hitlists choose. a smaller block gives its first address after the network.
+func blockTargets(prefix netip.Prefix) [][4]byte {
+	prefix = prefix.Masked()
+	base := prefix.Addr().Unmap().As4()
+	start := binary.BigEndian.Uint32(base[:])
+	bits := prefix.Bits()
+	if prefix.Addr().Is4In6() {
+		bits -= 96
+	}
+	if bits >= TARGET_PREFIX {
+		if bits < 31 {
+			start++
+		}
+		return [][4]byte{uint32Address(start)}
+	}
+	count := uint32(1) << (TARGET_PREFIX - bits)
+	step := uint32(1) << (32 - TARGET_PREFIX)
+	targets := make([][4]byte, 0, count)
+	for i := uint32(0); i < count; i++ {
+		targets = append(targets, uint32Address(start+i*step+1))
+	}
+	return targets
+}
+
+func uint32Address(n uint32) [4]byte {
+	var addr [4]byte
+	binary.BigEndian.PutUint32(addr[:], n)
+	return addr
+}
+
+//the columns named in an fsdb header such as "#fsdb -F t score ip"
+func fsdbColumns(header string) (*columns, error) {
+	fields := strings.Fields(header)[1:]
+	cols := &columns{addr: -1, score: -1, hexAddr: true}
+	names := []string{}
+	for i := 0; i < len(fields); i++ {
+		if strings.HasPrefix(fields[i], "-") { //options such as the separator, -F t, which take a value
+			i++
+			continue
+		}
+		names = append(names, strings.SplitN(fields[i], ":", 2)[0])
+	}
+	for i, name := range names {
+		switch name {
+		case "ip", "addr", "address", "saddr":
+			cols.addr = i
+		case "score":
+			cols.score = i
+		}
+	}
+	if cols.addr < 0 {
+		return nil, fmt.Errorf("no address column in %q", header)
+	}
+	return cols, nil
+}
+
+//the columns of a CSV header with a "saddr" column
+func csvColumns(header string) *columns {
+	cols := &columns{score: -1, sep: ","}
+	for i, name := range strings.Split(header, ",") {
+		if strings.TrimSpace(name) == "saddr" {
+			cols.addr = i
+		}
+	}
+	return cols
+}
+
+//the target on a line of a table, if it has one worth probing
+func (c *columns) parse(line string) ([4]byte, bool) {
+	var fields []string
+	if c.sep == "" {
+		fields = strings.Fields(line)
+	} else {
+		fields = strings.Split(line, c.sep)
+	}
+	if c.addr >= len(fields) {
+		return [4]byte{}, false
+	}
+	if c.score >= 0 {
+		if c.score >= len(fields) {
+			return [4]byte{}, false
+		}
+		score, err := strconv.Atoi(strings.TrimSpace(fields[c.score]))
+		if err != nil || score < 0 { //negative scores never answered
+			return [4]byte{}, false
+		}
+	}
+	field := strings.TrimSpace(fields[c.addr])
+	if c.hexAddr && len(field) == 8 {
+		var addr [4]byte
+		if _, err := hex.Decode(addr[:], []byte(field)); err == nil {
+			return addr, true
+		}
+	}
+	return set.ParseAddress(field)
+}
+
+//the targets found that no prefix of exclude covers. exclude may be nil.
+func notExcluded(found *set.Set[[4]byte], exclude *set.PrefixSet) [][4]byte {
+	targets := make([][4]byte, 0, found.Size())
+	found.Each(func(addr [4]byte) bool {
+		if exclude == nil || !exclude.Covers(netip.AddrFrom4(addr)) {
+			targets = append(targets, addr)
+		}
+		return true
+	})
+	return targets
+}
+
+//reads the targets in every file, without repeats or excluded addresses
+func loadTargets(paths []string, exclude *set.PrefixSet) ([][4]byte, error) {
+	found := set.NewSet[[4]byte]()
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		skipped, err := readTargets(file, found)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		if skipped > 0 {
+			fmt.Println("skipped", skipped, "lines of", path)
+		}
+	}
+	targets := notExcluded(found, exclude)
+	if len(targets) == 0 {
+		return nil, errors.New("no targets to probe")
+	}
+	return targets, nil
+}
+
+//splits targets into ranges of neighbouring addresses, so the destinations of
+//a range share prefixes and paths, and its stop set is useful for all of them.
+//ranges hold size targets, or there are count ranges if size is 0.
+//the order of the ranges and of the targets in each is shuffled, so monitors
+//spread their probes over the address space.
+func partition(targets [][4]byte, size int, count int, rnd *rand.Rand) [][][4]byte {
+	sorted := make([][4]byte, len(targets))
+	copy(sorted, targets)
+	sort.Slice(sorted, func(i, j int) bool {
+		return binary.BigEndian.Uint32(sorted[i][:]) < binary.BigEndian.Uint32(sorted[j][:])
+	})
+	if size <= 0 {
+		if count <= 0 {
+			count = CHUNKS
+		}
+		size = (len(sorted) + count - 1) / count
+	}
+	if size < 1 {
+		size = 1
+	}
+	ranges := [][][4]byte{}
+	for start := 0; start < len(sorted); start += size {
+		end := start + size
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+		r := sorted[start:end:end]
+		rnd.Shuffle(len(r), func(i, j int) { r[i], r[j] = r[j], r[i] })
+		ranges = append(ranges, r)
+	}
+	rnd.Shuffle(len(ranges), func(i, j int) { ranges[i], ranges[j] = ranges[j], ranges[i] })
+	return ranges
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+func TestReadTargetFormats(t *testing.T) {
+	for name, test := range map[string]struct {
+		text string
+		want []string
+	}{
+		"plain": {"# targets\n192.0.2.7\n192.0.2.7\n2001:db8::1\n198.51.100.3 extra\n", []string{"192.0.2.7", "198.51.100.3"}},
+		"cidr": {"10.0.0.0/22\n192.0.2.64/28\n", []string{"10.0.0.1", "10.0.1.1", "10.0.2.1", "10.0.3.1", "192.0.2.65"}},
+		"hitlist": {"#fsdb -F t score ip\n99\tc0000201\n-2\tc0000202\n7\t0a000001\n# | /bin/hitlist\n", []string{"192.0.2.1", "10.0.0.1"}},
+		"zmap csv": {"saddr,sport,classification\n192.0.2.9,0,synack\n192.0.2.10,0,rst\n", []string{"192.0.2.9", "192.0.2.10"}},
+	} {
+		found := set.NewSet[[4]byte]()
+		if _, err := readTargets(strings.NewReader(test.text), found); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		want := set.NewSet[[4]byte]()
+		for _, addr := range test.want {
+			a, _ := set.ParseAddress(addr)
+			want.Add(a)
+		}
+		if !found.Equal(want) {
+			t.Errorf("%s: got %v", name, found.ToCSV())
+		}
+	}
+}
+
+func TestPartitionKeepsNeighbours(t *testing.T) {
+	targets := [][4]byte{}
+	for i := 0; i < 100; i++ {
+		targets = append(targets, [4]byte{10, byte(i % 4), byte(i), 1})
+	}
+	ranges := partition(targets, 25, 0, rand.New(rand.NewSource(1)))
+	if len(ranges) != 4 {
+		t.Fatalf("expected 4 ranges of 25, got %d", len(ranges))
+	}
+	for _, r := range ranges {
+		for _, addr := range r {
+			if addr[1] != r[0][1] {
+				t.Errorf("a range mixes 10.%d/16 and 10.%d/16", r[0][1], addr[1])
+				break
+			}
+		}
+	}
+	if ranges := partition(targets, 0, 3, rand.New(rand.NewSource(1))); len(ranges) != 3 {
+		t.Errorf("expected 3 ranges, got %d", len(ranges))
+	}
+}
@@ -0,0 +1,428 @@
+// Package metrics exposes counters, gauges and histograms in the OpenMetrics
+// text format, so Prometheus and anything else that scrapes it can watch the
+// leader and the monitors.
+//
+// A metric is a family: it has a name, help text and label names, and one
+// value for each combination of label values it has been given. Families are
+// made by a Registry, whose Handler serves them all.
+//
+//	probes := metrics.Default.Counter("probes_sent", "Probes sent.", "direction")
+//	probes.Inc("forward")
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefBuckets are histogram buckets for durations in seconds, from 5ms to 10s.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count buckets, the first start and each factor times the last.
+func ExponentialBuckets(start float64, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+//what every family has
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	lock   sync.Mutex
+}
+
+//the key of a combination of label values
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+//writes {a="x",b="y"}, with extra label pairs after the family's
+func (f *family) labelText(values []string, extra ...string) string {
+	if len(f.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	b := &strings.Builder{}
+	b.WriteByte('{')
+	for i, name := range f.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(b, "%s=%s", name, strconv.Quote(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(b, "%s=%s", extra[i], strconv.Quote(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//one value of a counter or gauge
+type sample struct {
+	values []string
+	value  float64
+}
+
+// Counter is a family of values that only go up.
+type Counter struct {
+	family
+	samples map[string]*sample
+}
+
+// Add adds n, which must not be negative, to the counter with these label values.
+func (c *Counter) Add(n float64, values ...string) {
+	if n < 0 {
+		panic("metrics: counters cannot go down")
+	}
+	key := c.key(values)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, ok := c.samples[key]
+	if !ok {
+		s = &sample{values: append([]string(nil), values...)}
+		c.samples[key] = s
+	}
+	s.value += n
+}
+
+// Inc adds one.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Value returns the counter with these label values.
+func (c *Counter) Value(values ...string) float64 {
+	key := c.key(values)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if s, ok := c.samples[key]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// Gauge is a family of values that go up and down.
+type Gauge struct {
+	family
+	samples map[string]*sample
+	fn      func() float64 //for a gauge without labels read when scraped
+}
+
+// Set sets the gauge with these label values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.update(values, func(s *sample) { s.value = v })
+}
+
+// Add adds n, which may be negative.
+func (g *Gauge) Add(n float64, values ...string) {
+	g.update(values, func(s *sample) { s.value += n })
+}
+
+func (g *Gauge) update(values []string, fn func(*sample)) {
+	key := g.key(values)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	s, ok := g.samples[key]
+	if !ok {
+		s = &sample{values: append([]string(nil), values...)}
+		g.samples[key] = s
+	}
+	fn(s)
+}
+
+// Value returns the gauge with these label values.
+func (g *Gauge) Value(values ...string) float64 {
+	if g.fn != nil {
+		return g.fn()
+	}
+	key := g.key(values)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if s, ok := g.samples[key]; ok {
+		return s.value
+	}
+	return 0
+}
+
+//one set of buckets of a histogram
+type histogramSample struct {
+	values []string
+	counts []uint64 //per bucket, not cumulative. the last is above every bound
+	sum    float64
+}
+
+// Histogram is a family of distributions, counted into buckets.
+type Histogram struct {
+	family
+	bounds  []float64
+	samples map[string]*histogramSample
+}
+
+// Observe adds one value to the histogram with these label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, ok := h.samples[key]
+	if !ok {
+		s = &histogramSample{values: append([]string(nil), values...), counts: make([]uint64, len(h.bounds)+1)}
+		h.samples[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.bounds, v)]++
+	s.sum += v
+}
+
+// Since observes the seconds since start, for timing.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Count returns how many values the histogram with these label values has seen.
+func (h *Histogram) Count(values ...string) uint64 {
+	key := h.key(values)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	total := uint64(0)
+	if s, ok := h.samples[key]; ok {
+		for _, c := range s.counts {
+			total += c
+		}
+	}
+	return total
+}
+
+//anything a registry can write
+type metric interface {
+	header() *family
+	write(w io.Writer, openMetrics bool)
+}
+
+func (f *family) header() *family {
+	return f
+}
+
+func (f *family) writeHeader(w io.Writer, name string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind)
+	if f.help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(f.help))
+	}
+}
+
+//samples in the order of their label values, so scrapes are stable
+func sortedKeys[S any](samples map[string]S) []string {
+	keys := make([]string, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Counter) write(w io.Writer, openMetrics bool) {
+	//OpenMetrics names the family without the _total its samples have,
+	//the older Prometheus format names it with.
+	name := c.name
+	if !openMetrics {
+		name += "_total"
+	}
+	c.writeHeader(w, name)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range sortedKeys(c.samples) {
+		s := c.samples[key]
+		fmt.Fprintf(w, "%s_total%s %s\n", c.name, c.labelText(s.values), formatValue(s.value))
+	}
+}
+
+func (g *Gauge) write(w io.Writer, openMetrics bool) {
+	g.writeHeader(w, g.name)
+	if g.fn != nil {
+		fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.fn()))
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, key := range sortedKeys(g.samples) {
+		s := g.samples[key]
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelText(s.values), formatValue(s.value))
+	}
+}
+
+func (h *Histogram) write(w io.Writer, openMetrics bool) {
+	h.writeHeader(w, h.name)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, key := range sortedKeys(h.samples) {
+		s := h.samples[key]
+		total := uint64(0)
+		for i, bound := range h.bounds {
+			total += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(s.values, "le", formatValue(bound)), total)
+		}
+		total += s.counts[len(h.bounds)]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(s.values, "le", "+Inf"), total)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelText(s.values), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelText(s.values), total)
+	}
+}
+
+// Registry holds families by name and writes them out.
+type Registry struct {
+	metrics map[string]metric
+	lock    sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Default is the registry the leader and monitors use.
+var Default = NewRegistry()
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		letter := r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+//adds a family, panicking on a bad or taken name as that is a bug
+func (r *Registry) register(name string, m metric) {
+	if !validName(name) {
+		panic("metrics: bad name " + strconv.Quote(name))
+	}
+	for _, label := range m.header().labels {
+		if !validName(label) || label == "le" {
+			panic("metrics: bad label " + strconv.Quote(label) + " of " + name)
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, taken := r.metrics[name]; taken {
+		panic("metrics: " + name + " is registered twice")
+	}
+	r.metrics[name] = m
+}
+
+// Counter registers a counter. Its name should not end in _total, which its samples get.
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	c := &Counter{family: family{name: strings.TrimSuffix(name, "_total"), help: help, kind: counterType, labels: labels}, samples: make(map[string]*sample)}
+	r.register(c.name, c)
+	if len(labels) == 0 { //scraped as 0 before anything is counted
+		c.samples[""] = &sample{}
+	}
+	return c
+}
+
+// Gauge registers a gauge.
+func (r *Registry) Gauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{family: family{name: name, help: help, kind: gaugeType, labels: labels}, samples: make(map[string]*sample)}
+	r.register(name, g)
+	return g
+}
+
+// GaugeFunc registers a gauge without labels whose value is read from fn when scraped.
+func (r *Registry) GaugeFunc(name string, help string, fn func() float64) *Gauge {
+	g := &Gauge{family: family{name: name, help: help, kind: gaugeType}, fn: fn}
+	r.register(name, g)
+	return g
+}
+
+// Histogram registers a histogram with these upper bounds, DefBuckets if nil.
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	h := &Histogram{family: family{name: name, help: help, kind: histogramType, labels: labels}, bounds: bounds, samples: make(map[string]*histogramSample)}
+	r.register(name, h)
+	return h
+}
+
+// Write writes every family, in order of name. The OpenMetrics format ends
+// with # EOF, the older Prometheus text format does not.
+func (r *Registry) Write(w io.Writer, openMetrics bool) error {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]metric, len(names))
+	for i, name := range names {
+		families[i] = r.metrics[name]
+	}
+	r.lock.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range families {
+		m.write(bw, openMetrics)
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+const (
+	OpenMetricsType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	TextType        = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// Handler serves the registry, as OpenMetrics to scrapers that accept it
+// and in the Prometheus text format otherwise.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+		if openMetrics {
+			w.Header().Set("Content-Type", OpenMetricsType)
+		} else {
+			w.Header().Set("Content-Type", TextType)
+		}
+		r.Write(w, openMetrics)
+	})
+}
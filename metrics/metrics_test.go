@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	probes := r.Counter("probes_sent_total", "Probes sent.", "direction")
+	probes.Inc("forward")
+	probes.Add(2, "backward")
+	probes.Inc("forward")
+	r.Gauge("ranges", "Ranges.").Set(4)
+	r.GaugeFunc("interfaces", "Interfaces found.", func() float64 { return 12 })
+	h := r.Histogram("rpc_duration_seconds", "RPC latency.", []float64{1, 0.1}, "method")
+	h.Observe(0.05, "GetIPs")
+	h.Observe(0.5, "GetIPs")
+	h.Observe(3, "GetIPs")
+
+	buf := &bytes.Buffer{}
+	if err := r.Write(buf, true); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE interfaces gauge
+# HELP interfaces Interfaces found.
+interfaces 12
+# TYPE probes_sent counter
+# HELP probes_sent Probes sent.
+probes_sent_total{direction="backward"} 2
+probes_sent_total{direction="forward"} 2
+# TYPE ranges gauge
+# HELP ranges Ranges.
+ranges 4
+# TYPE rpc_duration_seconds histogram
+# HELP rpc_duration_seconds RPC latency.
+rpc_duration_seconds_bucket{method="GetIPs",le="0.1"} 1
+rpc_duration_seconds_bucket{method="GetIPs",le="1"} 2
+rpc_duration_seconds_bucket{method="GetIPs",le="+Inf"} 3
+rpc_duration_seconds_sum{method="GetIPs"} 3.55
+rpc_duration_seconds_count{method="GetIPs"} 3
+# EOF
+`
+	if buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf)
+	}
+	if h.Count("GetIPs") != 3 || probes.Value("forward") != 2 {
+		t.Errorf("unexpected values")
+	}
+}
+
+func TestHandlerFormats(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("results", "").Inc()
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Header().Get("Content-Type") != TextType || !strings.HasPrefix(rec.Body.String(), "# TYPE results_total counter\n") || strings.Contains(rec.Body.String(), "# EOF") {
+		t.Errorf("unexpected text format %q", rec.Body)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Type") != OpenMetricsType || !strings.HasSuffix(rec.Body.String(), "# EOF\n") {
+		t.Errorf("unexpected OpenMetrics %q", rec.Body)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("ranges", "")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+	r.Gauge("ranges", "")
+}
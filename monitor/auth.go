@@ -0,0 +1,129 @@
+package main
+
+//AUTHENTICATION
+//A leader started with -enroll only answers enrolled monitors. With -enroll
+//tokenfile, the monitor enrolls under its id with the token in that file and
+//sends the session it gets back with every call. If the leader no longer
+//knows the session, because it was replaced or the leader lost its state,
+//the monitor enrolls again and retries once. The first enrolment of an id
+//also gives the monitor a secret, which it needs to enrol that id again. With
+//-secret file it is kept in that file, so a restarted monitor keeps its id.
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"os"
+	"strings"
+	"sync"
+)
+
+const AUTH_FAILED = "not authenticated" //what the leader's errors for a refused session start with
+
+type RegisterArgs struct {
+	EnrollToken string
+	Name string
+	Region string
+	Secret string
+}
+
+type RegisterReply struct {
+	Id string
+	Session string
+	Secret string
+	Ok bool
+}
+
+var enrollToken string //set from the -enroll file, empty for leaders that do not enroll
+var session string //from the leader, sent with every call
+var secret string //proves to the leader this monitor owns its id
+var secretFile string //set by -secret, where secret is kept between runs
+var sessionLock sync.Mutex //the lease is renewed while other calls are made, guards session and secret
+
+//args that carry a session
+type authenticated interface {
+	withSession(session string)
+}
+
+func (a *IpArgs) withSession(s string) { a.Session = s }
+func (a *ResultArgs) withSession(s string) { a.Session = s }
+func (a *RenewArgs) withSession(s string) { a.Session = s }
+func (a *AliasArgs) withSession(s string) { a.Session = s }
+
+func readToken(path string) (string, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(text))
+	if token == "" {
+		return "", fmt.Errorf("%s holds no token", path)
+	}
+	return token, nil
+}
+
+//whether err is the leader refusing the session
+func isAuthFailed(err error) bool {
+	var serverErr rpc.ServerError
+	return errors.As(err, &serverErr) && strings.HasPrefix(string(serverErr), AUTH_FAILED)
+}
+
+//reads the secret kept by an earlier run, if there is one
+func loadSecret() error {
+	if secretFile == "" {
+		return nil
+	}
+	text, err := os.ReadFile(secretFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	secret = strings.TrimSpace(string(text))
+	return nil
+}
+
+//enrolls with the leader as id, keeping the session, and the secret if it is new
+func enroll(leader *rpc.Client, id string) error {
+	sessionLock.Lock()
+	args := RegisterArgs{EnrollToken: enrollToken, Name: id, Region: region, Secret: secret}
+	sessionLock.Unlock()
+	reply := RegisterReply{}
+	if err := leader.Call("Leader.Register", args, &reply); err != nil {
+		return err
+	}
+	sessionLock.Lock()
+	session = reply.Session
+	if reply.Secret != "" {
+		secret = reply.Secret
+	}
+	sessionLock.Unlock()
+	if reply.Secret != "" && secretFile != "" {
+		if err := os.WriteFile(secretFile, []byte(reply.Secret+"\n"), 0600); err != nil {
+			return fmt.Errorf("enrolled, but could not keep the secret: %v", err)
+		}
+	}
+	fmt.Println("enrolled with the leader as", reply.Id)
+	return nil
+}
+
+func currentSession() string {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	return session
+}
+
+//calls the leader with the session, enrolling again once if it is refused
+func callLeader(leader *rpc.Client, id string, method string, args authenticated, reply any) error {
+	args.withSession(currentSession())
+	err := leader.Call(method, args, reply)
+	if !isAuthFailed(err) || enrollToken == "" {
+		return err
+	}
+	if err := enroll(leader, id); err != nil {
+		return err
+	}
+	args.withSession(currentSession())
+	return leader.Call(method, args, reply)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+)
+
+func TestAuthFailedError(t *testing.T) {
+	if !isAuthFailed(rpc.ServerError("not authenticated: mon1 was revoked")) {
+		t.Errorf("the leader's error was not recognised")
+	}
+	if isAuthFailed(rpc.ServerError("lease lost: range 2 ran out")) || isAuthFailed(errors.New("not authenticated")) {
+		t.Errorf("only the leader's refusals mean the session was refused")
+	}
+	args := ResultArgs{}
+	var a authenticated = &args
+	a.withSession("abc")
+	if args.Session != "abc" {
+		t.Errorf("the session was not set")
+	}
+}
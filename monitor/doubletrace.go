@@ -6,6 +6,7 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
@@ -13,7 +14,10 @@ import (
 	"sync"
 	"syscall"
 	"time"
+	"github.com/arieltraver/ari_traceroute/alias"
+	"github.com/arieltraver/ari_traceroute/graph"
 	"github.com/arieltraver/ari_traceroute/set"
+	"github.com/arieltraver/ari_traceroute/tracert"
 	"net/rpc"
 	"os"
 	//"net/http"
@@ -29,41 +33,75 @@ const DEFAULT_RETRIES = 3
 const DEFAULT_PACKET_SIZE = 52
 const FLOOR = 6
 const CEILING = 12
+const PAUSE_RETRY = 5 * time.Second //how long to wait before asking a paused leader again
 
 var ipRange [][4]byte
-var GSS *set.SafeSet
-var LSS *set.SafeSet
-var newNodes *set.SafeSet
+var GSS *stopSet
+var LSS set.Container[set.StopKey] //a ShardedSet, or a SafeSet of an ExpiringSet when -lssage is given
+var lssPolicy set.AgePolicy //set by -lssage
+var stopPrefix int //destination prefix length of GSS keys, sent by the leader
+var heldStops = map[int]*heldStopSet{} //the leader's stop sets of ranges probed before
+var newNodes *set.SafeSet[[4]byte] //a RoaringSet, so it stays small when sent to the leader
+var sightings *set.CountMin[[4]byte] //replies per interface in the current range
+var capture *traceroute.PcapWriter //nil unless -pcap is given
+var resolveAliases bool //set by -alias
+var region string //set by -region
 
 type Monitor int
 
 type IpArgs struct {
 	ProbeId string
+	Held map[int]int //range index to the stop set version we already have
+	Region string //where this monitor is, set by -region
+	Session string
 }
 
 type IpReply struct {
 	Ips [][4]byte
 	Index int
-	Stops *set.StringSet
+	Stops set.Container[set.StopKey] //a Set or a BloomFilter, whichever the leader uses, or the changes to it
+	StopsFull bool //false when Stops is only what changed since the held version
+	StopsVersion int
+	StopPrefix int //0 from older leaders, meaning exact destinations
+	Paused bool //the leader is not lending ranges for now, ask again later
+	LeaseId string //empty from older leaders
+	Deadline time.Time
+	LeaseTerm time.Duration
 	Ok bool
 }
 
 type ResultArgs struct {
-	NewGSS *set.StringSet
-	News *set.StringSet
+	NewGSS set.Container[set.StopKey]
+	News set.Container[[4]byte]
+	Seen *set.HyperLogLog[[4]byte] //sketch of every interface this monitor has found
+	Sightings *set.CountMin[[4]byte] //how often each interface answered while probing this range
+	Links []graph.Link //consecutive hops seen since the last transfer
 	Id string
 	Index int
+	Session string
+	LeaseId string
+	Partial bool //the lease was lost before the range was finished
 }
 
 type ResultReply struct {
-	News *set.StringSet
-	NewGSS *set.StringSet
+	News set.Container[[4]byte]
+	NewGSS set.Container[set.StopKey]
+	Ok bool
+}
+
+type AliasArgs struct {
+	Id string
+	Session string
+	Routers [][][4]byte
+}
+
+type AliasReply struct {
 	Ok bool
 }
 
 
 func dialLeader(address string) (*rpc.Client, error) {
-	client, err := rpc.DialHTTP("tcp", address)
+	client, err := dial(address)
 	if err != nil {
 		connectTimer := time.NewTimer(800 * time.Millisecond)
 		for {
@@ -71,7 +109,7 @@ func dialLeader(address string) (*rpc.Client, error) {
 			case <- connectTimer.C:
 				return nil, errors.New("failed to connect within time limit")
 			default:
-				client, err = rpc.DialHTTP("tcp", address)
+				client, err = dial(address)
 				if err == nil {
 					return client, nil
 				} else {
@@ -84,11 +122,23 @@ func dialLeader(address string) (*rpc.Client, error) {
 }
 
 func getIpRange(leader *rpc.Client, id string) (int, bool) {
+	held := make(map[int]int, len(heldStops))
+	for index, h := range heldStops {
+		held[index] = h.version
+	}
 	arguments := IpArgs {
 		ProbeId:id,
+		Held:held,
+		Region:region,
 	}
 	reply := IpReply{}
-	err := leader.Call("Leader.GetIPs", arguments, &reply)
+	err := callLeader(leader, id, "Leader.GetIPs", &arguments, &reply)
+	for err == nil && reply.Paused {
+		fmt.Println("the leader has nothing to probe yet, waiting...")
+		time.Sleep(PAUSE_RETRY)
+		reply = IpReply{}
+		err = callLeader(leader, id, "Leader.GetIPs", &arguments, &reply)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -96,30 +146,67 @@ func getIpRange(leader *rpc.Client, id string) (int, bool) {
 		return -1, false
 	}
 	ipRange = reply.Ips
-	GSS.ChangeSetTo(reply.Stops)
+	stopPrefix = reply.StopPrefix
+	lease = rangeLease{id: reply.LeaseId, term: reply.LeaseTerm}
+	leaseLost.Store(false)
+	stops, err := catchUp(reply.Index, reply.Stops, reply.StopsFull, reply.StopsVersion)
+	if err != nil {
+		log.Fatal(err)
+	}
+	GSS.ChangeSetTo(stops)
 	return reply.Index, true
 }
 
 /**return the results of a trace to the leader**/
 func sendIPRange(leader *rpc.Client, index int, id string) bool {
 	fmt.Println(GSS.ToCSV())
-	arguments := ResultArgs{NewGSS:GSS.Set().(*set.StringSet),News:newNodes.Set().(*set.StringSet),Id:id, Index:index}
+	seen := set.NewHyperLogLog[[4]byte](set.HLL_PRECISION)
+	newNodes.Each(func(addr [4]byte) bool {
+		seen.Add(addr)
+		return true
+	})
+	arguments := ResultArgs{NewGSS:GSS.Set(),News:newNodes.Set(),Seen:seen,Sightings:sightings,Links:foundLinks.take(),Id:id, Index:index, LeaseId:lease.id, Partial:leaseLost.Load()}
 	reply := ResultReply{}
-	err := leader.Call("Leader.TransferResults", arguments, &reply)
+	err := callLeader(leader, id, "Leader.TransferResults", &arguments, &reply)
+	if isLeaseLost(err) { //the lease ran out just before, what was found is still worth having
+		fmt.Println("lost the lease on range", index, "sending partial results")
+		leasesLost.Inc()
+		arguments.Partial = true
+		reply = ResultReply{}
+		err = callLeader(leader, id, "Leader.TransferResults", &arguments, &reply)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 	if reply.Ok {
 		fmt.Println("Transfer success")
 	}
+	sightings = set.NewCountMin[[4]byte](set.COUNTMIN_WIDTH, set.COUNTMIN_DEPTH) //the leader adds them up
 	return reply.Ok
 }
 
+/**groups the interfaces found so far into routers and sends them to the leader**/
+func sendAliases(leader *rpc.Client, id string) bool {
+	addrs := [][4]byte{}
+	newNodes.Each(func(addr [4]byte) bool {
+		addrs = append(addrs, addr)
+		return true
+	})
+	groups := alias.Resolve(alias.NewSocketProber(), addrs, &alias.ResolveOptions{})
+	arguments := AliasArgs{Id:id, Routers:groups.Routers(1)}
+	reply := AliasReply{}
+	err := callLeader(leader, id, "Leader.TransferAliases", &arguments, &reply)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("found", len(arguments.Routers), "routers with aliases")
+	return reply.Ok
+}
 
 /**invoked by leader on this node. sends leader the results of probing
 func (*Monitor) GetResults(args ResultArgs, reply *ResultReply) error {
-	reply.News = newNodes.Set().(*set.StringSet)
-	reply.NewGSS = GSS.Set().(*set.StringSet)
+	reply.News = newNodes.Set()
+	reply.NewGSS = GSS.Set()
 	GSS.Wipe()
 	newNodes.Wipe()
 	//LSS is never wiped because it's useful to this probe.
@@ -193,6 +280,8 @@ type TracerouteOptions struct {
 	timeoutMs  int
 	retries    int
 	packetSize int
+	noLookup   bool
+	stopPrefix int
 }
 
 func (options *TracerouteOptions) Port() int {
@@ -257,6 +346,28 @@ func (options *TracerouteOptions) SetPacketSize(packetSize int) {
 	options.packetSize = packetSize
 }
 
+// NoLookup reports whether reverse DNS lookups of hop addresses are skipped.
+func (options *TracerouteOptions) NoLookup() bool {
+	return options.noLookup
+}
+
+func (options *TracerouteOptions) SetNoLookup(noLookup bool) {
+	options.noLookup = noLookup
+}
+
+// StopPrefix is how many bits of the destination GSS keys keep, so one entry
+// can stop probes to a whole prefix of destinations.
+func (options *TracerouteOptions) StopPrefix() int {
+	if options.stopPrefix == 0 {
+		options.stopPrefix = set.EXACT_PREFIX
+	}
+	return options.stopPrefix
+}
+
+func (options *TracerouteOptions) SetStopPrefix(stopPrefix int) {
+	options.stopPrefix = stopPrefix
+}
+
 // TracerouteHop type
 type TracerouteHop struct {
 	Success     bool
@@ -265,6 +376,8 @@ type TracerouteHop struct {
 	N           int
 	ElapsedTime time.Duration
 	TTL         int
+	SentAt      time.Time //absolute, to line up results between monitors
+	ReceivedAt  time.Time //kernel receive time where available
 }
 
 func addressString(add [4]byte) string {
@@ -289,6 +402,20 @@ type TracerouteResult struct {
 	Hops               []TracerouteHop
 }
 
+//records a probe just sent on sendSocket, if capture is turned on.
+func captureProbe(sendSocket int, sent time.Time, src [4]byte, dest [4]byte, port int, ttl int, payload []byte) {
+	if err := traceroute.CaptureProbe(capture, sendSocket, sent, src, dest, port, ttl, payload); err != nil {
+		log.Println("capture failed:", err)
+	}
+}
+
+//records a received ICMP reply, if capture is turned on.
+func captureReply(at time.Time, pkt []byte) {
+	if err := traceroute.CaptureReply(capture, at, pkt); err != nil {
+		log.Println("capture failed:", err)
+	}
+}
+
 func notify(hop TracerouteHop, channels []chan TracerouteHop) {
 	for _, c := range channels {
 		c <- hop
@@ -320,6 +447,7 @@ func probeAddr(wg *sync.WaitGroup, ip [4]byte) {
 	defer wg.Done()
 	options := &TracerouteOptions{}
 	options.SetMaxHopsRandom(FLOOR, CEILING)
+	options.SetStopPrefix(stopPrefix)
 	sourceAddr, err := socketAddr() //possible cause of glitch
 	if err != nil {
 		log.Fatal(err) //Todo: replace with non fatal err & return
@@ -331,7 +459,7 @@ func probeAddr(wg *sync.WaitGroup, ip [4]byte) {
 	}
 	backward := make(chan TracerouteHop, options.maxHops)
 	//
-	_, err = probeBackwards(sourceAddr,forwardHops.Hops, options, backward)
+	backHops, err := probeBackwards(sourceAddr,forwardHops.Hops, options, backward)
 	if err != nil {
 		log.Fatal(err) //TODO: do not crash the whole program if one trace fails.
 	}
@@ -339,9 +467,10 @@ func probeAddr(wg *sync.WaitGroup, ip [4]byte) {
 	//TODO: check for null nodes.
 	//add all new nodes to the set
 	for _, hop := range(forwardHops.Hops) {
-		newNodes.Add(hop.AddressString())
+		newNodes.Add(hop.Address)
+		sightings.Add(hop.Address, 1)
 	}
-	//TODO: add new (sorted) ip,ip edges to the edge set.
+	foundLinks.add(linksOf(forwardHops.Hops, backHops.Hops))
 }
 
 
@@ -369,10 +498,13 @@ func probeForward(socketAddr [4]byte, dest [4]byte, options *TracerouteOptions,
 	ttl := 0
 	retry := 0
 	for {
+		if leaseLost.Load() { //the range was taken back, stop here
+			closeNotify(c)
+			return result, nil
+		}
 
 		ttl += 1
 		//log.Println("TTL: ", ttl)
-		start := time.Now()
 
 		// Set up the socket to receive inbound packets
 		recvSocket, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
@@ -395,6 +527,8 @@ func probeForward(socketAddr [4]byte, dest [4]byte, options *TracerouteOptions,
 		syscall.SetsockoptInt(sendSocket, 0x0, syscall.IP_TTL, ttl)
 		// This sets the timeout to wait for a response from the remote host
 		syscall.SetsockoptTimeval(recvSocket, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+		// Have the kernel stamp replies as they arrive
+		traceroute.EnableRecvTimestamps(recvSocket)
 
 		defer syscall.Close(recvSocket)
 		defer syscall.Close(sendSocket)
@@ -410,40 +544,49 @@ func probeForward(socketAddr [4]byte, dest [4]byte, options *TracerouteOptions,
 			  print out the checksum each time
 		*/
 		// Send a single null byte UDP packet
-		syscall.Sendto(sendSocket, []byte{0x0}, 0, &syscall.SockaddrInet4{Port: options.Port(), Addr: dest})
+		payload := []byte{0x0}
+		sent := time.Now()
+		syscall.Sendto(sendSocket, payload, 0, &syscall.SockaddrInet4{Port: options.Port(), Addr: dest})
+		probesSent.Inc(FORWARD)
+		captureProbe(sendSocket, sent, socketAddr, dest, options.Port(), ttl, payload)
 
 		var p = make([]byte, options.PacketSize())
-		n, from, err := syscall.Recvfrom(recvSocket, p, 0)
-		elapsed := time.Since(start)
+		n, from, received, err := traceroute.RecvTimestamped(recvSocket, p)
 		if err == nil {
+			captureReply(received, p[:n])
+			repliesReceived.Inc(icmpType(p[:n]))
 			currAddr := from.(*syscall.SockaddrInet4).Addr
 
-			hop := TracerouteHop{Success: true, Address: currAddr, N: n, ElapsedTime: elapsed, TTL: ttl}
+			hop := TracerouteHop{Success: true, Address: currAddr, N: n, ElapsedTime: received.Sub(sent), TTL: ttl, SentAt: sent, ReceivedAt: received}
 
 			// TODO: this reverse lookup appears to have some standard timeout that is relatively
 			// high. Consider switching to something where there is greater control.
-			currHost, err := net.LookupAddr(hop.AddressString())
-			if err == nil {
-				hop.Host = currHost[0]
+			if !options.NoLookup() {
+				currHost, err := net.LookupAddr(hop.AddressString())
+				if err == nil {
+					hop.Host = currHost[0]
+				}
 			}
 
 			notify(hop, c)
 			
 			retry = 0
 
-			hopDestString := hop.AddressString() + "-" + addressString(dest)
+			hopDest := set.NewPrefixStopKey(currAddr, dest, options.StopPrefix())
 
 			// modification added here to stop if it hits node in GSS or LSS
-			if ttl >= options.MaxHops() || currAddr == dest || GSS.Contains(hopDestString) {
-				if GSS.Contains(hopDestString) {
-					fmt.Println("found seen node", hopDestString )
+			if ttl >= options.MaxHops() || currAddr == dest || GSS.Contains(hopDest) {
+				if GSS.Contains(hopDest) {
+					fmt.Println("found seen node", hopDest)
+					stopSetHits.Inc("global")
 				}
 				closeNotify(c)
 				return result, nil
 			}
 			result.Hops = append(result.Hops, hop)
-			GSS.Add(hopDestString) //add to global stop set
+			GSS.Add(hopDest) //add to global stop set
 		} else {
+			probeTimeouts.Inc(FORWARD)
 			retry += 1
 			if retry > options.Retries() {
 				notify(TracerouteHop{Success: false, TTL: ttl}, c)
@@ -467,7 +610,6 @@ each(hop, probe) address pair is added to both GSS and LSS.
 */
 func probeBackwards(socketAddr [4]byte, forwardHops []TracerouteHop, options *TracerouteOptions, c ...chan TracerouteHop) (result TracerouteResult, err error) {
 	fmt.Println("probing backwards")
-	source := addressString(socketAddr)
 	result.Hops = make([]TracerouteHop, 0, len(forwardHops)) //prevent resizing
 
 	timeoutMs := (int64)(options.TimeoutMs())
@@ -477,11 +619,16 @@ func probeBackwards(socketAddr [4]byte, forwardHops []TracerouteHop, options *Tr
 	currentHop := len(forwardHops) - 2
 	if currentHop < 0 {return}
 	for {
+		if leaseLost.Load() { //the range was taken back, stop here
+			closeNotify(c)
+			return result, nil
+		}
 		hopAddr := forwardHops[currentHop].Address //probe the address
 		fmt.Println("backwards:", hopAddr)
-		resultStr := addressString(hopAddr) +"-"+ source
-		if LSS.Contains(resultStr) {
+		hopSource := set.NewStopKey(hopAddr, socketAddr)
+		if LSS.Contains(hopSource) {
 			fmt.Println("found visited already")
+			stopSetHits.Inc("local")
 			return
 		}
 		// Set up the socket to receive inbound packets
@@ -505,6 +652,8 @@ func probeBackwards(socketAddr [4]byte, forwardHops []TracerouteHop, options *Tr
 		syscall.SetsockoptInt(sendSocket, 0x0, syscall.IP_TTL, currentHop + 1)
 		// This sets the timeout to wait for a response from the remote host
 		syscall.SetsockoptTimeval(recvSocket, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+		// Have the kernel stamp replies as they arrive
+		traceroute.EnableRecvTimestamps(recvSocket)
 
 		defer syscall.Close(recvSocket)
 		defer syscall.Close(sendSocket)
@@ -521,29 +670,35 @@ func probeBackwards(socketAddr [4]byte, forwardHops []TracerouteHop, options *Tr
 			  print out the checksum each time
 		*/
 		// Send a single null byte UDP packet
-		start := time.Now()
-		syscall.Sendto(sendSocket, []byte{0x0}, 0, &syscall.SockaddrInet4{Port: options.Port(), Addr: hopAddr})
+		payload := []byte{0x0}
+		sent := time.Now()
+		syscall.Sendto(sendSocket, payload, 0, &syscall.SockaddrInet4{Port: options.Port(), Addr: hopAddr})
+		probesSent.Inc(BACKWARD)
+		captureProbe(sendSocket, sent, socketAddr, hopAddr, options.Port(), currentHop + 1, payload)
 
 		var p = make([]byte, options.PacketSize())
-		n, from, err := syscall.Recvfrom(recvSocket, p, 0)
-		elapsed := time.Since(start)
+		n, from, received, err := traceroute.RecvTimestamped(recvSocket, p)
 		if err == nil {
+			captureReply(received, p[:n])
+			repliesReceived.Inc(icmpType(p[:n]))
 			currAddr := from.(*syscall.SockaddrInet4).Addr
 
-			hop := TracerouteHop{Success: true, Address: currAddr, N: n, ElapsedTime: elapsed, TTL: currentHop + 1}
+			hop := TracerouteHop{Success: true, Address: currAddr, N: n, ElapsedTime: received.Sub(sent), TTL: currentHop + 1, SentAt: sent, ReceivedAt: received}
 
 			// TODO: this reverse lookup appears to have some standard timeout that is relatively
 			// high. Consider switching to something where there is greater control.
-			currHost, err2 := net.LookupAddr(hop.AddressString())
-			if err2 == nil {
-				hop.Host = currHost[0]
+			if !options.NoLookup() {
+				currHost, err2 := net.LookupAddr(hop.AddressString())
+				if err2 == nil {
+					hop.Host = currHost[0]
+				}
 			}
 
 			notify(hop, c)
 
 			result.Hops = append(result.Hops, hop)
-			GSS.Add(resultStr) //modification: add to GSS while probing back
-			LSS.Add(resultStr) //add to LSS while probing back
+			GSS.Add(hopSource) //modification: add to GSS while probing back
+			LSS.Add(hopSource) //add to LSS while probing back
 
 			currentHop--
 			retry = 0
@@ -553,6 +708,7 @@ func probeBackwards(socketAddr [4]byte, forwardHops []TracerouteHop, options *Tr
 				return result, nil
 			}
 		} else {
+			probeTimeouts.Inc(BACKWARD)
 			retry += 1
 			if retry > options.Retries() {
 				notify(TracerouteHop{Success: false, TTL: currentHop}, c)
@@ -565,10 +721,29 @@ func probeBackwards(socketAddr [4]byte, forwardHops []TracerouteHop, options *Tr
 }
 
 
+//the local stop set, which is kept across ranges. Only an expiring one
+//forgets the paths to this monitor as routes change.
+func newLocalStopSet() set.Container[set.StopKey] {
+	if lssPolicy != (set.AgePolicy{}) {
+		return set.NewSafeExpiringSet[set.StopKey](lssPolicy)
+	}
+	return set.NewShardedStopKeySet()
+}
+
+//every so often, drops the local stop set entries that have expired.
+func compactLocalStops(every time.Duration) {
+	for range time.Tick(every) {
+		if expiring, ok := LSS.(*set.SafeSet[set.StopKey]); ok {
+			expiring.Compact()
+		}
+	}
+}
+
 func testJustProbes(addr [4]byte) {
-	GSS = set.NewSafeStringSet()
-	LSS = set.NewSafeStringSet()
-	newNodes = set.NewSafeStringSet()
+	GSS = newStopSet()
+	LSS = newLocalStopSet()
+	newNodes = set.NewSafeRoaringSet()
+	sightings = set.NewCountMin[[4]byte](set.COUNTMIN_WIDTH, set.COUNTMIN_DEPTH)
 	options := &TracerouteOptions{}
 	options.SetMaxHopsRandom(FLOOR, CEILING)
 	fmt.Println("max hops is", options.maxHops)
@@ -596,9 +771,10 @@ func testJustProbes(addr [4]byte) {
 }
 
 func testConcurrent() {
-	GSS = set.NewSafeStringSet()
-	LSS = set.NewSafeStringSet()
-	newNodes = set.NewSafeStringSet()
+	GSS = newStopSet()
+	LSS = newLocalStopSet()
+	newNodes = set.NewSafeRoaringSet()
+	sightings = set.NewCountMin[[4]byte](set.COUNTMIN_WIDTH, set.COUNTMIN_DEPTH)
 	ips := [][4]byte {
 		{192, 124, 249, 164},
 		{107, 21, 104, 61},
@@ -624,14 +800,23 @@ func connect(port string) {
 
 func loop(id string) {
 	//connect to the leader node.
-	leader, err := dialLeader(ADDRESS_STRING)
+	leader, err := dialLeader(leaderAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println("connected to:", ADDRESS_STRING)
-	GSS = set.NewSafeStringSet()
-	LSS = set.NewSafeStringSet()
-	newNodes = set.NewSafeStringSet()
+	fmt.Println("connected to:", leaderAddr)
+	if enrollToken != "" {
+		if err := enroll(leader, id); err != nil {
+			log.Fatal(err)
+		}
+	}
+	GSS = newStopSet()
+	LSS = newLocalStopSet()
+	newNodes = set.NewSafeRoaringSet()
+	sightings = set.NewCountMin[[4]byte](set.COUNTMIN_WIDTH, set.COUNTMIN_DEPTH)
+	if lssPolicy.MaxAge > 0 {
+		go compactLocalStops(lssPolicy.MaxAge / 4)
+	}
 	//continue to request ranges until you run out.
 	for {
 		indx, ok := getIpRange(leader, id)
@@ -639,19 +824,64 @@ func loop(id string) {
 			fmt.Println("halting the loop...")
 			return
 		}
+		start := time.Now()
+		done := make(chan struct{})
+		go keepLease(leader, id, indx, lease, done)
 		sendProbes()
+		close(done)
+		rangeDuration.Since(start)
 		sendIPRange(leader, indx, id)
+		if resolveAliases {
+			sendAliases(leader, id)
+		}
 	}
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("usage: sudo go run doubletrace id")
+	pcapPath := flag.String("pcap", "", "write every probe and reply to this pcap file")
+	flag.BoolVar(&resolveAliases, "alias", false, "group discovered interfaces into routers after each range")
+	flag.DurationVar(&lssPolicy.MaxAge, "lssage", 0, "forget local stop set entries not seen again within this long, e.g. 1h. 0 keeps them forever")
+	flag.StringVar(&region, "region", "", "where this monitor is, e.g. eu-west, for a leader started with -assign region")
+	enrollFile := flag.String("enroll", "", "file holding the token to enroll with a leader that needs it")
+	flag.StringVar(&secretFile, "secret", "", "file to keep the secret the leader gives on enrolment in, so the id can enrol again after a restart")
+	metricsAddr := flag.String("metrics", "", "serve metrics at this address, e.g. :9101")
+	flag.StringVar(&leaderAddr, "leader", ADDRESS_STRING, "address of the leader")
+	flag.BoolVar(&useTLS, "tls", false, "talk to the leader over TLS, checking it against the system roots unless -tlsca or -tlspin is given")
+	tlsFlags.AddFlags(flag.CommandLine, "leader")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Println("usage: sudo go run doubletrace [-pcap file] [-alias] [-lssage duration] [-metrics addr] [-region name] [-enroll tokenfile [-secret file]] [-leader addr] [-tls] [-tlsca file] [-tlscert file -tlskey file] [-tlspin key] id")
 		return
-	} else {
-		id := os.Args[1]
-		loop(id)
 	}
+	if *enrollFile != "" {
+		token, err := readToken(*enrollFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		enrollToken = token
+		if err := loadSecret(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := setupTLS(leaderAddr); err != nil {
+		log.Fatal(err)
+	}
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
+	if *pcapPath != "" {
+		file, err := os.Create(*pcapPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		capture, err = traceroute.NewPcapWriter(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	id := flag.Arg(0)
+	loop(id)
 }
 
 /*
@@ -0,0 +1,85 @@
+package main
+
+//LEASES
+//The leader lends each range under a lease that runs out unless it is
+//renewed. While the range is probed, keepLease renews it every third of its
+//term. If the leader says the lease was lost, because it ran out or was
+//revoked, the traces in progress stop early and what they found is sent as
+//partial results.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/rpc"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const LEASE_LOST = "lease lost" //what the leader's errors for a lost lease start with
+const MIN_RENEW = time.Second //shortest wait between renewals
+
+type RenewArgs struct {
+	Id string
+	Index int
+	LeaseId string
+	Session string
+}
+
+type RenewReply struct {
+	Deadline time.Time
+	Term time.Duration
+	Ok bool
+}
+
+//the lease on the range being probed
+type rangeLease struct {
+	id string //empty from leaders without leases, which are never renewed
+	term time.Duration
+}
+
+var lease rangeLease
+var leaseLost atomic.Bool //set when the leader takes the range back, which ends the traces early
+
+//whether err is the leader saying a lease was lost
+func isLeaseLost(err error) bool {
+	var serverErr rpc.ServerError
+	return errors.As(err, &serverErr) && strings.HasPrefix(string(serverErr), LEASE_LOST)
+}
+
+//how long to wait before renewing a lease of this term
+func renewWait(term time.Duration) time.Duration {
+	if term/3 < MIN_RENEW {
+		return MIN_RENEW
+	}
+	return term / 3
+}
+
+//renews the lease on a range until done is closed or the lease is lost
+func keepLease(leader *rpc.Client, id string, index int, held rangeLease, done chan struct{}) {
+	if held.id == "" {
+		return
+	}
+	wait := renewWait(held.term)
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(wait):
+		}
+		reply := RenewReply{}
+		err := callLeader(leader, id, "Leader.RenewLease", &RenewArgs{Id: id, Index: index, LeaseId: held.id}, &reply)
+		if isLeaseLost(err) {
+			fmt.Println("lost the lease on range", index, "stopping early:", err)
+			leasesLost.Inc()
+			leaseLost.Store(true)
+			return
+		}
+		if err != nil { //tried again next time, and the leader says if it was too late
+			log.Println("could not renew the lease:", err)
+			continue
+		}
+		wait = renewWait(reply.Term)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestLeaseLostError(t *testing.T) {
+	if !isLeaseLost(rpc.ServerError("lease lost: the lease on range 3 ran out")) {
+		t.Errorf("the leader's error was not recognised")
+	}
+	if isLeaseLost(rpc.ServerError("no such range")) || isLeaseLost(errors.New("lease lost")) || isLeaseLost(nil) {
+		t.Errorf("only the leader's lease errors mean the lease was lost")
+	}
+	if renewWait(90*time.Second) != 30*time.Second || renewWait(time.Second) != MIN_RENEW {
+		t.Errorf("unexpected renewal waits")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/arieltraver/ari_traceroute/graph"
+)
+
+//links found since the last transfer to the leader
+type linkList struct {
+	links []graph.Link
+	lock sync.Mutex
+}
+
+var foundLinks = &linkList{}
+
+func (l *linkList) add(links []graph.Link) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.links = append(l.links, links...)
+}
+
+//the links found so far, leaving none
+func (l *linkList) take() []graph.Link {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	links := l.links
+	l.links = nil
+	return links
+}
+
+//the links between hops of one trace that answered at consecutive TTLs.
+//the forward and backward hops of a trace may repeat TTLs; the first answer counts.
+//a hop that did not answer breaks the path, since we can't know what was there.
+func linksOf(traces ...[]TracerouteHop) []graph.Link {
+	byTTL := map[int]TracerouteHop{}
+	for _, hops := range traces {
+		for _, hop := range hops {
+			if _, ok := byTTL[hop.TTL]; !ok && hop.Success {
+				byTTL[hop.TTL] = hop
+			}
+		}
+	}
+	ttls := make([]int, 0, len(byTTL))
+	for ttl := range byTTL {
+		ttls = append(ttls, ttl)
+	}
+	sort.Ints(ttls)
+	links := []graph.Link{}
+	for i := 1; i < len(ttls); i++ {
+		if ttls[i] != ttls[i-1]+1 {
+			continue
+		}
+		from, to := byTTL[ttls[i-1]], byTTL[ttls[i]]
+		links = append(links, graph.Link{From: from.Address, To: to.Address, TTL: to.TTL, RTT: to.ElapsedTime, At: to.SentAt})
+	}
+	return links
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLinksOfTrace(t *testing.T) {
+	hop := func(ttl int, last byte, ok bool) TracerouteHop {
+		return TracerouteHop{TTL: ttl, Address: [4]byte{10, 0, 0, last}, Success: ok}
+	}
+	forward := []TracerouteHop{hop(4, 4, true), hop(5, 5, false), hop(6, 6, true), hop(7, 7, true)}
+	backward := []TracerouteHop{hop(4, 99, true), hop(3, 3, true), hop(2, 2, true)}
+	links := linksOf(forward, backward)
+	want := [][2]byte{{2, 3}, {3, 4}, {6, 7}}
+	if len(links) != len(want) {
+		t.Fatalf("expected %d links, got %+v", len(want), links)
+	}
+	for i, link := range links {
+		if link.From[3] != want[i][0] || link.To[3] != want[i][1] || link.TTL != int(want[i][1]) {
+			t.Errorf("link %d: expected %v, got %v-%v", i, want[i], link.From, link.To)
+		}
+	}
+}
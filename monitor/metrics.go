@@ -0,0 +1,63 @@
+package main
+
+//METRICS
+//With -metrics addr, the monitor serves its metrics in the OpenMetrics format
+//at addr/metrics for Prometheus to scrape. Stop set hits are the probes
+//Doubletree saved: each one ended a trace that would otherwise have gone on.
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/arieltraver/ari_traceroute/metrics"
+)
+
+var (
+	probesSent = metrics.Default.Counter("monitor_probes_sent", "Probes sent, forwards to a target or backwards to a hop.", "direction")
+	repliesReceived = metrics.Default.Counter("monitor_replies", "ICMP replies to probes, by ICMP type.", "type")
+	probeTimeouts = metrics.Default.Counter("monitor_probe_timeouts", "Probes that got no reply in time.", "direction")
+	stopSetHits = metrics.Default.Counter("monitor_stop_set_hits", "Traces ended early by a stop set, the global one forwards or the local one backwards.", "set")
+	leasesLost = metrics.Default.Counter("monitor_leases_lost", "Ranges whose leases were lost before their results were sent.")
+	rangeDuration = metrics.Default.Histogram("monitor_range_duration_seconds", "Time taken to probe a whole range.", metrics.ExponentialBuckets(1, 2, 12))
+)
+
+const (
+	FORWARD = "forward"
+	BACKWARD = "backward"
+)
+
+//names of the ICMP types a probe can get back
+var icmpTypes = map[byte]string{
+	0: "echo_reply",
+	3: "destination_unreachable",
+	4: "source_quench",
+	5: "redirect",
+	11: "time_exceeded",
+	12: "parameter_problem",
+}
+
+//the ICMP type of a reply read from a raw socket, which starts with its IPv4 header
+func icmpType(pkt []byte) string {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != 1 {
+		return "unknown"
+	}
+	headerLen := int(pkt[0]&0x0f) * 4
+	if headerLen < 20 || len(pkt) <= headerLen {
+		return "unknown"
+	}
+	if name, ok := icmpTypes[pkt[headerLen]]; ok {
+		return name
+	}
+	return strconv.Itoa(int(pkt[headerLen]))
+}
+
+//serves /metrics on addr in the background
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	go func() {
+		log.Println(http.ListenAndServe(addr, mux))
+	}()
+	log.Printf("serving metrics on " + addr)
+}
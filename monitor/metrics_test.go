@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestMetricsICMPType(t *testing.T) {
+	reply := make([]byte, 56)
+	reply[0] = 0x45 //IPv4, 20 byte header
+	reply[9] = 1 //ICMP
+	reply[20] = 11
+	if got := icmpType(reply); got != "time_exceeded" {
+		t.Errorf("expected time_exceeded, got %s", got)
+	}
+	reply[0] = 0x46 //24 byte header, with options
+	reply[24] = 3
+	if got := icmpType(reply); got != "destination_unreachable" {
+		t.Errorf("expected destination_unreachable, got %s", got)
+	}
+	reply[24] = 42
+	if got := icmpType(reply); got != "42" {
+		t.Errorf("expected 42, got %s", got)
+	}
+	if got := icmpType(reply[:10]); got != "unknown" {
+		t.Errorf("expected unknown for a short packet, got %s", got)
+	}
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/arieltraver/ari_traceroute/set"
+	"github.com/arieltraver/ari_traceroute/test/netns"
+)
+
+func TestDoubletreeNetns(t *testing.T) {
+	if err := netns.Available(); err != nil {
+		t.Skip(err)
+	}
+	chain, err := netns.NewChain("dtr", 4)
+	defer chain.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	GSS = newStopSet()
+	LSS = set.NewShardedStopKeySet()
+	newNodes = set.NewSafeRoaringSet()
+
+	options := &TracerouteOptions{}
+	options.SetMaxHops(len(chain.Hops) + 2)
+	options.SetRetries(1)
+	options.SetNoLookup(true) //no DNS inside the namespaces
+	var forward, backward, again TracerouteResult
+	err = chain.Run(chain.Source, func() error {
+		source, err := socketAddr()
+		if err != nil {
+			return err
+		}
+		if source != chain.SourceAddr {
+			t.Errorf("expected to send from %v, got %v", chain.SourceAddr, source)
+		}
+		forward, err = probeForward(source, chain.DestAddr, options)
+		if err != nil {
+			return err
+		}
+		backward, err = probeBackwards(source, forward.Hops, options)
+		if err != nil {
+			return err
+		}
+		again, err = probeForward(source, chain.DestAddr, options)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//forward probing stops on reaching the destination, which is not recorded
+	routers := chain.Hops[:len(chain.Hops)-1]
+	if len(forward.Hops) != len(routers) {
+		t.Fatalf("expected %d forward hops, got %v", len(routers), forward.Hops)
+	}
+	for i, hop := range forward.Hops {
+		if hop.Address != routers[i] || hop.TTL != i+1 {
+			t.Errorf("forward hop %d: expected %v, got %v ttl %d", i, routers[i], hop.Address, hop.TTL)
+		}
+		if !GSS.Contains(set.NewStopKey(hop.Address, chain.DestAddr)) {
+			t.Errorf("global stop set is missing %v", hop.AddressString())
+		}
+	}
+
+	//backward probing walks from the second to last hop towards the source
+	expected := [][4]byte{}
+	for i := len(forward.Hops) - 2; i > 0; i-- {
+		expected = append(expected, forward.Hops[i].Address)
+	}
+	if len(backward.Hops) != len(expected) {
+		t.Fatalf("expected %d backward hops, got %v", len(expected), backward.Hops)
+	}
+	for i, hop := range backward.Hops {
+		if hop.Address != expected[i] {
+			t.Errorf("backward hop %d: expected %v, got %v", i, expected[i], hop.Address)
+		}
+		if !LSS.Contains(set.NewStopKey(hop.Address, chain.SourceAddr)) {
+			t.Errorf("local stop set is missing %v", hop.AddressString())
+		}
+	}
+
+	//a second trace meets the global stop set at the first hop
+	if len(again.Hops) != 0 {
+		t.Errorf("expected the stop set to end the second trace, got %v", again.Hops)
+	}
+}
+
+func TestStopPrefixNetns(t *testing.T) {
+	if err := netns.Available(); err != nil {
+		t.Skip(err)
+	}
+	chain, err := netns.NewChain("dtp", 2)
+	defer chain.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	GSS = newStopSet()
+	LSS = set.NewShardedStopKeySet()
+	newNodes = set.NewSafeRoaringSet()
+
+	options := &TracerouteOptions{}
+	options.SetMaxHops(len(chain.Hops) + 2)
+	options.SetRetries(1)
+	options.SetNoLookup(true)
+	options.SetStopPrefix(24)
+	//the last router's far interface shares the destination's /24
+	neighbour := chain.DestAddr
+	neighbour[3] = 1
+	var first, second TracerouteResult
+	err = chain.Run(chain.Source, func() error {
+		source, err := socketAddr()
+		if err != nil {
+			return err
+		}
+		if first, err = probeForward(source, chain.DestAddr, options); err != nil {
+			return err
+		}
+		second, err = probeForward(source, neighbour, options)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Hops) != 2 {
+		t.Fatalf("expected 2 hops to the destination, got %v", first.Hops)
+	}
+	if len(second.Hops) != 0 {
+		t.Errorf("expected a /24 stop key to end the trace to %v, got %v", neighbour, second.Hops)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+//the stop set the leader sent, which may be a Set or a BloomFilter
+type leaderStops struct {
+	set.Container[set.StopKey]
+}
+
+//the global stop set. The leader's stop set for the range is only ever read,
+//and the pairs found since are kept beside it in a sharded set, so the trace
+//goroutines looking up and adding pairs rarely wait on each other.
+type stopSet struct {
+	fromLeader atomic.Pointer[leaderStops]
+	found      *set.ShardedSet[set.StopKey]
+}
+
+func newStopSet() *stopSet {
+	s := &stopSet{found: set.NewShardedStopKeySet()}
+	s.fromLeader.Store(&leaderStops{set.NewSet[set.StopKey]()})
+	return s
+}
+
+//starts over from the stop set the leader sent with a new range
+func (s *stopSet) ChangeSetTo(stops set.Container[set.StopKey]) {
+	if stops == nil {
+		stops = set.NewSet[set.StopKey]()
+	}
+	s.fromLeader.Store(&leaderStops{stops})
+	s.found.Wipe()
+}
+
+func (s *stopSet) Contains(item set.StopKey) bool {
+	return s.found.Contains(item) || s.fromLeader.Load().Contains(item)
+}
+
+func (s *stopSet) Add(item set.StopKey) {
+	if !s.fromLeader.Load().known(item) {
+		s.found.Add(item)
+	}
+}
+
+//whether the leader already has item. If its entries expire, ones getting
+//old are sent back anyway, so the leader knows they are still there.
+func (l *leaderStops) known(item set.StopKey) bool {
+	if expiring, ok := l.Container.(*set.ExpiringSet[set.StopKey]); ok {
+		return !expiring.Stale(item)
+	}
+	return l.Contains(item)
+}
+
+//the pairs found since the leader's stop set arrived, which is all the
+//leader needs to add to its own or confirm again.
+func (s *stopSet) Set() set.Container[set.StopKey] {
+	return s.found.Snapshot()
+}
+
+func (s *stopSet) ToCSV() string {
+	return s.found.ToCSV()
+}
+
+func (s *stopSet) Wipe() {
+	s.ChangeSetTo(nil)
+}
+
+//a version of the leader's stop set for a range
+type heldStopSet struct {
+	version int
+	stops   set.Container[set.StopKey]
+}
+
+//brings the held stop set of a range up to the leader's version, either
+//replacing it or adding the changes since the version held. The result is
+//only read until the next call, once the range has been probed.
+func catchUp(index int, stops set.Container[set.StopKey], full bool, version int) (set.Container[set.StopKey], error) {
+	held, ok := heldStops[index]
+	if full || !ok {
+		held = &heldStopSet{stops: stops}
+		heldStops[index] = held
+	} else if err := held.stops.UnionWith(stops); err != nil {
+		delete(heldStops, index) //ask for the whole set next time
+		return nil, err
+	}
+	held.version = version
+	return held.stops, nil
+}
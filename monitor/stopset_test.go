@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arieltraver/ari_traceroute/set"
+)
+
+func TestStopSetDeltas(t *testing.T) {
+	heldStops = map[int]*heldStopSet{}
+	a := set.NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 1})
+	b := set.NewStopKey([4]byte{10, 0, 0, 2}, [4]byte{192, 0, 2, 1})
+	c := set.NewStopKey([4]byte{10, 0, 0, 3}, [4]byte{192, 0, 2, 1})
+
+	//the leader's first stop set for range 3, then only the changes since
+	first := set.NewSet[set.StopKey]()
+	first.Add(a)
+	stops, err := catchUp(3, first, true, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	GSS = newStopSet()
+	GSS.ChangeSetTo(stops)
+	GSS.Add(a) //already known to the leader, so not sent back
+	GSS.Add(b)
+	if sent := GSS.Set(); sent.Size() != 1 || !sent.Contains(b) {
+		t.Errorf("expected to send only the new pair, got %v", sent.ToCSV())
+	}
+
+	changes := set.NewSet[set.StopKey]()
+	changes.Add(b)
+	changes.Add(c)
+	stops, err = catchUp(3, changes, false, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stops.Size() != 3 || heldStops[3].version != 4 {
+		t.Errorf("expected 3 pairs at version 4, got %d at %d", stops.Size(), heldStops[3].version)
+	}
+	GSS.ChangeSetTo(stops)
+	if !GSS.Contains(c) || GSS.Set().Size() != 0 {
+		t.Errorf("a new range should start with the leader's set and nothing found")
+	}
+}
+
+func TestStopSetConfirmsOldEntries(t *testing.T) {
+	now := time.Now()
+	fresh := set.NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 1})
+	old := set.NewStopKey([4]byte{10, 0, 0, 2}, [4]byte{192, 0, 2, 1})
+	leader := set.NewExpiringSet[set.StopKey](set.AgePolicy{MaxAge: time.Hour})
+	leader.Confirm(fresh, set.Stamp{At: now})
+	leader.Confirm(old, set.Stamp{At: now.Add(-40 * time.Minute)})
+
+	GSS = newStopSet()
+	GSS.ChangeSetTo(leader)
+	GSS.Add(fresh)
+	GSS.Add(old)
+	if sent := GSS.Set(); sent.Size() != 1 || !sent.Contains(old) {
+		t.Errorf("expected to confirm only the old entry, got %v", sent.ToCSV())
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+//TLS
+//With -tls, or any of -tlsca, -tlscert and -tlspin, the monitor talks to the
+//leader over TLS. It checks the leader's certificate against -tlsca, or the
+//system roots without it, and against the -tlspin keys if any are given. A
+//pin without a CA accepts a self-signed leader. -tlscert and -tlskey are the
+//monitor's own certificate, for a leader that wants mutual TLS.
+
+import (
+	"crypto/tls"
+	"net"
+	"net/rpc"
+
+	"github.com/arieltraver/ari_traceroute/tlsconf"
+)
+
+var leaderAddr string //set by -leader
+var useTLS bool //set by -tls, or implied by the other -tls flags
+var tlsFlags tlsconf.Config //set by the -tls flags
+var clientTLS *tls.Config //nil when talking to the leader in plaintext
+
+//builds the TLS configuration for the leader at address, if TLS is asked for
+func setupTLS(address string) error {
+	if !useTLS && !tlsFlags.Enabled() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	clientTLS, err = tlsFlags.Client(host)
+	return err
+}
+
+//connects to the leader's rpc server, over TLS if it was set up
+func dial(address string) (*rpc.Client, error) {
+	if clientTLS != nil {
+		return tlsconf.DialRPC(address, clientTLS)
+	}
+	return rpc.DialHTTP("tcp", address)
+}
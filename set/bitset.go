@@ -1,109 +1,72 @@
-//TAKEN FROM: https://github.com/krakendio/bloomfilter/tree/master/bitset
-//Needed to take this because their bitset only uses MD5
-//Doubletree paper uses Sha-1
-
-// Package bitset implements a bitset based on the bitset library.
-//
-// It aggregates a hashfunction and implements the Add, Check and Union methods.
+// A fixed size bit set stored as 64 bit words, so a union is a bitwise OR
+// of each pair of words. Used as the storage for the Bloom filter.
+// Based on https://medium.com/@val_deleplace/7-ways-to-implement-a-bit-set-in-go-91650229b386
 package set
-/**
+
 import (
-	"github.com/krakendio/bloomfilter/v2"
-	"github.com/tmthrgd/go-bitset"
-	"sync"
+	"errors"
+	"math/bits"
 )
 
-// BitSet type cotains library bitset and Hasher function
+// Fields are exported so the set can travel over gob.
 type BitSet struct {
-	Bs     bitset.Bitset
-	Hasher bloomfilter.Hash
+	Words  []uint64
+	Length uint64 //number of bits
 }
 
-// NewBitSet constructor for BitSet with an array of m bits
-func NewBitSet(m uint) *BitSet {
-	return &BitSet{Bs: bitset.New(m), Hasher: bloomfilter.SHA1} //this edit from Ari
+func NewBitSet(length uint64) *BitSet {
+	return &BitSet{Words: make([]uint64, (length+63)/64), Length: length}
 }
 
-// Add element to bitset
-func (Bs *BitSet) Add(elem []byte) {
-	Bs.Bs.Set(Bs.Hasher(elem)[0] % Bs.Bs.Len())
+func (b *BitSet) Set(i uint64) {
+	b.Words[i/64] |= 1 << (i % 64)
 }
 
-// Check element in bitset
-func (Bs *BitSet) Check(elem []byte) bool {
-	return Bs.Bs.IsSet(Bs.Hasher(elem)[0] % Bs.Bs.Len())
+func (b *BitSet) Clear(i uint64) {
+	b.Words[i/64] &^= 1 << (i % 64)
 }
 
-// Union of two bitsets
-func (Bs *BitSet) Union(that interface{}) (float64, error) {
-	other, ok := that.(*BitSet)
-	if !ok {
-		return Bs.getCount(), bloomfilter.ErrImpossibleToTreat
-	}
-
-	Bs.Bs.Union(Bs.Bs, other.Bs)
-	return Bs.getCount(), nil
+func (b *BitSet) IsSet(i uint64) bool {
+	return b.Words[i/64]&(1<<(i%64)) != 0
 }
 
-func (Bs *BitSet) getCount() float64 {
-	return float64(Bs.Bs.Count()) / float64(Bs.Bs.Len())
-}
-
-func (s *Set) ToBitset() *BitSet{
-	//size chosen using this: https://hur.st/bloomfilter/?n=4000&p=1.0E-7&m=&k=
-	b := NewBitSet(134191) //TODO: research the right size for this.
-	
-	mp := s.Mp //TODO make set iterable
-	for item := range(mp) {
-		b.Add([]byte(item))
+// number of bits set
+func (b *BitSet) Count() uint64 {
+	var count uint64
+	for _, w := range b.Words {
+		count += uint64(bits.OnesCount64(w))
 	}
-	return b
+	return count
 }
 
-
-//following section by Ari:
-type SafeBitSet struct {
-	bits *BitSet
-	lock sync.Mutex
+// whether there are as many words as the length needs. one that arrived
+// over gob may have any number.
+func (b *BitSet) wellFormed() bool {
+	return uint64(len(b.Words)) == (b.Length+63)/64
 }
 
-
-func NewSafeBitSet(m uint) *SafeBitSet {
-	bitst := &SafeBitSet{bits:NewBitSet(m), lock:sync.Mutex{}}
-	return bitst
-}
-
-// Union of safe bitset and regular bitset
-func (sBs *SafeBitSet) Union(that interface{}) (float64, error) {
-	sBs.lock.Lock()
-	defer sBs.lock.Unlock()
-	other, ok := that.(*BitSet)
-	if !ok {
-		return sBs.bits.getCount(), bloomfilter.ErrImpossibleToTreat
+// expands b into its union with another bit set of the same length
+func (b *BitSet) Union(other *BitSet) error {
+	if b.Length != other.Length {
+		return errors.New("need same size for union")
 	}
-	sBs.bits.Union(other)
-	return sBs.bits.getCount(), nil
-}
-
-func (sBs *SafeBitSet) Wipe(newsize uint) {
-	sBs.lock.Lock()
-	defer sBs.lock.Unlock()
-	sBs.bits = NewBitSet(newsize)
-}
-
-func (sBs *SafeBitSet) AddString(s string) {
-	sBs.lock.Lock()
-	defer sBs.lock.Unlock()
-	sBs.bits.Add([]byte(s))
+	if !b.wellFormed() || !other.wellFormed() {
+		return errors.New("bit set has the wrong number of words for its length")
+	}
+	for i, w := range other.Words {
+		b.Words[i] |= w
+	}
+	return nil
 }
 
-func (sBs *SafeBitSet) CheckString(s string) bool {
-	sBs.lock.Lock()
-	defer sBs.lock.Unlock()
-	return sBs.bits.Check([]byte(s))
+func (b *BitSet) Clone() *BitSet {
+	words := make([]uint64, len(b.Words))
+	copy(words, b.Words)
+	return &BitSet{Words: words, Length: b.Length}
 }
 
-func (sBs *SafeBitSet) ToCSV() string {
-	return "...implement toCSV"
+func (b *BitSet) Wipe() {
+	for i := range b.Words {
+		b.Words[i] = 0
+	}
 }
-**/
\ No newline at end of file
@@ -0,0 +1,211 @@
+package set
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Stop set defaults from the README: about 4,000 (hop, destination) pairs
+// per range, 2 hash functions and a 0.005 chance of a false positive, which
+// comes to 13.32 KB and stays under Doubletree's ~15 KB transmission limit.
+const STOPSET_CAPACITY = 4000
+const STOPSET_HASHES = 2
+const STOPSET_FP_RATE = 0.005
+
+// BloomFilter is a set that never gives a false negative, and
+// gives a false positive at a known rate. A false positive only makes a
+// monitor stop probing early, which Doubletree can afford.
+// Items cannot be removed or listed.
+type BloomFilter[T comparable] struct {
+	Bits   *BitSet
+	Hashes int
+	Added  int //items added, counting repeats
+}
+
+// NewBloomFilter sizes a filter for capacity items with the given false
+// positive rate. If hashes is 0 the number of hash functions that gives the
+// smallest filter is used.
+func NewBloomFilter[T comparable](capacity int, hashes int, fpRate float64) *BloomFilter[T] {
+	n := float64(capacity)
+	var m float64
+	if hashes <= 0 {
+		m = math.Ceil(-n * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+		hashes = int(math.Max(1, math.Round(m/n*math.Ln2)))
+	} else {
+		k := float64(hashes)
+		m = math.Ceil(-k * n / math.Log(1-math.Pow(fpRate, 1/k)))
+	}
+	return &BloomFilter[T]{Bits: NewBitSet(uint64(m)), Hashes: hashes}
+}
+
+// a filter of stop keys with the README's stop set sizing
+func NewStopSetBloom() *BloomFilter[StopKey] {
+	return NewBloomFilter[StopKey](STOPSET_CAPACITY, STOPSET_HASHES, STOPSET_FP_RATE)
+}
+
+func NewSafeBloomSet[T comparable](capacity int, hashes int, fpRate float64) *SafeSet[T] {
+	return NewSafeSet[T](NewBloomFilter[T](capacity, hashes, fpRate))
+}
+
+// SHA-1 as in the Doubletree paper, split into two hashes that are combined
+// to make as many indexes as needed (Kirsch and Mitzenmacher).
+func (b *BloomFilter[T]) indexes(item T) []uint64 {
+	var buf [32]byte
+	sum := sha1.Sum(itemBytes(item, buf[:0]))
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+	idx := make([]uint64, b.Hashes)
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) % b.Bits.Length
+	}
+	return idx
+}
+
+func (b *BloomFilter[T]) Add(item T) {
+	for _, i := range b.indexes(item) {
+		b.Bits.Set(i)
+	}
+	b.Added++
+}
+
+// items cannot be taken out of a Bloom filter, so this does nothing
+func (b *BloomFilter[T]) Remove(item T) {}
+
+func (b *BloomFilter[T]) Contains(item T) bool {
+	for _, i := range b.indexes(item) {
+		if !b.Bits.IsSet(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// estimates the number of distinct items from how many bits are set
+func (b *BloomFilter[T]) Size() int {
+	m := float64(b.Bits.Length)
+	x := float64(b.Bits.Count())
+	if x >= m {
+		return b.Added
+	}
+	return int(math.Round(-m / float64(b.Hashes) * math.Log(1-x/m)))
+}
+
+// the chance that Contains is wrong about an item never added, at the current fill
+func (b *BloomFilter[T]) FalsePositiveRate() float64 {
+	return math.Pow(float64(b.Bits.Count())/float64(b.Bits.Length), float64(b.Hashes))
+}
+
+// size in bytes of the bits, which is what goes over the wire
+func (b *BloomFilter[T]) Bytes() int {
+	return len(b.Bits.Words) * 8
+}
+
+func (b *BloomFilter[T]) Clone() *BloomFilter[T] {
+	return &BloomFilter[T]{Bits: b.Bits.Clone(), Hashes: b.Hashes, Added: b.Added}
+}
+
+func (b *BloomFilter[T]) Wipe() {
+	b.Bits.Wipe()
+	b.Added = 0
+}
+
+// Compatible reports whether two filters have the same shape, and so can be
+// unioned. A filter whose words do not match its length has no shape.
+func (b *BloomFilter[T]) Compatible(other *BloomFilter[T]) bool {
+	if b.Bits == nil || other.Bits == nil || !b.Bits.wellFormed() || !other.Bits.wellFormed() {
+		return false
+	}
+	return b.Bits.Length == other.Bits.Length && b.Hashes == other.Hashes
+}
+
+// expands the filter into its union with another set. Another Bloom filter
+// must have the same shape; a set that can be listed has each item added.
+func (b *BloomFilter[T]) UnionWith(s2 Container[T]) error {
+	switch other := s2.(type) {
+	case *BloomFilter[T]:
+		if !b.Compatible(other) {
+			return errors.New("set: union of bloom filters with different sizes")
+		}
+		b.Added += other.Added
+		return b.Bits.Union(other.Bits)
+	case Iterable[T]:
+		other.Each(func(key T) bool {
+			b.Add(key)
+			return true
+		})
+		return nil
+	}
+	return ErrNotIterable
+}
+
+//lists the indexes of the bits that are set, since the items themselves are
+//gone. Only the binary form can be read back.
+func (b *BloomFilter[T]) ToCSV() string {
+	fields := []string{}
+	for i := uint64(0); i < b.Bits.Length; i++ {
+		if b.Bits.IsSet(i) {
+			fields = append(fields, strconv.FormatUint(i, 10))
+		}
+	}
+	return strings.Join(fields, ",") + "\n"
+}
+
+// WriteBinary saves the filter: the header, the number of hashes, the items
+// added and the number of bits, all as varints, then the words in little
+// endian order.
+func (b *BloomFilter[T]) WriteBinary(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if err := writeHeader(bw, bloomKind); err != nil {
+		return err
+	}
+	buf := binary.AppendUvarint(nil, uint64(b.Hashes))
+	buf = binary.AppendUvarint(buf, uint64(b.Added))
+	buf = binary.AppendUvarint(buf, b.Bits.Length)
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	word := make([]byte, 8)
+	for _, wd := range b.Bits.Words {
+		binary.LittleEndian.PutUint64(word, wd)
+		if _, err := bw.Write(word); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadBloomFilter reads a filter saved by WriteBinary.
+func ReadBloomFilter[T comparable](r io.Reader) (*BloomFilter[T], error) {
+	br := bufferedReader(r)
+	if err := readHeader(br, bloomKind); err != nil {
+		return nil, err
+	}
+	fields := make([]uint64, 3)
+	for i := range fields {
+		var err error
+		if fields[i], err = binary.ReadUvarint(br); err != nil {
+			return nil, unexpected(err)
+		}
+	}
+	hashes, added, length := fields[0], fields[1], fields[2]
+	if hashes == 0 || hashes > 64 || length == 0 || added > math.MaxInt32 {
+		return nil, ErrBadEncoding
+	}
+	//the words are read before they are kept, so a bad length cannot
+	//make us allocate more than the input holds
+	bits := &BitSet{Length: length}
+	word := make([]byte, 8)
+	for i := uint64(0); i < (length+63)/64; i++ {
+		if _, err := io.ReadFull(br, word); err != nil {
+			return nil, unexpected(err)
+		}
+		bits.Words = append(bits.Words, binary.LittleEndian.Uint64(word))
+	}
+	return &BloomFilter[T]{Bits: bits, Hashes: int(hashes), Added: int(added)}, nil
+}
@@ -0,0 +1,87 @@
+package set
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strconv"
+	"testing"
+)
+
+func TestStopSetBloomSize(t *testing.T) {
+	b := NewStopSetBloom()
+	if b.Hashes != STOPSET_HASHES {
+		t.Errorf("expected %d hashes, got %d", STOPSET_HASHES, b.Hashes)
+	}
+	if b.Bytes() > 15*1024 {
+		t.Errorf("stop set is %d bytes, over the 15 KB limit", b.Bytes())
+	}
+}
+
+func TestBloomFalsePositives(t *testing.T) {
+	b := NewBloomFilter[string](STOPSET_CAPACITY, STOPSET_HASHES, STOPSET_FP_RATE)
+	for i := 0; i < STOPSET_CAPACITY; i++ {
+		b.Add("10.0.0." + strconv.Itoa(i) + "-192.0.2.1")
+	}
+	for i := 0; i < STOPSET_CAPACITY; i++ {
+		if !b.Contains("10.0.0." + strconv.Itoa(i) + "-192.0.2.1") {
+			t.Fatalf("false negative for item %d", i)
+		}
+	}
+	false_positives := 0
+	trials := 100000
+	for i := 0; i < trials; i++ {
+		if b.Contains("172.16.0." + strconv.Itoa(i) + "-198.51.100.1") {
+			false_positives++
+		}
+	}
+	if rate := float64(false_positives) / float64(trials); rate > 2*STOPSET_FP_RATE {
+		t.Errorf("false positive rate %v, expected about %v", rate, STOPSET_FP_RATE)
+	}
+	if size := b.Size(); size < STOPSET_CAPACITY*9/10 || size > STOPSET_CAPACITY*11/10 {
+		t.Errorf("estimated %d items, expected about %d", size, STOPSET_CAPACITY)
+	}
+}
+
+func TestBloomUnionOverGob(t *testing.T) {
+	a := NewStopSetBloom()
+	b := NewStopSetBloom()
+	ab := NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 1})
+	cd := NewStopKey([4]byte{10, 0, 0, 2}, [4]byte{192, 0, 2, 1})
+	a.Add(ab)
+	b.Add(cd)
+
+	//sent as a Container, the way stop sets travel between leader and monitor
+	var sent Container[StopKey] = b
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&sent); err != nil {
+		t.Fatal(err)
+	}
+	var received Container[StopKey]
+	if err := gob.NewDecoder(buf).Decode(&received); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.UnionWith(received); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Contains(ab) || !a.Contains(cd) {
+		t.Errorf("union is missing items")
+	}
+
+	if err := a.UnionWith(NewBloomFilter[StopKey](100, 2, 0.01)); err == nil {
+		t.Errorf("expected an error for a union of filters with different sizes")
+	}
+
+	//a filter whose words do not match its length, as a peer could send
+	long := NewStopSetBloom()
+	long.Bits.Words = append(long.Bits.Words, 1)
+	short := NewStopSetBloom()
+	short.Bits.Words = short.Bits.Words[:1]
+	for _, bad := range []*BloomFilter[StopKey]{long, short} {
+		if a.Compatible(bad) {
+			t.Errorf("a filter with %d words was compatible", len(bad.Bits.Words))
+		}
+		if err := a.UnionWith(bad); err == nil {
+			t.Errorf("a filter with %d words was unioned", len(bad.Bits.Words))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package set
+
+import (
+	"sync/atomic"
+)
+
+// Count-min defaults: 4 rows of 2048 counters, 64 KB. A count is then over
+// by at most 0.13% of everything added, except with a chance under 2%.
+const COUNTMIN_WIDTH = 2048
+const COUNTMIN_DEPTH = 4
+
+// CountMin estimates how many times each item was added, in constant memory
+// (Cormode and Muthukrishnan). Each row adds an item to one of its counters,
+// and the smallest of an item's counters is its count, which is never too
+// low. Two sketches of the same size merge into the sketch of both.
+// Add and Count are safe for concurrent use, but Merge and Wipe are not.
+type CountMin[T comparable] struct {
+	Counts []uint64 //Depth rows of Width counters
+	Width  int
+	Depth  int
+	Total  uint64 //everything added
+}
+
+func NewCountMin[T comparable](width, depth int) *CountMin[T] {
+	if width < 1 {
+		width = 1
+	}
+	if depth < 1 {
+		depth = 1
+	}
+	return &CountMin[T]{Counts: make([]uint64, width*depth), Width: width, Depth: depth}
+}
+
+//the counter of item in each row, made from two hashes
+func (c *CountMin[T]) counters(item T) []int {
+	h1 := sketchHash(item)
+	h2 := mix(h1^0x9e3779b97f4a7c15) | 1
+	idx := make([]int, c.Depth)
+	for row := range idx {
+		idx[row] = row*c.Width + int((h1+uint64(row)*h2)%uint64(c.Width))
+	}
+	return idx
+}
+
+// Add counts item n more times.
+func (c *CountMin[T]) Add(item T, n uint64) {
+	for _, i := range c.counters(item) {
+		atomic.AddUint64(&c.Counts[i], n)
+	}
+	atomic.AddUint64(&c.Total, n)
+}
+
+// Count estimates how many times item was added. It may be too high, never too low.
+func (c *CountMin[T]) Count(item T) uint64 {
+	var least uint64
+	for row, i := range c.counters(item) {
+		if n := atomic.LoadUint64(&c.Counts[i]); row == 0 || n < least {
+			least = n
+		}
+	}
+	return least
+}
+
+// Merge adds the counts of other to c.
+func (c *CountMin[T]) Merge(other *CountMin[T]) error {
+	if c.Width != other.Width || c.Depth != other.Depth || len(c.Counts) != len(other.Counts) {
+		return ErrSketchMismatch
+	}
+	for i, n := range other.Counts {
+		c.Counts[i] += n
+	}
+	c.Total += other.Total
+	return nil
+}
+
+func (c *CountMin[T]) Wipe() {
+	c.Counts = make([]uint64, len(c.Counts))
+	c.Total = 0
+}
@@ -0,0 +1,532 @@
+// Sets are saved in two forms.
+//
+// CSV is for people and for diffs. Items come out sorted, so the same set
+// always gives the same text: ToCSV puts them on one line separated by
+// commas, and WriteCSV streams one item per line. ReadCSV and FromCSV accept
+// either, skipping empty fields, so the trailing comma older versions wrote
+// is fine too.
+//
+// The binary form is for saving sets between campaigns. It starts with a
+// three letter kind and a version digit, like "RRS1" for version 1 of a
+// RoaringSet, so a reader can tell what it was handed and refuse versions it
+// does not know. Readers buffer their input; pass a *bufio.Reader to read
+// several sets back to back from one stream.
+
+package set
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const ENCODING_VERSION = '1'
+
+const (
+	stringSetKind   = "STS"
+	intSetKind      = "INS"
+	addressSetKind  = "ADS"
+	stopKeySetKind  = "SKS"
+	stopKey6SetKind = "S6S"
+	bloomKind       = "BLM"
+	ipSetKind       = "IPS"
+	roaringKind     = "RRS"
+	expiringKind    = "EXS" //followed by the kind of a Set of its items
+)
+
+const maxItemLen = 1 << 16 //longest string item a reader accepts
+
+var ErrBadEncoding = errors.New("set: bad encoding")
+
+// VersionError is returned for an encoding of a version this package cannot read.
+type VersionError struct {
+	Kind    string
+	Version byte
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("set: version %c of the %v encoding is not supported", e.Version, e.Kind)
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func bufferedReader(r io.Reader) *bufio.Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
+
+// an io.EOF part way through a set means it was cut short
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func writeHeader(w io.Writer, kind string) error {
+	_, err := w.Write([]byte{kind[0], kind[1], kind[2], ENCODING_VERSION})
+	return err
+}
+
+// reads a header and checks that it is for kind, in a version we know
+func readHeader(r io.Reader, kind string) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if string(header[:3]) != kind {
+		return fmt.Errorf("set: expected a %v encoding, got %q", kind, header[:3])
+	}
+	if header[3] != ENCODING_VERSION {
+		return &VersionError{Kind: kind, Version: header[3]}
+	}
+	return nil
+}
+
+func peekKind(br *bufio.Reader) (string, error) {
+	header, err := br.Peek(4)
+	if err != nil {
+		return "", err
+	}
+	return string(header[:3]), nil
+}
+
+//---------items of the map backed Set-------------------------------------------------//
+
+// the kind of binary encoding for a Set of T. Sets of strings, ints,
+// addresses and stop keys can be saved.
+func setKind[T comparable]() (string, error) {
+	var item T
+	switch any(item).(type) {
+	case string:
+		return stringSetKind, nil
+	case int:
+		return intSetKind, nil
+	case [4]byte:
+		return addressSetKind, nil
+	case StopKey:
+		return stopKeySetKind, nil
+	case StopKey6:
+		return stopKey6SetKind, nil
+	}
+	return "", fmt.Errorf("set: cannot encode items of type %T", item)
+}
+
+func formatItem[T comparable](item T) string {
+	switch it := any(item).(type) {
+	case string:
+		return it
+	case [4]byte:
+		return addressString(it)
+	}
+	return fmt.Sprint(item)
+}
+
+func parseItem[T comparable](field string) (T, error) {
+	var item T
+	var err error
+	switch p := any(&item).(type) {
+	case *string:
+		*p = field
+	case *int:
+		*p, err = strconv.Atoi(field)
+	case *[4]byte:
+		var ok bool
+		if *p, ok = ParseAddress(field); !ok {
+			err = fmt.Errorf("set: %q is not an IPv4 address", field)
+		}
+	case *StopKey:
+		*p, err = ParseStopKey(field)
+	case *StopKey6:
+		*p, err = ParseStopKey6(field)
+	default:
+		err = fmt.Errorf("set: cannot parse items of type %T", item)
+	}
+	return item, err
+}
+
+// sorts strings lexically, and everything else numerically
+func sortItems[T comparable](items []T) {
+	switch it := any(items).(type) {
+	case []string:
+		sort.Strings(it)
+	case []int:
+		sort.Ints(it)
+	case [][4]byte:
+		sort.Slice(it, func(i, j int) bool { return bytes.Compare(it[i][:], it[j][:]) < 0 })
+	case []StopKey:
+		sort.Slice(it, func(i, j int) bool { return it[i] < it[j] })
+	case []StopKey6:
+		sort.Slice(it, func(i, j int) bool {
+			if c := bytes.Compare(it[i].Interface[:], it[j].Interface[:]); c != 0 {
+				return c < 0
+			}
+			return bytes.Compare(it[i].Destination[:], it[j].Destination[:]) < 0
+		})
+	default:
+		sort.Slice(items, func(i, j int) bool { return fmt.Sprint(items[i]) < fmt.Sprint(items[j]) })
+	}
+}
+
+// Sorted returns the items in the order they are saved in.
+func (s *Set[T]) Sorted() []T {
+	items := s.Items()
+	sortItems(items)
+	return items
+}
+
+//---------CSV-------------------------------------------------//
+
+// calls fn on every non empty field of every line of r
+func scanCSV(r io.Reader, fn func(string) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, field := range record {
+			if field == "" {
+				continue
+			}
+			if err := fn(field); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// WriteCSV streams the items in sorted order, one per line.
+func (s *Set[T]) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	record := make([]string, 1)
+	for _, item := range s.Sorted() {
+		record[0] = formatItem(item)
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadCSV adds every item in r, which may be one line or one item per line.
+func (s *Set[T]) ReadCSV(r io.Reader) error {
+	return scanCSV(r, func(field string) error {
+		item, err := parseItem[T](field)
+		if err != nil {
+			return err
+		}
+		s.Add(item)
+		return nil
+	})
+}
+
+// FromCSV parses the output of ToCSV or WriteCSV back into a Set.
+func FromCSV[T comparable](text string) (*Set[T], error) {
+	s := NewSet[T]()
+	if err := s.ReadCSV(strings.NewReader(text)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//turns a Set into a CSV, in sorted order
+func (s *Set[T]) ToCSV() string {
+	items := s.Sorted()
+	record := make([]string, len(items))
+	for i, item := range items {
+		record[i] = formatItem(item)
+	}
+	str := &strings.Builder{}
+	cw := csv.NewWriter(str)
+	cw.Write(record)
+	cw.Flush()
+	if len(record) == 0 {
+		return "\n"
+	}
+	return str.String()
+}
+
+func addressesCSV(each func(func([4]byte) bool)) string {
+	str := &strings.Builder{}
+	first := true
+	each(func(addr [4]byte) bool {
+		if !first {
+			str.WriteRune(',')
+		}
+		str.WriteString(addressString(addr))
+		first = false
+		return true
+	})
+	str.WriteRune('\n')
+	return str.String()
+}
+
+func writeAddressCSV(w io.Writer, each func(func([4]byte) bool)) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	each(func(addr [4]byte) bool {
+		_, err = bw.WriteString(addressString(addr) + "\n")
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func readAddressCSV(r io.Reader, add func([4]byte) error) error {
+	return scanCSV(r, func(field string) error {
+		addr, ok := ParseAddress(field)
+		if !ok {
+			return fmt.Errorf("set: %q is not an IPv4 address", field)
+		}
+		return add(addr)
+	})
+}
+
+//---------binary-------------------------------------------------//
+
+// writes items of a Set in sorted order. Strings are length prefixed, IPv6
+// stop keys are raw bytes, and the rest are varint gaps from the item before.
+type itemWriter[T comparable] struct {
+	bw       *bufio.Writer
+	scratch  []byte
+	prevInt  int64
+	prevAddr uint32
+	prevKey  uint64
+}
+
+func newItemWriter[T comparable](bw *bufio.Writer) *itemWriter[T] {
+	return &itemWriter[T]{bw: bw, scratch: make([]byte, binary.MaxVarintLen64)}
+}
+
+func (e *itemWriter[T]) uvarint(n uint64) error {
+	_, err := e.bw.Write(e.scratch[:binary.PutUvarint(e.scratch, n)])
+	return err
+}
+
+func (e *itemWriter[T]) varint(n int64) error {
+	_, err := e.bw.Write(e.scratch[:binary.PutVarint(e.scratch, n)])
+	return err
+}
+
+func (e *itemWriter[T]) write(item T) error {
+	var err error
+	switch it := any(item).(type) {
+	case string:
+		if err = e.uvarint(uint64(len(it))); err == nil {
+			_, err = e.bw.WriteString(it)
+		}
+	case int:
+		err = e.varint(int64(it) - e.prevInt)
+		e.prevInt = int64(it)
+	case [4]byte:
+		n := bytesToUint32(it)
+		err = e.uvarint(uint64(n - e.prevAddr))
+		e.prevAddr = n
+	case StopKey:
+		err = e.uvarint(uint64(it) - e.prevKey)
+		e.prevKey = uint64(it)
+	case StopKey6:
+		if _, err = e.bw.Write(it.Interface[:]); err == nil {
+			_, err = e.bw.Write(it.Destination[:])
+		}
+	}
+	return err
+}
+
+// reads back what an itemWriter wrote
+type itemReader[T comparable] struct {
+	br       *bufio.Reader
+	first    bool
+	prevInt  int64
+	prevAddr uint32
+	prevKey  uint64
+}
+
+func newItemReader[T comparable](br *bufio.Reader) *itemReader[T] {
+	return &itemReader[T]{br: br, first: true}
+}
+
+func (d *itemReader[T]) read() (T, error) {
+	var item T
+	var err error
+	//items are distinct and sorted, so only the first may be a gap of 0
+	first := d.first
+	d.first = false
+	switch p := any(&item).(type) {
+	case *string:
+		var n uint64
+		if n, err = binary.ReadUvarint(d.br); err != nil {
+			break
+		}
+		if n > maxItemLen {
+			return item, ErrBadEncoding
+		}
+		buf := make([]byte, n)
+		if _, err = io.ReadFull(d.br, buf); err == nil {
+			*p = string(buf)
+		}
+	case *int:
+		var gap int64
+		if gap, err = binary.ReadVarint(d.br); err == nil {
+			d.prevInt += gap
+			*p = int(d.prevInt)
+		}
+	case *[4]byte:
+		var gap uint64
+		if gap, err = binary.ReadUvarint(d.br); err == nil {
+			if gap > math.MaxUint32 || (!first && gap == 0) {
+				return item, ErrBadEncoding
+			}
+			d.prevAddr += uint32(gap)
+			*p = uint32ToBytes(d.prevAddr)
+		}
+	case *StopKey:
+		var gap uint64
+		if gap, err = binary.ReadUvarint(d.br); err == nil {
+			if !first && gap == 0 {
+				return item, ErrBadEncoding
+			}
+			d.prevKey += gap
+			*p = StopKey(d.prevKey)
+		}
+	case *StopKey6:
+		if _, err = io.ReadFull(d.br, p.Interface[:]); err == nil {
+			_, err = io.ReadFull(d.br, p.Destination[:])
+		}
+	}
+	return item, unexpected(err)
+}
+
+// WriteBinary saves a Set of strings, ints, addresses or stop keys: the
+// header, the item count, then the items in sorted order.
+func (s *Set[T]) WriteBinary(w io.Writer) error {
+	kind, err := setKind[T]()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := writeHeader(bw, kind); err != nil {
+		return err
+	}
+	items := s.Sorted()
+	e := newItemWriter[T](bw)
+	if err := e.uvarint(uint64(len(items))); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := e.write(item); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadSet reads a Set saved by WriteBinary. T must match what was saved.
+func ReadSet[T comparable](r io.Reader) (*Set[T], error) {
+	kind, err := setKind[T]()
+	if err != nil {
+		return nil, err
+	}
+	br := bufferedReader(r)
+	if err := readHeader(br, kind); err != nil {
+		return nil, err
+	}
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	s := NewSet[T]()
+	d := newItemReader[T](br)
+	for i := uint64(0); i < count; i++ {
+		item, err := d.read()
+		if err != nil {
+			return nil, err
+		}
+		s.Add(item)
+	}
+	return s, nil
+}
+
+// ReadStopSet reads a stop set saved by WriteBinary, whether it was a Set,
+// a BloomFilter or an ExpiringSet of stop keys.
+func ReadStopSet(r io.Reader) (Container[StopKey], error) {
+	br := bufferedReader(r)
+	kind, err := peekKind(br)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case stopKeySetKind:
+		s, err := ReadSet[StopKey](br)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case bloomKind:
+		b, err := ReadBloomFilter[StopKey](br)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	case expiringKind:
+		s, err := ReadExpiringSet[StopKey](br)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("set: %q is not a stop set encoding", kind)
+}
+
+// ReadAddressSet reads any set of addresses saved by WriteBinary: a Set of
+// addresses, an IPSet or a RoaringSet.
+func ReadAddressSet(r io.Reader) (Iterable[[4]byte], error) {
+	br := bufferedReader(r)
+	kind, err := peekKind(br)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case addressSetKind:
+		s, err := ReadSet[[4]byte](br)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case ipSetKind:
+		s, err := ReadIPSet(br)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case roaringKind:
+		s, err := ReadRoaringSet(br)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("set: %q is not an address set encoding", kind)
+}
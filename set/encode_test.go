@@ -0,0 +1,156 @@
+package set
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCSVSortedAndParsed(t *testing.T) {
+	s := NewStringSet()
+	for _, item := range []string{"10.0.0.2-1.1.1.1", "10.0.0.1-1.1.1.1", "has,comma"} {
+		s.Add(item)
+	}
+	csv := s.ToCSV()
+	if csv != "10.0.0.1-1.1.1.1,10.0.0.2-1.1.1.1,\"has,comma\"\n" {
+		t.Errorf("unexpected csv %q", csv)
+	}
+	back, err := FromCSV[string](csv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !back.Equal(s) {
+		t.Errorf("round trip gave %v", back.ToCSV())
+	}
+
+	//the trailing comma older versions wrote, and one item per line
+	ints, err := FromCSV[int]("3,1,2,\n")
+	if err != nil || ints.Size() != 3 || !ints.Contains(2) {
+		t.Errorf("could not read the old format: %v %v", ints, err)
+	}
+	buf := &bytes.Buffer{}
+	if err := ints.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "1\n2\n3\n" {
+		t.Errorf("unexpected streamed csv %q", buf.String())
+	}
+	if _, err := FromCSV[int]("1,x"); err == nil {
+		t.Errorf("expected an error for a bad int")
+	}
+
+	r := NewRoaringSet()
+	if err := r.ReadCSV(strings.NewReader("10.0.0.1\n1.2.3.4\n")); err != nil {
+		t.Fatal(err)
+	}
+	if r.ToCSV() != "1.2.3.4,10.0.0.1\n" {
+		t.Errorf("unexpected address csv %q", r.ToCSV())
+	}
+	ips, _ := NewIPPrefixSet([4]byte{10, 0, 0, 0}, 24)
+	if err := ips.ReadCSV(strings.NewReader(r.ToCSV())); err == nil {
+		t.Errorf("expected an error for an address outside the prefix")
+	}
+}
+
+func TestBinaryRoundTrips(t *testing.T) {
+	strs := NewStringSet()
+	strs.Add("a-b")
+	strs.Add("")
+	keys := NewSet[StopKey]()
+	keys.Add(NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 1}))
+	keys.Add(NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 9}))
+	v6 := NewSet[StopKey6]()
+	v6.Add(NewStopKey6([16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}, [16]byte{0x20, 0x01, 0x0d, 0xb8, 1, 15: 2}))
+	ints := NewIntSet()
+	for _, n := range []int{-5, 0, 7, 1 << 40} {
+		ints.Add(n)
+	}
+	addrs := NewSet[[4]byte]()
+	addrs.Add([4]byte{10, 0, 0, 1})
+	addrs.Add([4]byte{255, 255, 255, 255})
+	bloom := NewStopSetBloom()
+	bloom.Add(NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 1}))
+	roaring := NewRoaringSet()
+	for i := 0; i < 5000; i++ { //enough for one bitmap container
+		roaring.Add([4]byte{172, 16, byte(i >> 8), byte(i)})
+	}
+	roaring.Add([4]byte{8, 8, 8, 8})
+	ipset, _ := NewIPPrefixSet([4]byte{192, 168, 0, 0}, 16)
+	ipset.Add([4]byte{192, 168, 1, 1})
+
+	//every set back to back in one stream
+	buf := &bytes.Buffer{}
+	writers := []func() error{
+		func() error { return strs.WriteBinary(buf) },
+		func() error { return keys.WriteBinary(buf) },
+		func() error { return v6.WriteBinary(buf) },
+		func() error { return ints.WriteBinary(buf) },
+		func() error { return addrs.WriteBinary(buf) },
+		func() error { return bloom.WriteBinary(buf) },
+		func() error { return roaring.WriteBinary(buf) },
+		func() error { return ipset.WriteBinary(buf) },
+	}
+	for _, write := range writers {
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	br := bufio.NewReader(buf)
+	gotStrs, err := ReadSet[string](br)
+	if err != nil || !gotStrs.Equal(strs) {
+		t.Fatalf("string set round trip failed: %v", err)
+	}
+	stops, err := ReadStopSet(br)
+	if err != nil || !stops.(*Set[StopKey]).Equal(keys) {
+		t.Fatalf("stop key set round trip failed: %v", err)
+	}
+	gotV6, err := ReadSet[StopKey6](br)
+	if err != nil || !gotV6.Equal(v6) {
+		t.Fatalf("ipv6 stop key set round trip failed: %v", err)
+	}
+	gotInts, err := ReadSet[int](br)
+	if err != nil || !gotInts.Equal(ints) {
+		t.Fatalf("int set round trip failed: %v", err)
+	}
+	gotAddrs, err := ReadAddressSet(br)
+	if err != nil || !gotAddrs.(*Set[[4]byte]).Equal(addrs) {
+		t.Fatalf("address set round trip failed: %v", err)
+	}
+	gotBloom, err := ReadStopSet(br)
+	if err != nil || !gotBloom.Contains(NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 1})) || !gotBloom.(*BloomFilter[StopKey]).Compatible(bloom) {
+		t.Fatalf("bloom filter round trip failed: %v", err)
+	}
+	gotRoaring, err := ReadAddressSet(br)
+	if err != nil || gotRoaring.Size() != 5001 || !gotRoaring.Contains([4]byte{8, 8, 8, 8}) {
+		t.Fatalf("roaring set round trip failed: %v", err)
+	}
+	gotIPs, err := ReadAddressSet(br)
+	if err != nil || gotIPs.Size() != 1 || !gotIPs.Contains([4]byte{192, 168, 1, 1}) {
+		t.Fatalf("ip set round trip failed: %v", err)
+	}
+	if _, err := br.ReadByte(); err == nil {
+		t.Errorf("expected the stream to be used up")
+	}
+}
+
+func TestBinaryRejectsBadInput(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewIntSet().WriteBinary(buf)
+	data := buf.Bytes()
+	data[3] = '2'
+	var version *VersionError
+	if _, err := ReadSet[int](bytes.NewReader(data)); !errors.As(err, &version) {
+		t.Errorf("expected a VersionError, got %v", err)
+	}
+	if _, err := ReadSet[string](strings.NewReader("INS1\x00")); err == nil {
+		t.Errorf("expected an error for the wrong kind")
+	}
+	if _, err := ReadSet[string](strings.NewReader("STS1\x02\x01a")); err == nil {
+		t.Errorf("expected an error for a truncated set")
+	}
+	if _, err := ReadBloomFilter[StopKey](strings.NewReader("BLM1\x02\x00\xff\xff\xff\xff\x0f")); err == nil {
+		t.Errorf("expected an error for a filter missing its words")
+	}
+}
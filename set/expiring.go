@@ -0,0 +1,298 @@
+//EXPIRING SETS
+//Routes change, so a stop set entry is only worth trusting for a while after
+//it was last seen. An ExpiringSet stamps every entry with the time and the
+//campaign round it was last confirmed in. Entries older than the set's
+//AgePolicy are treated as gone by every read, and Compact deletes them.
+
+package set
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Stamp is when an entry was last confirmed.
+type Stamp struct {
+	At    time.Time
+	Round int
+}
+
+// AgePolicy says how old an entry may get. A zero field means entries never
+// expire by that measure, so the zero AgePolicy keeps everything.
+type AgePolicy struct {
+	MaxAge    time.Duration //entries confirmed longer ago than this expire
+	MaxRounds int           //entries confirmed more than this many rounds ago expire
+}
+
+func (p AgePolicy) expired(stamp Stamp, now time.Time, round int) bool {
+	if p.MaxAge > 0 && now.Sub(stamp.At) > p.MaxAge {
+		return true
+	}
+	return p.MaxRounds > 0 && round-stamp.Round > p.MaxRounds
+}
+
+// ExpiringSet is a set whose entries expire. Reads never change it, so it
+// can be read concurrently, but writes must be guarded, e.g. with a SafeSet.
+// Fields are exported so the set can travel over gob.
+type ExpiringSet[T comparable] struct {
+	Stamps map[T]Stamp
+	Policy AgePolicy
+	Round  int //the current campaign round
+	clock  func() time.Time
+}
+
+func NewExpiringSet[T comparable](policy AgePolicy) *ExpiringSet[T] {
+	return &ExpiringSet[T]{Stamps: make(map[T]Stamp), Policy: policy}
+}
+
+func NewSafeExpiringSet[T comparable](policy AgePolicy) *SafeSet[T] {
+	return NewSafeSet[T](NewExpiringSet[T](policy))
+}
+
+func (s *ExpiringSet[T]) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
+}
+
+// SetClock replaces time.Now, for tests.
+func (s *ExpiringSet[T]) SetClock(clock func() time.Time) {
+	s.clock = clock
+}
+
+func (s *ExpiringSet[T]) SetPolicy(policy AgePolicy) {
+	s.Policy = policy
+}
+
+// SetRound moves the set to a campaign round. Entries added from now on are
+// stamped with it.
+func (s *ExpiringSet[T]) SetRound(round int) {
+	s.Round = round
+}
+
+func (s *ExpiringSet[T]) live(stamp Stamp, now time.Time) bool {
+	return !s.Policy.expired(stamp, now, s.Round)
+}
+
+// Add adds item, or confirms it again if it is already there.
+func (s *ExpiringSet[T]) Add(item T) {
+	s.Confirm(item, Stamp{At: s.now(), Round: s.Round})
+}
+
+// Confirm stamps item as seen at stamp, unless it was seen more recently.
+func (s *ExpiringSet[T]) Confirm(item T, stamp Stamp) {
+	if s.Stamps == nil { //gob leaves the map out of an empty set
+		s.Stamps = make(map[T]Stamp)
+	}
+	if old, ok := s.Stamps[item]; ok && old.At.After(stamp.At) {
+		return
+	}
+	s.Stamps[item] = stamp
+}
+
+func (s *ExpiringSet[T]) Remove(item T) {
+	delete(s.Stamps, item)
+}
+
+func (s *ExpiringSet[T]) Contains(item T) bool {
+	stamp, ok := s.Stamps[item]
+	return ok && s.live(stamp, s.now())
+}
+
+// StampOf returns when item was last confirmed, if it is still live.
+func (s *ExpiringSet[T]) StampOf(item T) (Stamp, bool) {
+	stamp, ok := s.Stamps[item]
+	if !ok || !s.live(stamp, s.now()) {
+		return Stamp{}, false
+	}
+	return stamp, true
+}
+
+// Stale reports whether item is worth confirming again: it is missing or
+// expired, was confirmed more than half its allowed age ago, or was
+// confirmed in an earlier round of a set that ages by rounds.
+func (s *ExpiringSet[T]) Stale(item T) bool {
+	stamp, ok := s.StampOf(item)
+	if !ok {
+		return true
+	}
+	if s.Policy.MaxAge > 0 && s.now().Sub(stamp.At) > s.Policy.MaxAge/2 {
+		return true
+	}
+	return s.Policy.MaxRounds > 0 && stamp.Round < s.Round
+}
+
+// Size counts the live entries.
+func (s *ExpiringSet[T]) Size() int {
+	now := s.now()
+	n := 0
+	for _, stamp := range s.Stamps {
+		if s.live(stamp, now) {
+			n++
+		}
+	}
+	return n
+}
+
+// Compact deletes expired entries and returns how many there were.
+func (s *ExpiringSet[T]) Compact() int {
+	now := s.now()
+	n := 0
+	for item, stamp := range s.Stamps {
+		if !s.live(stamp, now) {
+			delete(s.Stamps, item)
+			n++
+		}
+	}
+	return n
+}
+
+// Clone copies the entries, policy, round and clock of the set.
+func (s *ExpiringSet[T]) Clone() *ExpiringSet[T] {
+	s2 := &ExpiringSet[T]{Stamps: make(map[T]Stamp, len(s.Stamps)), Policy: s.Policy, Round: s.Round, clock: s.clock}
+	for item, stamp := range s.Stamps {
+		s2.Stamps[item] = stamp
+	}
+	return s2
+}
+
+func (s *ExpiringSet[T]) Wipe() {
+	s.Stamps = make(map[T]Stamp)
+}
+
+// Each calls fn on every live entry until fn returns false.
+func (s *ExpiringSet[T]) Each(fn func(T) bool) {
+	now := s.now()
+	for item, stamp := range s.Stamps {
+		if s.live(stamp, now) && !fn(item) {
+			return
+		}
+	}
+}
+
+// expands the set into its union with another set. The stamps of another
+// ExpiringSet are kept; the items of any other listable set count as
+// confirmed now.
+func (s *ExpiringSet[T]) UnionWith(s2 Container[T]) error {
+	switch other := s2.(type) {
+	case *ExpiringSet[T]:
+		now := other.now()
+		for item, stamp := range other.Stamps {
+			if other.live(stamp, now) {
+				s.Confirm(item, stamp)
+			}
+		}
+		return nil
+	case Iterable[T]:
+		other.Each(func(item T) bool {
+			s.Add(item)
+			return true
+		})
+		return nil
+	}
+	return ErrNotIterable
+}
+
+// the live entries as a plain Set
+func (s *ExpiringSet[T]) Live() *Set[T] {
+	live := NewSet[T]()
+	s.Each(func(item T) bool {
+		live.Add(item)
+		return true
+	})
+	return live
+}
+
+//turns the live entries into a CSV, in sorted order
+func (s *ExpiringSet[T]) ToCSV() string {
+	return s.Live().ToCSV()
+}
+
+// WriteBinary saves the kind of its items, the policy and round, then the
+// live entries in sorted order, each followed by its stamp. Items are
+// encoded as in a Set.
+func (s *ExpiringSet[T]) WriteBinary(w io.Writer) error {
+	kind, err := setKind[T]()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := writeHeader(bw, expiringKind); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString(kind); err != nil {
+		return err
+	}
+	e := newItemWriter[T](bw)
+	items := s.Live().Sorted()
+	for _, n := range []int64{int64(s.Policy.MaxAge), int64(s.Policy.MaxRounds), int64(s.Round), int64(len(items))} {
+		if err := e.varint(n); err != nil {
+			return err
+		}
+	}
+	for _, item := range items {
+		stamp := s.Stamps[item]
+		if err := e.write(item); err != nil {
+			return err
+		}
+		if err := e.varint(stamp.At.UnixNano()); err != nil {
+			return err
+		}
+		if err := e.varint(int64(stamp.Round)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadExpiringSet reads a set saved by WriteBinary. T must match what was saved.
+func ReadExpiringSet[T comparable](r io.Reader) (*ExpiringSet[T], error) {
+	kind, err := setKind[T]()
+	if err != nil {
+		return nil, err
+	}
+	br := bufferedReader(r)
+	if err := readHeader(br, expiringKind); err != nil {
+		return nil, err
+	}
+	itemKind := make([]byte, len(kind))
+	if _, err := io.ReadFull(br, itemKind); err != nil {
+		return nil, unexpected(err)
+	}
+	if string(itemKind) != kind {
+		return nil, fmt.Errorf("set: expected an expiring set of %v items, found %q", kind, itemKind)
+	}
+	fields := make([]int64, 4)
+	for i := range fields {
+		var err error
+		if fields[i], err = binary.ReadVarint(br); err != nil {
+			return nil, unexpected(err)
+		}
+	}
+	if fields[0] < 0 || fields[1] < 0 || fields[3] < 0 {
+		return nil, ErrBadEncoding
+	}
+	s := NewExpiringSet[T](AgePolicy{MaxAge: time.Duration(fields[0]), MaxRounds: int(fields[1])})
+	s.Round = int(fields[2])
+	d := newItemReader[T](br)
+	for i := int64(0); i < fields[3]; i++ {
+		item, err := d.read()
+		if err != nil {
+			return nil, err
+		}
+		at, err := binary.ReadVarint(br)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		round, err := binary.ReadVarint(br)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		s.Stamps[item] = Stamp{At: time.Unix(0, at), Round: int(round)}
+	}
+	return s, nil
+}
@@ -0,0 +1,122 @@
+package set
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+)
+
+func TestExpiringByAgeAndRound(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewExpiringSet[StopKey](AgePolicy{MaxAge: time.Hour, MaxRounds: 2})
+	s.SetClock(func() time.Time { return now })
+	old := NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 1})
+	confirmed := NewStopKey([4]byte{10, 0, 0, 2}, [4]byte{192, 0, 2, 1})
+	s.Add(old)
+	s.Add(confirmed)
+
+	now = now.Add(50 * time.Minute)
+	s.Add(confirmed) //seen again
+	now = now.Add(20 * time.Minute)
+	if s.Contains(old) || !s.Contains(confirmed) || s.Size() != 1 {
+		t.Errorf("expected only the confirmed entry to be live, got %v", s.ToCSV())
+	}
+	if len(s.Stamps) != 2 {
+		t.Errorf("reads should not drop entries")
+	}
+	if dropped := s.Compact(); dropped != 1 || len(s.Stamps) != 1 {
+		t.Errorf("expected compaction to drop 1 entry, dropped %d", dropped)
+	}
+
+	s.SetRound(3)
+	if s.Contains(confirmed) {
+		t.Errorf("an entry from round 0 should be too old in round 3")
+	}
+	s.SetPolicy(AgePolicy{})
+	if !s.Contains(confirmed) {
+		t.Errorf("the zero policy should keep everything")
+	}
+}
+
+func TestExpiringKeepsNewerStamps(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	a := NewExpiringSet[string](AgePolicy{MaxAge: time.Hour})
+	b := NewExpiringSet[string](AgePolicy{MaxAge: time.Hour})
+	a.SetClock(clock)
+	b.SetClock(clock)
+	a.Add("x")
+	now = now.Add(30 * time.Minute)
+	b.Add("x")
+	if err := b.UnionWith(a); err != nil {
+		t.Fatal(err)
+	}
+	if stamp, _ := b.StampOf("x"); !stamp.At.Equal(now) {
+		t.Errorf("union replaced a newer stamp with %v", stamp.At)
+	}
+	if err := a.UnionWith(b); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(45 * time.Minute)
+	if !a.Contains("x") {
+		t.Errorf("union did not carry over the newer stamp")
+	}
+}
+
+func TestExpiringEncodings(t *testing.T) {
+	s := NewExpiringSet[StopKey](AgePolicy{MaxAge: time.Minute, MaxRounds: 4})
+	s.SetRound(7)
+	for i := 0; i < 100; i++ {
+		s.Add(NewStopKey(uint32ToBytes(uint32(i)), [4]byte{192, 0, 2, 1}))
+	}
+	buf := &bytes.Buffer{}
+	if err := s.WriteBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	back, err := ReadStopSet(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := back.(*ExpiringSet[StopKey])
+	if read.Policy != s.Policy || read.Round != 7 || !read.Live().Equal(s.Live()) {
+		t.Errorf("binary round trip lost the policy, round or entries")
+	}
+	buf.Reset()
+	s.WriteBinary(buf)
+	if _, err := ReadExpiringSet[int](buf); err == nil {
+		t.Errorf("expected an error reading stop keys as ints")
+	}
+
+	var sent Container[StopKey] = s
+	buf.Reset()
+	if err := gob.NewEncoder(buf).Encode(&sent); err != nil {
+		t.Fatal(err)
+	}
+	var received Container[StopKey]
+	if err := gob.NewDecoder(buf).Decode(&received); err != nil {
+		t.Fatal(err)
+	}
+	if received.Size() != 100 {
+		t.Errorf("expected 100 live entries over gob, got %d", received.Size())
+	}
+}
+
+func TestVersionedCompaction(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	all := NewExpiringSet[StopKey](AgePolicy{MaxAge: time.Hour})
+	all.SetClock(func() time.Time { return now })
+	v := NewVersionedSet[StopKey](all, DEFAULT_HISTORY)
+	v.Apply(keysFrom(10, 0)) //version 2
+	now = now.Add(40 * time.Minute)
+	if version, _ := v.Apply(keysFrom(5, 0)); version != 2 {
+		t.Errorf("confirming entries should not make a version")
+	}
+	now = now.Add(40 * time.Minute)
+	if dropped := v.Compact(); dropped != 5 {
+		t.Errorf("expected the 5 unconfirmed entries to expire, dropped %d", dropped)
+	}
+	if changes, full := v.Since(2); !full || changes.Size() != 5 {
+		t.Errorf("holders should get the whole set after entries expire")
+	}
+}
@@ -0,0 +1,126 @@
+package set
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog precision: 2^14 one-byte registers, 16 KB, and a standard
+// error of about 1.04/sqrt(2^14), under 1%, however many items are added.
+const HLL_PRECISION = 14
+const HLL_MIN_PRECISION = 4
+const HLL_MAX_PRECISION = 18
+
+var ErrSketchMismatch = errors.New("set: sketches of different sizes cannot be merged")
+
+// HyperLogLog estimates how many distinct items were added, in constant
+// memory (Flajolet et al.). Two HyperLogLogs merge into the sketch of the
+// union of what was added to either, and adding an item twice, or merging
+// the same sketch twice, changes nothing. The hash does not depend on the
+// process, so sketches made by different monitors can be merged.
+// It is not safe for concurrent use.
+type HyperLogLog[T comparable] struct {
+	Registers []uint8 //the most leading zeros seen among the hashes landing in each register, plus one
+	Precision uint8
+}
+
+// NewHyperLogLog makes a sketch with 2^precision registers. The precision
+// is clamped to between HLL_MIN_PRECISION and HLL_MAX_PRECISION.
+func NewHyperLogLog[T comparable](precision int) *HyperLogLog[T] {
+	if precision < HLL_MIN_PRECISION {
+		precision = HLL_MIN_PRECISION
+	}
+	if precision > HLL_MAX_PRECISION {
+		precision = HLL_MAX_PRECISION
+	}
+	return &HyperLogLog[T]{Registers: make([]uint8, 1<<precision), Precision: uint8(precision)}
+}
+
+//a 64 bit hash of an item that is the same in every process
+func sketchHash[T comparable](item T) uint64 {
+	var buf [32]byte
+	h := fnv.New64a()
+	h.Write(itemBytes(item, buf[:0]))
+	return mix(h.Sum64())
+}
+
+func (h *HyperLogLog[T]) Add(item T) {
+	hash := sketchHash(item)
+	p := h.Precision
+	register := hash >> (64 - p)
+	//the rest of the hash, with a bit set so it has at most 64-p leading zeros
+	rest := hash<<p | 1<<(p-1)
+	rank := uint8(bits.LeadingZeros64(rest) + 1)
+	if rank > h.Registers[register] {
+		h.Registers[register] = rank
+	}
+}
+
+// Count estimates the number of distinct items added.
+func (h *HyperLogLog[T]) Count() uint64 {
+	m := float64(len(h.Registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.Registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(h.Registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 { //few items, so count the empty registers instead
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// Merge makes h the sketch of everything added to h or other.
+func (h *HyperLogLog[T]) Merge(other *HyperLogLog[T]) error {
+	if h.Precision != other.Precision || len(h.Registers) != len(other.Registers) {
+		return ErrSketchMismatch
+	}
+	for i, r := range other.Registers {
+		if r > h.Registers[i] {
+			h.Registers[i] = r
+		}
+	}
+	return nil
+}
+
+func (h *HyperLogLog[T]) Clone() *HyperLogLog[T] {
+	registers := make([]uint8, len(h.Registers))
+	copy(registers, h.Registers)
+	return &HyperLogLog[T]{Registers: registers, Precision: h.Precision}
+}
+
+func (h *HyperLogLog[T]) Wipe() {
+	h.Registers = make([]uint8, len(h.Registers))
+}
+
+// Overlap estimates how many distinct items two sketches have in common,
+// as |a| + |b| - |a ∪ b|. The error is that of the larger sketches, so
+// small overlaps of large sets are rough.
+func Overlap[T comparable](a, b *HyperLogLog[T]) (uint64, error) {
+	union := a.Clone()
+	if err := union.Merge(b); err != nil {
+		return 0, err
+	}
+	both := int64(a.Count()) + int64(b.Count()) - int64(union.Count())
+	if both < 0 {
+		return 0, nil
+	}
+	return uint64(both), nil
+}
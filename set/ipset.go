@@ -1,179 +1,255 @@
 //SPECIAL DATA STRUCTURE: the IP set
-//SIZE: 32x32
-//subnet slices are smaller.
+//each IPv4 address maps directly to its own bit, so all of IPv4 takes 2^32 bits (512 MB).
+//prefix slices are smaller: a /8 takes 2 MB, a /16 8 KB and a /24 32 bytes.
+//For example, a set for 111.200.0.0/16 holds every address "111.200.(...)"
 
 package set
 
-/**
 import (
-	"sync"
+	"bufio"
+	"encoding/binary"
 	"errors"
-	"strings"
 	"fmt"
-	"strconv"
+	"io"
+	"math/bits"
+	"net"
 )
 
-// IPSet type cotains library IPSet and Hasher function
+// IPSet is a bitmap of every address in one IPv4 prefix.
+// Fields are exported so the set can travel over gob.
 type IPSet struct {
-	BS []bool
+	Base      uint32 //first address in the prefix
+	PrefixLen int    //0 covers all of IPv4
+	Bits      *BitSet
 }
 
-func bytesToUint32(byts []byte) uint32 {
-	if(len(byts)) == 1 {
-		return uint32(byts[0])
-	}
-	var n uint32 = 0;
-	for i := len(byts)-1; i>0; i-- {
-		n += uint32(byts[i])
-		n = n << 8
-	}
-	n+= uint32(byts[0])
-	return n
+func bytesToUint32(b [4]byte) uint32 {
+	return binary.BigEndian.Uint32(b[:])
 }
 
-func uint32ToBytes(n uint32) []byte{
-	fmt.Println("n is", n)
-	bytez := make([]byte, 4)
-	mask := uint32(255)
-	for i := 0; i < 4; i++ {
-		n2 := n & mask //last 8 bits
-		fmt.Println("n2 is", n2)
-		bytez[i] = uint8(n2);
-		n = n >> 8 //shift it down
-		fmt.Println("n is", n, "after shift")
-	}
-	fmt.Println(bytez)
-	return bytez
+func uint32ToBytes(n uint32) [4]byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	return b
+}
+
+func addressString(addr [4]byte) string {
+	return fmt.Sprintf("%v.%v.%v.%v", addr[0], addr[1], addr[2], addr[3])
 }
 
-func uint32ToString(n uint32) string {
-	bytez := uint32ToBytes(n)
-	s := &strings.Builder{}
-	for _, b := range(bytez[:3]) {
-		s.WriteString(strconv.Itoa(int(b)))
-		s.WriteString(".")
+// ParseAddress reads a dotted quad, and reports false for anything else.
+func ParseAddress(s string) ([4]byte, bool) {
+	var addr [4]byte
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return addr, false
 	}
-	s.WriteString(strconv.Itoa(int(bytez[3])))
-	return s.String()
+	copy(addr[:], ip)
+	return addr, true
 }
 
-// NewIPSet constructor for IPSet with an array of m bits
-func NewIPSet(numBits uint) *IPSet {
-	bs := make([]bool, uint32(1) << numBits-1)
-	return &IPSet{BS:bs}
+// NewIPPrefixSet makes an empty bitmap covering prefix/prefixLen.
+func NewIPPrefixSet(prefix [4]byte, prefixLen int) (*IPSet, error) {
+	if prefixLen < 0 || prefixLen > 32 {
+		return nil, errors.New("prefix length must be between 0 and 32")
+	}
+	size := uint64(1) << (32 - prefixLen)
+	base := bytesToUint32(prefix) &^ uint32(size-1)
+	return &IPSet{Base: base, PrefixLen: prefixLen, Bits: NewBitSet(size)}, nil
 }
 
+// NewIPv4Set makes an empty bitmap of all IPv4 space. It takes 512 MB.
 func NewIPv4Set() *IPSet {
-	return NewIPSet(32)
+	s, _ := NewIPPrefixSet([4]byte{}, 0)
+	return s
 }
 
-// Add IP to IPSet based on itself
-func (BS *IPSet) Add(elem []byte) {
-	var i = bytesToUint32(elem)
-	fmt.Println("index is",i);
-	BS.BS[i] = true
+func NewSafeIPv4Set() *SafeSet[[4]byte] {
+	return NewSafeSet[[4]byte](NewIPv4Set())
 }
 
-func (BS *IPSet) REmove(elem []byte) {
-	var i = bytesToUint32(elem)
-	BS.BS[i] = false
+// Covers reports whether addr falls inside the set's prefix.
+func (s *IPSet) Covers(addr [4]byte) bool {
+	return s.covers(bytesToUint32(addr))
 }
 
-// Check element in IPSet
-func (BS *IPSet) Check(elem []byte) bool {
-	var i = bytesToUint32(elem)
-	return BS.BS[i]
+func (s *IPSet) covers(n uint32) bool {
+	return uint64(n-s.Base) < s.Bits.Length && n >= s.Base
 }
 
-
-
-// Union of two IPSets
-func (BS *IPSet) Union(BS2 *IPSet) (error) {
-	if len(BS.BS) != len(BS2.BS) {
-		return errors.New("need same size for union")
+// Add ignores addresses outside the prefix, which cannot be in the set.
+func (s *IPSet) Add(addr [4]byte) {
+	if n := bytesToUint32(addr); s.covers(n) {
+		s.Bits.Set(uint64(n - s.Base))
 	}
-	for i, _ := range(BS.BS) {
-		BS.BS[i] = BS.BS[i] && BS2.BS[i]
-	}
-	return nil
 }
 
-func (BS *IPSet) getCount() uint32 {
-	var count uint32
-	for _, val := range(BS.BS) {
-		if val {
-			count+=1
-		}
+func (s *IPSet) Remove(addr [4]byte) {
+	if n := bytesToUint32(addr); s.covers(n) {
+		s.Bits.Clear(uint64(n - s.Base))
 	}
-	return count
 }
 
-func (s *Set) ToIPSet() *IPSet{
-	b := NewIPv4Set() //TODO: research the right size for this.
-	mp := s.Mp //TODO make set iterable
-	for item := range(mp) {
-		b.Add([]byte(item))
-	}
-	return b
+func (s *IPSet) Contains(addr [4]byte) bool {
+	n := bytesToUint32(addr)
+	return s.covers(n) && s.Bits.IsSet(uint64(n-s.Base))
+}
+
+// number of addresses in the set
+func (s *IPSet) Count() uint64 {
+	return s.Bits.Count()
 }
 
+func (s *IPSet) Size() int {
+	return int(s.Count())
+}
+
+func (s *IPSet) Wipe() {
+	s.Bits.Wipe()
+}
+
+func (s *IPSet) sameShape(other *IPSet) bool {
+	return s.Base == other.Base && s.PrefixLen == other.PrefixLen
+}
 
-//following section by Ari:
-type SafeIPSet struct {
-	IPS *IPSet
-	lock sync.Mutex
+// expands the set into its union with another set over the same prefix
+func (s *IPSet) Union(other *IPSet) error {
+	if !s.sameShape(other) {
+		return errors.New("need same prefix for union")
+	}
+	return s.Bits.Union(other.Bits)
 }
 
+// reduces the set to the addresses not in another set over the same prefix
+func (s *IPSet) Difference(other *IPSet) error {
+	if !s.sameShape(other) {
+		return errors.New("need same prefix for difference")
+	}
+	for i, w := range other.Bits.Words {
+		s.Bits.Words[i] &^= w
+	}
+	return nil
+}
 
-func NewSafeIPSet(m uint) *SafeIPSet {
-	bitst := &SafeIPSet{IPS:NewIPSet(m), lock:sync.Mutex{}}
-	return bitst
+// expands the set into its union with another set. Another IPSet must cover
+// the same prefix; the addresses of any other listable set are added one by one.
+func (s *IPSet) UnionWith(s2 Container[[4]byte]) error {
+	switch other := s2.(type) {
+	case *IPSet:
+		return s.Union(other)
+	case Iterable[[4]byte]:
+		other.Each(func(addr [4]byte) bool {
+			s.Add(addr)
+			return true
+		})
+		return nil
+	}
+	return ErrNotIterable
+}
+
+// Each calls fn on every address in order, lowest first, until fn returns false.
+func (s *IPSet) Each(fn func([4]byte) bool) {
+	for i, w := range s.Bits.Words {
+		for w != 0 {
+			bit := bits.TrailingZeros64(w)
+			w &= w - 1
+			if !fn(uint32ToBytes(s.Base + uint32(i*64+bit))) {
+				return
+			}
+		}
+	}
 }
 
-// Union of safe IPSet and regular IPSet
-func (sBs *SafeIPSet) Union(that interface{}) (uint32, error) {
-	sBs.lock.Lock()
-	defer sBs.lock.Unlock()
-	other, ok := that.(*IPSet)
-	if !ok {
-		return sBs.IPS.getCount(), errors.New("not an ip set")
+// Slice copies out the part of the set inside a longer prefix, so a /8, /16
+// or /24 can be handed around without the whole bitmap.
+func (s *IPSet) Slice(prefix [4]byte, prefixLen int) (*IPSet, error) {
+	if prefixLen < s.PrefixLen {
+		return nil, errors.New("slice must be a longer prefix than the set")
+	}
+	sub, err := NewIPPrefixSet(prefix, prefixLen)
+	if err != nil {
+		return nil, err
+	}
+	if !s.covers(sub.Base) {
+		return nil, errors.New("slice is outside the set's prefix")
+	}
+	offset := uint64(sub.Base - s.Base)
+	if offset%64 == 0 && sub.Bits.Length%64 == 0 {
+		copy(sub.Bits.Words, s.Bits.Words[offset/64:])
+		return sub, nil
 	}
-	sBs.IPS.Union(other)
-	return sBs.IPS.getCount(), nil
+	for i := uint64(0); i < sub.Bits.Length; i++ {
+		if s.Bits.IsSet(offset + i) {
+			sub.Bits.Set(i)
+		}
+	}
+	return sub, nil
 }
 
-func (sBs *SafeIPSet) Wipe(newsize uint) {
-	sBs.lock.Lock()
-	defer sBs.lock.Unlock()
-	sBs.IPS = NewIPSet(newsize)
+// WriteCSV streams the addresses in order, one dotted quad per line.
+func (s *IPSet) WriteCSV(w io.Writer) error {
+	return writeAddressCSV(w, s.Each)
 }
 
-func (sBs *SafeIPSet) AddString(s string) {
-	sBs.lock.Lock()
-	defer sBs.lock.Unlock()
-	sBs.IPS.Add([]byte(s))
+// ReadCSV adds every address in r. Addresses outside the prefix are an error.
+func (s *IPSet) ReadCSV(r io.Reader) error {
+	return readAddressCSV(r, func(addr [4]byte) error {
+		if !s.Covers(addr) {
+			return fmt.Errorf("set: %v is outside the set's prefix", addressString(addr))
+		}
+		s.Add(addr)
+		return nil
+	})
 }
 
-func (sBs *SafeIPSet) CheckString(s string) bool {
-	sBs.lock.Lock()
-	defer sBs.lock.Unlock()
-	return sBs.IPS.Check([]byte(s))
+//turns a Set into a CSV, in address order
+func (s *IPSet) ToCSV() string {
+	return addressesCSV(s.Each)
 }
 
-func (IPS *IPSet) ToCSV() string {
-	s := &strings.Builder{}
-	for i, val := range(IPS.BS) {
-		if val {
-			s.WriteString(uint32ToString(uint32(i)))
-			s.WriteString(",")
+// WriteBinary writes the raw bitmap: the header, the base address, the
+// prefix length, then the words in little endian order.
+func (s *IPSet) WriteBinary(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if err := writeHeader(bw, ipSetKind); err != nil {
+		return err
+	}
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header, s.Base)
+	header[4] = byte(s.PrefixLen)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	word := make([]byte, 8)
+	for _, wd := range s.Bits.Words {
+		binary.LittleEndian.PutUint64(word, wd)
+		if _, err := bw.Write(word); err != nil {
+			return err
 		}
 	}
-	return s.String()
+	return bw.Flush()
 }
 
-func (SPS *SafeIPSet) ToCSV() string {
-	SPS.lock.Lock();
-	defer SPS.lock.Unlock();
-	return SPS.IPS.ToCSV();
+// ReadIPSet reads a bitmap written by WriteBinary.
+func ReadIPSet(r io.Reader) (*IPSet, error) {
+	br := bufferedReader(r)
+	if err := readHeader(br, ipSetKind); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, unexpected(err)
+	}
+	s, err := NewIPPrefixSet(uint32ToBytes(binary.BigEndian.Uint32(header)), int(header[4]))
+	if err != nil {
+		return nil, err
+	}
+	word := make([]byte, 8)
+	for i := range s.Bits.Words {
+		if _, err := io.ReadFull(br, word); err != nil {
+			return nil, unexpected(err)
+		}
+		s.Bits.Words[i] = binary.LittleEndian.Uint64(word)
+	}
+	return s, nil
 }
-**/
\ No newline at end of file
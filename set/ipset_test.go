@@ -0,0 +1,77 @@
+package set
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestIPSetAlgebra(t *testing.T) {
+	a, err := NewIPPrefixSet([4]byte{10, 1, 0, 0}, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewIPPrefixSet([4]byte{10, 1, 200, 3}, 16) //host bits are dropped
+	a.Add([4]byte{10, 1, 0, 1})
+	a.Add([4]byte{10, 1, 255, 255})
+	a.Add([4]byte{10, 2, 0, 1}) //outside the prefix
+	b.Add([4]byte{10, 1, 0, 1})
+	b.Add([4]byte{10, 1, 3, 4})
+
+	if err := a.Union(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count() != 3 || a.Contains([4]byte{10, 2, 0, 1}) {
+		t.Errorf("expected 3 addresses inside the prefix, got %v", a.ToCSV())
+	}
+	if err := a.Difference(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count() != 1 || !a.Contains([4]byte{10, 1, 255, 255}) {
+		t.Errorf("expected only 10.1.255.255 left, got %v", a.ToCSV())
+	}
+	c, _ := NewIPPrefixSet([4]byte{10, 2, 0, 0}, 16)
+	if err := a.Union(c); err == nil {
+		t.Errorf("expected an error for a union of different prefixes")
+	}
+}
+
+func TestIPSetOrderAndExport(t *testing.T) {
+	s := NewIPv4Set()
+	for _, addr := range []string{"200.1.1.1", "1.2.3.4", "10.0.0.1", "255.255.255.255", "0.0.0.0"} {
+		ip, ok := ParseAddress(addr)
+		if !ok {
+			t.Fatalf("could not parse %v", addr)
+		}
+		s.Add(ip)
+	}
+	csv := &strings.Builder{}
+	if err := s.WriteCSV(csv); err != nil {
+		t.Fatal(err)
+	}
+	expected := "0.0.0.0\n1.2.3.4\n10.0.0.1\n200.1.1.1\n255.255.255.255\n"
+	if csv.String() != expected {
+		t.Errorf("expected addresses in order, got %q", csv.String())
+	}
+
+	slice, err := s.Slice([4]byte{10, 0, 0, 0}, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slice.Count() != 1 || !slice.Contains([4]byte{10, 0, 0, 1}) {
+		t.Errorf("expected the /8 slice to hold 10.0.0.1, got %v", slice.ToCSV())
+	}
+
+	small, _ := s.Slice([4]byte{1, 2, 3, 0}, 29)
+	buf := &bytes.Buffer{}
+	if err := small.WriteBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	back, err := ReadIPSet(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Count() != 1 || !back.Contains([4]byte{1, 2, 3, 4}) || back.PrefixLen != 29 {
+		t.Errorf("binary round trip lost data: %v", back.ToCSV())
+	}
+}
@@ -0,0 +1,412 @@
+//PREFIX TRIES
+//A PrefixTrie holds IPv4 and IPv6 prefixes, each with a value, in a binary
+//trie: one level per bit of the address. It answers "which of these prefixes
+//is the longest one containing this address" in at most 32 or 128 steps,
+//which is what blocklists, ASN annotation and range assignment need.
+//A PrefixSet is a PrefixTrie without values, and can be aggregated,
+//intersected and subtracted as a set of addresses.
+
+package set
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+)
+
+type trieNode[V any] struct {
+	children [2]*trieNode[V]
+	set      bool //a prefix ends here
+	value    V
+}
+
+// PrefixTrie maps prefixes to values. Prefixes are stored masked, so
+// 10.1.2.3/8 and 10.0.0.0/8 are the same. It is not safe for concurrent use.
+// As a Container, its items are prefixes, each held with the zero value
+// unless it was inserted with another.
+type PrefixTrie[V any] struct {
+	roots [2]*trieNode[V] //IPv4, then IPv6
+	size  int
+}
+
+// PrefixSet is a set of prefixes, which is also a set of the addresses in them.
+type PrefixSet = PrefixTrie[struct{}]
+
+var ErrBadPrefix = errors.New("set: not a valid prefix")
+
+func NewPrefixTrie[V any]() *PrefixTrie[V] {
+	return &PrefixTrie[V]{}
+}
+
+func NewPrefixSet() *PrefixSet {
+	return NewPrefixTrie[struct{}]()
+}
+
+//the trie of an address family, and its address as bytes
+func family(addr netip.Addr) (int, []byte) {
+	addr = addr.Unmap()
+	if addr.Is4() {
+		b := addr.As4()
+		return 0, b[:]
+	}
+	b := addr.As16()
+	return 1, b[:]
+}
+
+func bitAt(b []byte, i int) int {
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+//the prefix of the first bits of b
+func prefixOf(fam int, b []byte, bits int) netip.Prefix {
+	var addr netip.Addr
+	if fam == 0 {
+		addr = netip.AddrFrom4([4]byte(b))
+	} else {
+		addr = netip.AddrFrom16([16]byte(b))
+	}
+	p, _ := addr.Prefix(bits)
+	return p
+}
+
+//the node of a prefix. with create, it and the nodes above it are made if missing.
+func (t *PrefixTrie[V]) node(p netip.Prefix, create bool) *trieNode[V] {
+	if !p.IsValid() {
+		return nil
+	}
+	fam, b := family(p.Addr())
+	bits := p.Bits()
+	if fam == 0 && p.Addr().Is4In6() {
+		bits -= 96
+		if bits < 0 {
+			return nil
+		}
+	}
+	n := t.roots[fam]
+	if n == nil {
+		if !create {
+			return nil
+		}
+		n = &trieNode[V]{}
+		t.roots[fam] = n
+	}
+	for i := 0; i < bits; i++ {
+		next := n.children[bitAt(b, i)]
+		if next == nil {
+			if !create {
+				return nil
+			}
+			next = &trieNode[V]{}
+			n.children[bitAt(b, i)] = next
+		}
+		n = next
+	}
+	return n
+}
+
+// Insert adds p with value v, replacing the value p had. It returns
+// ErrBadPrefix if p is not valid.
+func (t *PrefixTrie[V]) Insert(p netip.Prefix, v V) error {
+	n := t.node(p, true)
+	if n == nil {
+		return ErrBadPrefix
+	}
+	if !n.set {
+		t.size++
+	}
+	n.set = true
+	n.value = v
+	return nil
+}
+
+// Add adds p with the zero value, or leaves it as it is if it is already there.
+func (t *PrefixTrie[V]) Add(p netip.Prefix) {
+	if n := t.node(p, true); n != nil && !n.set {
+		n.set = true
+		t.size++
+	}
+}
+
+// Delete takes p out, and reports whether it was there. Prefixes inside
+// p stay.
+func (t *PrefixTrie[V]) Delete(p netip.Prefix) bool {
+	n := t.node(p, false)
+	if n == nil || !n.set {
+		return false
+	}
+	var zero V
+	n.set = false
+	n.value = zero
+	t.size--
+	t.prune()
+	return true
+}
+
+func (t *PrefixTrie[V]) Remove(p netip.Prefix) {
+	t.Delete(p)
+}
+
+//drops the nodes with no prefix at or below them
+func (t *PrefixTrie[V]) prune() {
+	var prune func(n *trieNode[V]) *trieNode[V]
+	prune = func(n *trieNode[V]) *trieNode[V] {
+		if n == nil {
+			return nil
+		}
+		n.children[0] = prune(n.children[0])
+		n.children[1] = prune(n.children[1])
+		if !n.set && n.children[0] == nil && n.children[1] == nil {
+			return nil
+		}
+		return n
+	}
+	t.roots[0] = prune(t.roots[0])
+	t.roots[1] = prune(t.roots[1])
+}
+
+// Get returns the value of exactly p.
+func (t *PrefixTrie[V]) Get(p netip.Prefix) (V, bool) {
+	var zero V
+	n := t.node(p, false)
+	if n == nil || !n.set {
+		return zero, false
+	}
+	return n.value, true
+}
+
+// Contains reports whether exactly p is in the trie. Use Covers to ask
+// whether an address is inside any prefix.
+func (t *PrefixTrie[V]) Contains(p netip.Prefix) bool {
+	n := t.node(p, false)
+	return n != nil && n.set
+}
+
+// Lookup finds the longest prefix containing addr, and its value.
+func (t *PrefixTrie[V]) Lookup(addr netip.Addr) (netip.Prefix, V, bool) {
+	var value V
+	if !addr.IsValid() {
+		return netip.Prefix{}, value, false
+	}
+	fam, b := family(addr)
+	n := t.roots[fam]
+	longest := -1
+	for i := 0; n != nil; i++ {
+		if n.set {
+			longest = i
+			value = n.value
+		}
+		if i == len(b)*8 {
+			break
+		}
+		n = n.children[bitAt(b, i)]
+	}
+	if longest < 0 {
+		return netip.Prefix{}, value, false
+	}
+	return prefixOf(fam, b, longest), value, true
+}
+
+// Covers reports whether addr is inside any prefix in the trie.
+func (t *PrefixTrie[V]) Covers(addr netip.Addr) bool {
+	_, _, ok := t.Lookup(addr)
+	return ok
+}
+
+func (t *PrefixTrie[V]) Size() int {
+	return t.size
+}
+
+func (t *PrefixTrie[V]) Wipe() {
+	t.roots = [2]*trieNode[V]{}
+	t.size = 0
+}
+
+// Walk calls fn on every prefix and its value in CIDR order: IPv4 first, by
+// address, and a prefix before the longer prefixes inside it. It stops when
+// fn returns false.
+func (t *PrefixTrie[V]) Walk(fn func(netip.Prefix, V) bool) {
+	for fam, root := range t.roots {
+		b := make([]byte, 4+12*fam)
+		var walk func(n *trieNode[V], depth int) bool
+		walk = func(n *trieNode[V], depth int) bool {
+			if n == nil {
+				return true
+			}
+			if n.set && !fn(prefixOf(fam, b, depth), n.value) {
+				return false
+			}
+			if !walk(n.children[0], depth+1) {
+				return false
+			}
+			if n.children[1] == nil {
+				return true
+			}
+			b[depth/8] |= 1 << (7 - depth%8)
+			more := walk(n.children[1], depth+1)
+			b[depth/8] &^= 1 << (7 - depth%8)
+			return more
+		}
+		if !walk(root, 0) {
+			return
+		}
+	}
+}
+
+// Each calls fn on every prefix in CIDR order until fn returns false.
+func (t *PrefixTrie[V]) Each(fn func(netip.Prefix) bool) {
+	t.Walk(func(p netip.Prefix, _ V) bool {
+		return fn(p)
+	})
+}
+
+func (t *PrefixTrie[V]) Prefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, t.size)
+	t.Each(func(p netip.Prefix) bool {
+		prefixes = append(prefixes, p)
+		return true
+	})
+	return prefixes
+}
+
+// adds the prefixes of another listable set, keeping the values of those
+// already here
+func (t *PrefixTrie[V]) UnionWith(s2 Container[netip.Prefix]) error {
+	other, ok := s2.(Iterable[netip.Prefix])
+	if !ok {
+		return ErrNotIterable
+	}
+	other.Each(func(p netip.Prefix) bool {
+		t.Add(p)
+		return true
+	})
+	return nil
+}
+
+//turns the prefixes into a CSV, in CIDR order
+func (t *PrefixTrie[V]) ToCSV() string {
+	record := make([]string, 0, t.size)
+	t.Each(func(p netip.Prefix) bool {
+		record = append(record, p.String())
+		return true
+	})
+	return strings.Join(record, ",") + "\n"
+}
+
+// ParsePrefixSet reads prefixes in CIDR notation, separated by commas or
+// lines, such as the output of ToCSV. A bare address is a prefix of one address.
+func ParsePrefixSet(text string) (*PrefixSet, error) {
+	s := NewPrefixSet()
+	err := scanCSV(strings.NewReader(text), func(field string) error {
+		field = strings.TrimSpace(field)
+		p, err := netip.ParsePrefix(field)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(field)
+			if addrErr != nil {
+				return err
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		s.Add(p.Masked())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//---------set operations on the addresses in prefixes----------------//
+
+// combines the addresses of a and b with keep, which says whether an
+// address is in the result from whether it is in a and in b. The result
+// is aggregated.
+func combine[V, W any](a *PrefixTrie[V], b *PrefixTrie[W], keep func(inA, inB bool) bool) *PrefixSet {
+	result := NewPrefixSet()
+	for fam := range a.roots {
+		path := make([]byte, 4+12*fam)
+		var walk func(x *trieNode[V], y *trieNode[W], inA, inB bool, depth int)
+		walk = func(x *trieNode[V], y *trieNode[W], inA, inB bool, depth int) {
+			inA = inA || (x != nil && x.set)
+			inB = inB || (y != nil && y.set)
+			//below a prefix, or where there are none, every address is the same
+			doneA := inA || x == nil
+			doneB := inB || y == nil
+			if doneA && doneB || depth == len(path)*8 {
+				if keep(inA, inB) {
+					result.Add(prefixOf(fam, path, depth))
+				}
+				return
+			}
+			for bit := 0; bit < 2; bit++ {
+				var cx *trieNode[V]
+				var cy *trieNode[W]
+				if !doneA {
+					cx = x.children[bit]
+				}
+				if !doneB {
+					cy = y.children[bit]
+				}
+				if bit == 1 {
+					path[depth/8] |= 1 << (7 - depth%8)
+				}
+				walk(cx, cy, inA, inB, depth+1)
+			}
+			path[depth/8] &^= 1 << (7 - depth%8)
+		}
+		walk(a.roots[fam], b.roots[fam], false, false, 0)
+	}
+	aggregate(result)
+	return result
+}
+
+//merges the prefixes of a set into the fewest that cover the same
+//addresses: prefixes inside others go, and two halves become their whole.
+func aggregate(s *PrefixSet) {
+	size := 0
+	var merge func(n *trieNode[struct{}]) *trieNode[struct{}]
+	merge = func(n *trieNode[struct{}]) *trieNode[struct{}] {
+		if n == nil {
+			return nil
+		}
+		if !n.set {
+			n.children[0] = merge(n.children[0])
+			n.children[1] = merge(n.children[1])
+			if n.children[0] == nil && n.children[1] == nil {
+				return nil
+			}
+			if n.children[0] != nil && n.children[1] != nil && n.children[0].set && n.children[1].set {
+				n.set = true
+				size -= 2
+			}
+		}
+		if n.set {
+			n.children = [2]*trieNode[struct{}]{}
+			size++
+		}
+		return n
+	}
+	s.roots[0] = merge(s.roots[0])
+	s.roots[1] = merge(s.roots[1])
+	s.size = size
+}
+
+// Aggregate returns the fewest prefixes covering the same addresses as t.
+func (t *PrefixTrie[V]) Aggregate() *PrefixSet {
+	return combine(t, NewPrefixSet(), func(inA, _ bool) bool { return inA })
+}
+
+// Union returns the prefixes covering the addresses in either t or other.
+func (t *PrefixTrie[V]) Union(other *PrefixSet) *PrefixSet {
+	return combine(t, other, func(inA, inB bool) bool { return inA || inB })
+}
+
+// Intersect returns the prefixes covering the addresses in both t and other.
+func (t *PrefixTrie[V]) Intersect(other *PrefixSet) *PrefixSet {
+	return combine(t, other, func(inA, inB bool) bool { return inA && inB })
+}
+
+// Subtract returns the prefixes covering the addresses in t but not other,
+// such as the targets left once a blocklist is taken out.
+func (t *PrefixTrie[V]) Subtract(other *PrefixSet) *PrefixSet {
+	return combine(t, other, func(inA, inB bool) bool { return inA && !inB })
+}
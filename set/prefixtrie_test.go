@@ -0,0 +1,78 @@
+package set
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixTrieLongestMatch(t *testing.T) {
+	asns := NewPrefixTrie[int]()
+	for prefix, asn := range map[string]int{
+		"10.0.0.0/8":    1,
+		"10.1.0.0/16":   2,
+		"10.1.2.0/24":   3,
+		"2001:db8::/32": 4,
+		"192.0.2.7/32":  5,
+		"0.0.0.0/0":     6,
+	} {
+		if err := asns.Insert(netip.MustParsePrefix(prefix), asn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for addr, want := range map[string]int{
+		"10.1.2.3":        3,
+		"10.1.3.3":        2,
+		"10.2.0.1":        1,
+		"192.0.2.7":       5,
+		"192.0.2.8":       6,
+		"::ffff:10.1.2.3": 3,
+		"2001:db8::1":     4,
+	} {
+		_, asn, ok := asns.Lookup(netip.MustParseAddr(addr))
+		if !ok || asn != want {
+			t.Errorf("%v: expected AS %d, got %d", addr, want, asn)
+		}
+	}
+	if _, _, ok := asns.Lookup(netip.MustParseAddr("2001:db9::1")); ok {
+		t.Errorf("an IPv4 default route should not match IPv6")
+	}
+
+	if !asns.Delete(netip.MustParsePrefix("10.1.0.0/16")) || asns.Size() != 5 {
+		t.Errorf("expected to delete the /16")
+	}
+	if p, asn, _ := asns.Lookup(netip.MustParseAddr("10.1.3.3")); asn != 1 || p.Bits() != 8 {
+		t.Errorf("expected the /8 once the /16 is gone, got %v", p)
+	}
+	if asn, ok := asns.Get(netip.MustParsePrefix("10.1.2.99/24")); !ok || asn != 3 {
+		t.Errorf("prefixes should be masked")
+	}
+	if err := asns.Insert(netip.Prefix{}, 0); err != ErrBadPrefix {
+		t.Errorf("expected ErrBadPrefix, got %v", err)
+	}
+}
+
+func TestPrefixSetOperations(t *testing.T) {
+	targets, err := ParsePrefixSet("10.0.0.0/25,10.0.0.128/25,10.0.1.0/24,10.0.0.5\n192.0.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if csv := targets.ToCSV(); csv != "10.0.0.0/25,10.0.0.5/32,10.0.0.128/25,10.0.1.0/24,192.0.2.0/24\n" {
+		t.Errorf("not in CIDR order: %q", csv)
+	}
+	if csv := targets.Aggregate().ToCSV(); csv != "10.0.0.0/23,192.0.2.0/24\n" {
+		t.Errorf("unexpected aggregate %q", csv)
+	}
+	blocked, _ := ParsePrefixSet("10.0.0.0/24,192.0.2.128/26,198.51.100.0/24")
+	if csv := targets.Subtract(blocked).ToCSV(); csv != "10.0.1.0/24,192.0.2.0/25,192.0.2.192/26\n" {
+		t.Errorf("unexpected difference %q", csv)
+	}
+	if csv := targets.Intersect(blocked).ToCSV(); csv != "10.0.0.0/24,192.0.2.128/26\n" {
+		t.Errorf("unexpected intersection %q", csv)
+	}
+	if csv := targets.Union(blocked).ToCSV(); csv != "10.0.0.0/23,192.0.2.0/24,198.51.100.0/24\n" {
+		t.Errorf("unexpected union %q", csv)
+	}
+	if _, err := ParsePrefixSet("10.0.0.0/33"); err == nil {
+		t.Errorf("expected an error for a bad prefix")
+	}
+}
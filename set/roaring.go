@@ -0,0 +1,479 @@
+package set
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+//a container switches from a sorted array to a bitmap past this many
+//addresses, where the 8 KB bitmap becomes the smaller of the two.
+const ROARING_ARRAY_MAX = 4096
+
+// RoaringSet is a compressed set of IPv4 addresses, in the style of roaring
+// bitmaps. Addresses are grouped by their upper 16 bits, and each group is
+// kept as a sorted array of the lower 16 bits while it is sparse, or as a
+// 65,536 bit bitmap once it is dense. Clustered addresses, like the
+// interfaces a monitor finds, take a few bytes each.
+type RoaringSet struct {
+	keys       []uint16 //sorted upper 16 bits, one per container
+	containers []*container
+}
+
+type container struct {
+	array  []uint16 //sorted, used while card <= ROARING_ARRAY_MAX
+	bitmap []uint64 //1024 words, used once the array would be too big
+	card   int
+}
+
+func NewRoaringSet() *RoaringSet {
+	return &RoaringSet{}
+}
+
+func NewSafeRoaringSet() *SafeSet[[4]byte] {
+	return NewSafeSet[[4]byte](NewRoaringSet())
+}
+
+//---------containers-------------------------------------------------//
+
+func (c *container) contains(low uint16) bool {
+	if c.bitmap != nil {
+		return c.bitmap[low/64]&(1<<(low%64)) != 0
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	return i < len(c.array) && c.array[i] == low
+}
+
+func (c *container) add(low uint16) {
+	if c.bitmap != nil {
+		if c.bitmap[low/64]&(1<<(low%64)) == 0 {
+			c.bitmap[low/64] |= 1 << (low % 64)
+			c.card++
+		}
+		return
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	if i < len(c.array) && c.array[i] == low {
+		return
+	}
+	c.array = append(c.array, 0)
+	copy(c.array[i+1:], c.array[i:])
+	c.array[i] = low
+	c.card++
+	if c.card > ROARING_ARRAY_MAX {
+		c.toBitmap()
+	}
+}
+
+func (c *container) remove(low uint16) {
+	if c.bitmap != nil {
+		if c.bitmap[low/64]&(1<<(low%64)) != 0 {
+			c.bitmap[low/64] &^= 1 << (low % 64)
+			c.card--
+			if c.card <= ROARING_ARRAY_MAX {
+				c.toArray()
+			}
+		}
+		return
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	if i < len(c.array) && c.array[i] == low {
+		c.array = append(c.array[:i], c.array[i+1:]...)
+		c.card--
+	}
+}
+
+func (c *container) toBitmap() {
+	c.bitmap = make([]uint64, 1024)
+	for _, low := range c.array {
+		c.bitmap[low/64] |= 1 << (low % 64)
+	}
+	c.array = nil
+}
+
+func (c *container) toArray() {
+	array := make([]uint16, 0, c.card)
+	c.each(func(low uint16) bool {
+		array = append(array, low)
+		return true
+	})
+	c.array = array
+	c.bitmap = nil
+}
+
+//calls fn on each lower half in order until it returns false
+func (c *container) each(fn func(uint16) bool) bool {
+	if c.bitmap == nil {
+		for _, low := range c.array {
+			if !fn(low) {
+				return false
+			}
+		}
+		return true
+	}
+	for i, w := range c.bitmap {
+		for w != 0 {
+			bit := bits.TrailingZeros64(w)
+			w &= w - 1
+			if !fn(uint16(i*64 + bit)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//returns c with every value of other added. c may be reused.
+func (c *container) union(other *container) *container {
+	if c.bitmap == nil && other.bitmap == nil {
+		merged := make([]uint16, 0, len(c.array)+len(other.array))
+		i, j := 0, 0
+		for i < len(c.array) && j < len(other.array) {
+			switch {
+			case c.array[i] < other.array[j]:
+				merged = append(merged, c.array[i])
+				i++
+			case c.array[i] > other.array[j]:
+				merged = append(merged, other.array[j])
+				j++
+			default:
+				merged = append(merged, c.array[i])
+				i++
+				j++
+			}
+		}
+		merged = append(merged, c.array[i:]...)
+		merged = append(merged, other.array[j:]...)
+		c.array, c.card = merged, len(merged)
+		if c.card > ROARING_ARRAY_MAX {
+			c.toBitmap()
+		}
+		return c
+	}
+	if c.bitmap == nil {
+		c.toBitmap()
+	}
+	if other.bitmap != nil {
+		c.card = 0
+		for i, w := range other.bitmap {
+			c.bitmap[i] |= w
+			c.card += bits.OnesCount64(c.bitmap[i])
+		}
+		return c
+	}
+	for _, low := range other.array {
+		c.add(low)
+	}
+	return c
+}
+
+//returns a new container with the values in both, or nil if there are none
+func (c *container) intersect(other *container) *container {
+	out := &container{}
+	switch {
+	case c.bitmap != nil && other.bitmap != nil:
+		out.bitmap = make([]uint64, 1024)
+		for i := range out.bitmap {
+			out.bitmap[i] = c.bitmap[i] & other.bitmap[i]
+			out.card += bits.OnesCount64(out.bitmap[i])
+		}
+		if out.card <= ROARING_ARRAY_MAX {
+			out.toArray()
+		}
+	case c.bitmap != nil:
+		return other.intersect(c)
+	default:
+		for _, low := range c.array {
+			if other.contains(low) {
+				out.array = append(out.array, low)
+			}
+		}
+		out.card = len(out.array)
+	}
+	if out.card == 0 {
+		return nil
+	}
+	return out
+}
+
+func (c *container) clone() *container {
+	out := &container{card: c.card}
+	if c.bitmap != nil {
+		out.bitmap = append([]uint64(nil), c.bitmap...)
+	} else {
+		out.array = append([]uint16(nil), c.array...)
+	}
+	return out
+}
+
+//---------RoaringSet-------------------------------------------------//
+
+//index of the container for key, and whether it exists
+func (s *RoaringSet) find(key uint16) (int, bool) {
+	i := sort.Search(len(s.keys), func(i int) bool { return s.keys[i] >= key })
+	return i, i < len(s.keys) && s.keys[i] == key
+}
+
+func (s *RoaringSet) insertContainer(i int, key uint16, c *container) {
+	s.keys = append(s.keys, 0)
+	copy(s.keys[i+1:], s.keys[i:])
+	s.keys[i] = key
+	s.containers = append(s.containers, nil)
+	copy(s.containers[i+1:], s.containers[i:])
+	s.containers[i] = c
+}
+
+func (s *RoaringSet) Add(addr [4]byte) {
+	n := bytesToUint32(addr)
+	key, low := uint16(n>>16), uint16(n)
+	i, found := s.find(key)
+	if !found {
+		s.insertContainer(i, key, &container{})
+	}
+	s.containers[i].add(low)
+}
+
+func (s *RoaringSet) Remove(addr [4]byte) {
+	n := bytesToUint32(addr)
+	i, found := s.find(uint16(n >> 16))
+	if !found {
+		return
+	}
+	s.containers[i].remove(uint16(n))
+	if s.containers[i].card == 0 {
+		s.keys = append(s.keys[:i], s.keys[i+1:]...)
+		s.containers = append(s.containers[:i], s.containers[i+1:]...)
+	}
+}
+
+func (s *RoaringSet) Contains(addr [4]byte) bool {
+	n := bytesToUint32(addr)
+	i, found := s.find(uint16(n >> 16))
+	return found && s.containers[i].contains(uint16(n))
+}
+
+func (s *RoaringSet) Size() int {
+	size := 0
+	for _, c := range s.containers {
+		size += c.card
+	}
+	return size
+}
+
+func (s *RoaringSet) Wipe() {
+	s.keys = nil
+	s.containers = nil
+}
+
+// Each calls fn on every address in order, lowest first, until fn returns false.
+func (s *RoaringSet) Each(fn func([4]byte) bool) {
+	for i, c := range s.containers {
+		high := uint32(s.keys[i]) << 16
+		if !c.each(func(low uint16) bool { return fn(uint32ToBytes(high | uint32(low))) }) {
+			return
+		}
+	}
+}
+
+// Union expands s into its union with other.
+func (s *RoaringSet) Union(other *RoaringSet) {
+	for j, key := range other.keys {
+		i, found := s.find(key)
+		if found {
+			s.containers[i] = s.containers[i].union(other.containers[j])
+		} else {
+			s.insertContainer(i, key, other.containers[j].clone())
+		}
+	}
+}
+
+// IntersectWith reduces s to the addresses also in other.
+func (s *RoaringSet) IntersectWith(other *RoaringSet) {
+	*s = *RoaringIntersection(s, other)
+}
+
+// RoaringIntersection returns a new set of the addresses in both s1 and s2.
+func RoaringIntersection(s1 *RoaringSet, s2 *RoaringSet) *RoaringSet {
+	out := NewRoaringSet()
+	i, j := 0, 0
+	for i < len(s1.keys) && j < len(s2.keys) {
+		switch {
+		case s1.keys[i] < s2.keys[j]:
+			i++
+		case s1.keys[i] > s2.keys[j]:
+			j++
+		default:
+			if c := s1.containers[i].intersect(s2.containers[j]); c != nil {
+				out.keys = append(out.keys, s1.keys[i])
+				out.containers = append(out.containers, c)
+			}
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// expands the set into its union with another set. Other RoaringSets are
+// merged container by container; any other listable set is added one by one.
+func (s *RoaringSet) UnionWith(s2 Container[[4]byte]) error {
+	switch other := s2.(type) {
+	case *RoaringSet:
+		s.Union(other)
+		return nil
+	case Iterable[[4]byte]:
+		other.Each(func(addr [4]byte) bool {
+			s.Add(addr)
+			return true
+		})
+		return nil
+	}
+	return ErrNotIterable
+}
+
+//turns a Set into a CSV, in address order
+func (s *RoaringSet) ToCSV() string {
+	return addressesCSV(s.Each)
+}
+
+// WriteCSV streams the addresses in order, one dotted quad per line.
+func (s *RoaringSet) WriteCSV(w io.Writer) error {
+	return writeAddressCSV(w, s.Each)
+}
+
+// ReadCSV adds every address in r.
+func (s *RoaringSet) ReadCSV(r io.Reader) error {
+	return readAddressCSV(r, func(addr [4]byte) error {
+		s.Add(addr)
+		return nil
+	})
+}
+
+// WriteBinary encodes the set compactly: the header and the container count,
+// then for each container its key and either its array as varint deltas or
+// its bitmap words.
+func (s *RoaringSet) WriteBinary(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if err := writeHeader(bw, roaringKind); err != nil {
+		return err
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(s.keys)))
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	for i, c := range s.containers { //one container at a time
+		buf = binary.BigEndian.AppendUint16(buf[:0], s.keys[i])
+		if c.bitmap != nil {
+			buf = append(buf, 1)
+			for _, w := range c.bitmap {
+				buf = binary.LittleEndian.AppendUint64(buf, w)
+			}
+		} else {
+			buf = append(buf, 0)
+			buf = binary.AppendUvarint(buf, uint64(len(c.array)))
+			prev := uint16(0)
+			for _, low := range c.array {
+				buf = binary.AppendUvarint(buf, uint64(low-prev))
+				prev = low
+			}
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadRoaringSet reads a set written by WriteBinary or MarshalBinary.
+func ReadRoaringSet(r io.Reader) (*RoaringSet, error) {
+	s := NewRoaringSet()
+	if err := s.readFrom(bufferedReader(r)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RoaringSet) MarshalBinary() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := s.WriteBinary(buf)
+	return buf.Bytes(), err
+}
+
+func (s *RoaringSet) UnmarshalBinary(data []byte) error {
+	return s.readFrom(bytes.NewReader(data))
+}
+
+func (s *RoaringSet) readFrom(r byteReader) error {
+	if err := readHeader(r, roaringKind); err != nil {
+		return err
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return unexpected(err)
+	}
+	s.Wipe()
+	head := make([]byte, 3)
+	word := make([]byte, 8)
+	for k := uint64(0); k < count; k++ {
+		if _, err := io.ReadFull(r, head); err != nil {
+			return unexpected(err)
+		}
+		key, kind := binary.BigEndian.Uint16(head), head[2]
+		if len(s.keys) > 0 && key <= s.keys[len(s.keys)-1] {
+			return ErrBadEncoding
+		}
+		c := &container{}
+		switch kind {
+		case 1:
+			c.bitmap = make([]uint64, 1024)
+			for i := range c.bitmap {
+				if _, err := io.ReadFull(r, word); err != nil {
+					return unexpected(err)
+				}
+				c.bitmap[i] = binary.LittleEndian.Uint64(word)
+				c.card += bits.OnesCount64(c.bitmap[i])
+			}
+		case 0:
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return unexpected(err)
+			}
+			if length > 1<<16 {
+				return ErrBadEncoding
+			}
+			c.array = make([]uint16, 0, length)
+			prev := uint16(0)
+			for i := uint64(0); i < length; i++ {
+				delta, err := binary.ReadUvarint(r)
+				if err != nil {
+					return unexpected(err)
+				}
+				if (i > 0 && delta == 0) || uint64(prev)+delta > 0xffff {
+					return ErrBadEncoding
+				}
+				prev += uint16(delta)
+				c.array = append(c.array, prev)
+			}
+			c.card = len(c.array)
+		default:
+			return ErrBadEncoding
+		}
+		if c.card > 0 {
+			s.keys = append(s.keys, key)
+			s.containers = append(s.containers, c)
+		}
+	}
+	return nil
+}
+
+// the wire encoding over rpc is the compact one
+func (s *RoaringSet) GobEncode() ([]byte, error) {
+	return s.MarshalBinary()
+}
+
+func (s *RoaringSet) GobDecode(data []byte) error {
+	return s.UnmarshalBinary(data)
+}
@@ -0,0 +1,96 @@
+package set
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"testing"
+)
+
+//checks a roaring set against a plain map of the same addresses
+func sameAddresses(t *testing.T, s *RoaringSet, expected map[uint32]bool) {
+	t.Helper()
+	if s.Size() != len(expected) {
+		t.Fatalf("expected %d addresses, got %d", len(expected), s.Size())
+	}
+	prev, first := uint32(0), true
+	s.Each(func(addr [4]byte) bool {
+		n := bytesToUint32(addr)
+		if !expected[n] {
+			t.Fatalf("unexpected address %v", addr)
+		}
+		if !first && n <= prev {
+			t.Fatalf("addresses out of order: %v after %v", n, prev)
+		}
+		prev, first = n, false
+		return true
+	})
+}
+
+func TestRoaringAgainstMap(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	a, b := NewRoaringSet(), NewRoaringSet()
+	inA, inB := make(map[uint32]bool), make(map[uint32]bool)
+	//a dense /16 that becomes a bitmap, plus sparse addresses in a few others
+	for i := 0; i < 10000; i++ {
+		n := uint32(10<<24|1<<16) | uint32(r.Intn(1<<16))
+		a.Add(uint32ToBytes(n))
+		inA[n] = true
+	}
+	for i := 0; i < 3000; i++ {
+		n := uint32(r.Intn(4))<<16 | uint32(10<<24) | uint32(r.Intn(1<<16))
+		b.Add(uint32ToBytes(n))
+		inB[n] = true
+	}
+	for n := range inA {
+		if n%3 == 0 {
+			a.Remove(uint32ToBytes(n))
+			delete(inA, n)
+		}
+	}
+	sameAddresses(t, a, inA)
+
+	both := RoaringIntersection(a, b)
+	inBoth := make(map[uint32]bool)
+	for n := range inA {
+		if inB[n] {
+			inBoth[n] = true
+		}
+	}
+	sameAddresses(t, both, inBoth)
+
+	a.Union(b)
+	for n := range inB {
+		inA[n] = true
+	}
+	sameAddresses(t, a, inA)
+}
+
+func TestRoaringWireSize(t *testing.T) {
+	s := NewRoaringSet()
+	for i := 0; i < 1000; i++ { //a clustered block, like the interfaces of one network
+		s.Add([4]byte{192, 168, byte(i / 250), byte(i % 250)})
+	}
+	s.Add([4]byte{8, 8, 8, 8})
+
+	var sent Container[[4]byte] = s
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&sent); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 2000 {
+		t.Errorf("1001 clustered addresses took %d bytes over gob", buf.Len())
+	}
+	var received Container[[4]byte]
+	if err := gob.NewDecoder(buf).Decode(&received); err != nil {
+		t.Fatal(err)
+	}
+	back := received.(*RoaringSet)
+	if back.Size() != 1001 || !back.Contains([4]byte{8, 8, 8, 8}) || !back.Contains([4]byte{192, 168, 3, 249}) {
+		t.Errorf("round trip lost addresses, %d left", back.Size())
+	}
+
+	if err := back.UnmarshalBinary([]byte("RRS1\x01\x00")); err == nil {
+		t.Errorf("expected an error for a truncated encoding")
+	}
+}
@@ -1,139 +1,181 @@
 //reference: https://www.davidkaya.com/Sets-in-golang/
 package set
 import (
+	"encoding/gob"
+	"errors"
 	"sync"
-	"strings"
 	"strconv"
 	"fmt"
 )
 
-type Set interface {
-	Add(any)
-	Remove(any)
-	Contains(any) bool
+// Container is what every set in this package offers for items of type T:
+// the map backed Set, and the BloomFilter, IPSet and RoaringSet.
+type Container[T comparable] interface {
+	Add(T)
+	Remove(T)
+	Contains(T) bool
+	Size() int
 	ToCSV() string
 	Wipe()
-	UnionWith(Set)
+	UnionWith(Container[T]) error
 }
 
+// Iterable is a Container that can list its items. Each calls fn on every
+// item until fn returns false.
+type Iterable[T comparable] interface {
+	Container[T]
+	Each(fn func(T) bool)
+}
 
+var ErrNotIterable = errors.New("set: items of this set cannot be listed")
 
+//lets a set be sent over rpc, or saved, as a Container, e.g. a stop set that
+//may be a Set or a BloomFilter of stop keys. The names are part of the wire
+//format and of the leader's saved state. Peers from before Set was generic
+//sent other types and cannot talk to these.
+func init() {
+	gob.RegisterName("*set.StopKeySet", &Set[StopKey]{})
+	gob.RegisterName("*set.BloomFilter", &BloomFilter[StopKey]{})
+	gob.RegisterName("*set.ExpiringStopKeySet", &ExpiringSet[StopKey]{})
+	gob.Register(&IPSet{})
+	gob.Register(&RoaringSet{})
+}
 
-//---------IntSet-------------------------------------------------//
 
-type IntSet struct {
-	Mp map[int]struct{}
-}
 
-func NewIntSet() *IntSet {
-	s := &IntSet{}
-	s.Mp = make(map[int]struct{})
-	return s
-}
+//---------Set-------------------------------------------------//
 
-func (s *IntSet) ToCSV() string {
-	str := &strings.Builder{}
-	for item, _ := range(s.Mp) {
-		str.WriteString(strconv.Itoa(item) + ",")
-	}
-	str.WriteRune('\n')
-	return str.String()
+type Set[T comparable] struct {
+	//using struct{} because an empty struct takes up 0 bytes.
+	//exported so gob can send it; use Each or Items instead.
+	Mp map[T]struct{}
 }
 
-func (s *IntSet) Add(i any) {
-	s.Mp[i.(int)] = struct{}{}
+type StringSet = Set[string]
+type IntSet = Set[int]
+
+func NewSet[T comparable]() *Set[T] {
+	s := &Set[T]{}
+	s.Mp = make(map[T]struct{})
+	return s
 }
 
-func (s *IntSet) Remove(i any) {
-	delete(s.Mp, i.(int))
+func NewStringSet() *StringSet {
+	return NewSet[string]()
 }
 
-func (s *IntSet) Wipe() {
-	m := make(map[int]struct{})
-	s.Mp = m
+func NewIntSet() *IntSet {
+	return NewSet[int]()
 }
 
-func (s1 *IntSet) UnionWith(s2 Set) {
-	for key, _ := range(s2.(*IntSet).Mp) {
-		s1.Mp[key] = struct{}{}
-	}
+func (s *Set[T]) Size() int {
+	return len(s.Mp)
 }
 
-func (s *IntSet) Contains(i any) bool {
-	_, ok := s.Mp[i.(int)]
+func (s *Set[T]) Contains(item T) bool {
+	_, ok := s.Mp[item]
 	return ok
 }
 
-
-
-//---------StringSet-------------------------------------------------//
-
-type StringSet struct {
-	//using struct{} because an eMpty struct takes up 0 bytes
-	Mp map[string]struct{} //TODO: make this private and make an enumerate method
+func (s *Set[T]) Wipe() {
+	m := make(map[T]struct{})
+	s.Mp = m
 }
 
-func NewStringSet() *StringSet {
-	s := &StringSet{}
-	s.Mp = make(map[string]struct{})
-	return s
+func (s *Set[T]) Add(item T) {
+	if s.Mp == nil { //gob leaves the map out of an empty Set
+		s.Mp = make(map[T]struct{})
+	}
+	s.Mp[item] = struct{}{}
 }
 
+func (s *Set[T]) Remove(item T) {
+	delete(s.Mp, item)
+}
 
-func (s *StringSet) Size() int {
-	return len(s.Mp)
+// Each calls fn on every item, in no particular order, until fn returns false.
+func (s *Set[T]) Each(fn func(T) bool) {
+	for key := range s.Mp {
+		if !fn(key) {
+			return
+		}
+	}
 }
 
-/*unlock the Set*/
-func (s *StringSet) Contains(item any) bool {
-	_, ok := s.Mp[item.(string)]
-	return ok
+// Items returns the items in no particular order.
+func (s *Set[T]) Items() []T {
+	items := make([]T, 0, len(s.Mp))
+	for key := range s.Mp {
+		items = append(items, key)
+	}
+	return items
 }
 
-func (s *StringSet) Wipe() {
-	m := make(map[string]struct{})
-	s.Mp = m
+func (s *Set[T]) Clone() *Set[T] {
+	s2 := NewSet[T]()
+	for key := range s.Mp {
+		s2.Mp[key] = struct{}{}
+	}
+	return s2
 }
 
-func (s *StringSet) Add(item any) {
-	s.Mp[item.(string)] = struct{}{}
+//expands the Set into its union with another Set, which must be listable
+func (s1 *Set[T]) UnionWith(s2 Container[T]) error {
+	other, ok := s2.(Iterable[T])
+	if !ok {
+		return ErrNotIterable
+	}
+	other.Each(func(key T) bool {
+		s1.Add(key)
+		return true
+	})
+	return nil
 }
 
-func (s *StringSet) Remove(item any) {
-	delete(s.Mp, item.(string))
+//reduces the Set to its intersection with another Set
+func (s1 *Set[T]) IntersectWith(s2 Container[T]) {
+	for key := range s1.Mp {
+		if !s2.Contains(key) {
+			delete(s1.Mp, key)
+		}
+	}
 }
 
-//expands the Set into its union with another Set
-func (s1 *StringSet) UnionWith(s2 Set) {
-	for key, _ := range(s2.(*StringSet).Mp) {
-		s1.Mp[key] = struct{}{}
+//reduces the Set to the items not in another Set
+func (s1 *Set[T]) DifferenceWith(s2 Container[T]) {
+	for key := range s1.Mp {
+		if s2.Contains(key) {
+			delete(s1.Mp, key)
+		}
 	}
 }
 
-//reduces the Set to its intersection with another Set
-func (s1 *StringSet) IntersectWith(s2 *StringSet) {
-	for key, _ := range(s1.Mp) {
-		if !(s2.Contains(key)) {
-			s1.Remove(key)
+//true if every item of s1 is in s2
+func (s1 *Set[T]) IsSubsetOf(s2 Container[T]) bool {
+	for key := range s1.Mp {
+		if !s2.Contains(key) {
+			return false
 		}
 	}
+	return true
+}
+
+func (s1 *Set[T]) Equal(s2 *Set[T]) bool {
+	return s1.Size() == s2.Size() && s1.IsSubsetOf(s2)
 }
 
 //returns a new Set which is the union of two Sets
-func Union(s1 *StringSet, s2 *StringSet) *StringSet {
-	s3 := NewStringSet()
-	for key, _ := range(s1.Mp) {
-		s3.Mp[key] = struct{}{}
-	}
-	for key, _ := range(s2.Mp) {
+func Union[T comparable](s1 *Set[T], s2 *Set[T]) *Set[T] {
+	s3 := s1.Clone()
+	for key := range s2.Mp {
 		s3.Mp[key] = struct{}{}
 	}
 	return s3
 }
 
-func Intersection(s1 *StringSet, s2 *StringSet) *StringSet {
-	s3 := NewStringSet()
-	for key, _ := range(s1.Mp) {
+func Intersection[T comparable](s1 *Set[T], s2 *Set[T]) *Set[T] {
+	s3 := NewSet[T]()
+	for key := range s1.Mp {
 		if s2.Contains(key) {
 			s3.Mp[key] = struct{}{}
 		}
@@ -141,10 +183,10 @@ func Intersection(s1 *StringSet, s2 *StringSet) *StringSet {
 	return s3
 }
 
-//returns a new Set that is s1 U s2'
-func IntersectionComplement(s1 *StringSet, s2 *StringSet) *StringSet {
-	s3 := NewStringSet()
-	for key, _ := range(s1.Mp) {
+//returns a new Set of the items in s1 but not s2
+func Difference[T comparable](s1 *Set[T], s2 *Set[T]) *Set[T] {
+	s3 := NewSet[T]()
+	for key := range s1.Mp {
 		if !s2.Contains(key) {
 			s3.Mp[key] = struct{}{}
 		}
@@ -152,118 +194,157 @@ func IntersectionComplement(s1 *StringSet, s2 *StringSet) *StringSet {
 	return s3
 }
 
-//turns a Set into a CSV
-func (s *StringSet) ToCSV() string {
-	str := &strings.Builder{}
-	for item, _ := range(s.Mp) {
-		str.WriteString(item + ",")
+//returns a new Set that is s1 ∩ s2', the same as Difference
+func IntersectionComplement[T comparable](s1 *Set[T], s2 *Set[T]) *Set[T] {
+	return Difference(s1, s2)
+}
+
+//returns a new Set of the items in exactly one of s1 and s2
+func SymmetricDifference[T comparable](s1 *Set[T], s2 *Set[T]) *Set[T] {
+	s3 := Difference(s1, s2)
+	for key := range s2.Mp {
+		if !s1.Contains(key) {
+			s3.Mp[key] = struct{}{}
+		}
 	}
-	str.WriteRune('\n')
-	return str.String()
+	return s3
+}
+
+func IsSubset[T comparable](s1 *Set[T], s2 *Set[T]) bool {
+	return s1.IsSubsetOf(s2)
 }
 
 
 //---------SafeSet-------------------------------------------------//
 
-type SafeSet struct {
-	st Set
+// SafeSet wraps any Container with a lock, so goroutines can share it.
+type SafeSet[T comparable] struct {
+	st Container[T]
 	lock sync.Mutex
 }
 
-func NewSafeStringSet() *SafeSet {
-	strset := NewStringSet()
-	ss := &SafeSet{st:strset}
-	return ss
+func NewSafeSet[T comparable](st Container[T]) *SafeSet[T] {
+	return &SafeSet[T]{st:st}
+}
+
+func NewSafeStringSet() *SafeSet[string] {
+	return NewSafeSet[string](NewStringSet())
+}
+
+func NewSafeIntSet() *SafeSet[int] {
+	return NewSafeSet[int](NewIntSet())
 }
 
-func (ss *SafeSet) ChangeSetTo(s Set) {
+func (ss *SafeSet[T]) ChangeSetTo(s Container[T]) {
 	ss.lock.Lock()
 	defer ss.lock.Unlock()
 	ss.st = s
 }
 
-func NewSafeIntSet() *SafeSet {
-	intSet := NewIntSet()
-	ss := &SafeSet{st:intSet}
-	return ss
-}
-
-func (s *SafeSet) Add(item any) {
+func (s *SafeSet[T]) Add(item T) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.st.Add(item)
 }
 
-func (s *SafeSet) Remove(item any) {
+func (s *SafeSet[T]) Remove(item T) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.st.Remove(item)
 }
 
-func (s *SafeSet) Contains(item any) bool {
+func (s *SafeSet[T]) Contains(item T) bool {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	t := s.st.Contains(item)
 	return t
 }
 
-func (s1 *SafeSet) UnionWith(s2 Set){
+func (s *SafeSet[T]) Size() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.st.Size()
+}
+
+func (s1 *SafeSet[T]) UnionWith(s2 Container[T]) error {
 	s1.lock.Lock()
 	defer s1.lock.Unlock()
-	s1.st.UnionWith(s2)
+	return s1.st.UnionWith(s2)
 }
 
-func (s *SafeSet) ToCSV() string {
+//calls fn on every item while holding the lock, so fn must not use the SafeSet
+func (s *SafeSet[T]) Each(fn func(T) bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	it, ok := s.st.(Iterable[T])
+	if !ok {
+		return ErrNotIterable
+	}
+	it.Each(fn)
+	return nil
+}
+
+func (s *SafeSet[T]) ToCSV() string {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	str := s.st.ToCSV()
 	return str
 }
 
-
-func (s *SafeSet) Set() Set {
+//the wrapped set. it is not locked once returned.
+func (s *SafeSet[T]) Set() Container[T] {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	st := s.st
 	return st
 }
 
-//replaces the set with a new eMpty set
-func (s *SafeSet) Wipe() {
+//replaces the set with a new empty set
+func (s *SafeSet[T]) Wipe() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.st.Wipe()
 }
 
+//drops the expired entries of a wrapped ExpiringSet, returning how many there were
+func (s *SafeSet[T]) Compact() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if expiring, ok := s.st.(*ExpiringSet[T]); ok {
+		return expiring.Compact()
+	}
+	return 0
+}
+
 func TestNoRoutine() {
 	strs := NewStringSet()
 	strs.Add("ab")
 	strs.Add("cd")
 	strs.Remove("cd")
 	fmt.Println(strs.ToCSV())
-	fmt.Println("-- should be: ab\n")
+	fmt.Print("-- should be: ab\n\n")
 	ints := NewIntSet()
 	ints.Add(1)
 	ints.Add(2)
 	ints.Remove(1)
 	fmt.Println(ints.ToCSV())
-	fmt.Println("-- should be:", 2, "\n")
+	fmt.Print("-- should be: 2\n\n")
 	safeStrs := NewSafeStringSet()
 	safeInts := NewSafeIntSet()
 	safeStrs.Add("lame")
 	safeStrs.Add("cool")
 	safeStrs.Remove("lame")
 	fmt.Println(safeStrs.ToCSV())
-	fmt.Println("-- should be: cool\n")
+	fmt.Print("-- should be: cool\n\n")
 	safeInts.Add(11)
 	safeInts.Add(22)
 	safeInts.Remove(22)
 	fmt.Println(safeInts.ToCSV())
-	fmt.Println("-- should be: 11\n")
+	fmt.Print("-- should be: 11\n\n")
 
 	safeStrs.UnionWith(strs)
 	fmt.Println(safeStrs.ToCSV())
-	fmt.Println("-- should be: ab, cool or cool, ab")
+	fmt.Println("-- should be: ab,cool")
 }
 
 func TestRoutines() {
@@ -0,0 +1,87 @@
+package set
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSetAlgebra(t *testing.T) {
+	a, b := NewSet[int](), NewSet[int]()
+	for i := 0; i < 6; i++ {
+		a.Add(i)
+	}
+	for i := 4; i < 10; i++ {
+		b.Add(i)
+	}
+	check := func(name string, s *Set[int], expected ...int) {
+		t.Helper()
+		items := s.Items()
+		sort.Ints(items)
+		if len(items) != len(expected) {
+			t.Errorf("%v: expected %v, got %v", name, expected, items)
+			return
+		}
+		for i := range items {
+			if items[i] != expected[i] {
+				t.Errorf("%v: expected %v, got %v", name, expected, items)
+				return
+			}
+		}
+	}
+	check("union", Union(a, b), 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	check("intersection", Intersection(a, b), 4, 5)
+	check("difference", Difference(a, b), 0, 1, 2, 3)
+	check("symmetric difference", SymmetricDifference(a, b), 0, 1, 2, 3, 6, 7, 8, 9)
+	if IsSubset(a, b) || !IsSubset(Intersection(a, b), b) {
+		t.Errorf("wrong subset results")
+	}
+
+	c := a.Clone()
+	c.IntersectWith(b)
+	if !c.Equal(Intersection(a, b)) || a.Size() != 6 {
+		t.Errorf("clone is not independent of the original")
+	}
+	//a Bloom filter cannot list its items, so it cannot be added to a Set
+	if err := NewSet[StopKey]().UnionWith(NewStopSetBloom()); err != ErrNotIterable {
+		t.Errorf("expected ErrNotIterable, got %v", err)
+	}
+}
+
+func TestSafeSetConcurrent(t *testing.T) {
+	ss := NewSafeSet[[4]byte](NewRoaringSet())
+	wg := &sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				ss.Add([4]byte{10, byte(g), byte(i >> 8), byte(i)})
+			}
+			extra := NewSet[[4]byte]()
+			extra.Add([4]byte{192, 0, 2, byte(g)})
+			if err := ss.UnionWith(extra); err != nil {
+				t.Error(err)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if ss.Size() != 8*500+8 {
+		t.Errorf("expected %d addresses, got %d", 8*500+8, ss.Size())
+	}
+	count := 0
+	if err := ss.Each(func([4]byte) bool { count++; return true }); err != nil || count != ss.Size() {
+		t.Errorf("Each saw %d addresses, err %v", count, err)
+	}
+
+	strs := NewSafeStringSet()
+	strs.Add(strconv.Itoa(1))
+	strs.ChangeSetTo(NewBloomFilter[string](100, 0, 0.01))
+	if strs.Contains("1") {
+		t.Errorf("ChangeSetTo kept the old set")
+	}
+	if err := strs.Each(func(string) bool { return true }); err != ErrNotIterable {
+		t.Errorf("expected ErrNotIterable from a Bloom filter, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+//SPECIAL DATA STRUCTURE: the sharded set
+//a SafeSet has one lock, so every goroutine of a monitor queues on it even
+//though nearly all of them only want to look something up. A ShardedSet
+//hashes each item to one of many shards, each with its own read/write lock,
+//so lookups never wait on each other and writers only wait on writers to
+//the same shard.
+
+package set
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+const DEFAULT_SHARDS = 64
+
+type shard[T comparable] struct {
+	lock  sync.RWMutex
+	items map[T]struct{}
+	//items also belongs to a snapshot, so it is copied before the next change
+	shared bool
+}
+
+// must be called with the shard's write lock held
+func (sh *shard[T]) writable() {
+	if !sh.shared {
+		return
+	}
+	items := make(map[T]struct{}, len(sh.items))
+	for key := range sh.items {
+		items[key] = struct{}{}
+	}
+	sh.items = items
+	sh.shared = false
+}
+
+// ShardedSet is a concurrent set, safe for use by many goroutines, for
+// items that are read far more often than they are written.
+type ShardedSet[T comparable] struct {
+	shards []*shard[T]
+	mask   uint64
+	hash   func(T) uint64
+}
+
+// NewShardedSet makes a set with at least the given number of shards,
+// rounded up to a power of two, spreading items between them with hash.
+func NewShardedSet[T comparable](shards int, hash func(T) uint64) *ShardedSet[T] {
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	s := &ShardedSet[T]{shards: make([]*shard[T], n), mask: uint64(n - 1), hash: hash}
+	for i := range s.shards {
+		s.shards[i] = &shard[T]{items: make(map[T]struct{})}
+	}
+	return s
+}
+
+func NewShardedStringSet() *ShardedSet[string] {
+	seed := maphash.MakeSeed()
+	return NewShardedSet(DEFAULT_SHARDS, func(item string) uint64 {
+		return maphash.String(seed, item)
+	})
+}
+
+func NewShardedIntSet() *ShardedSet[int] {
+	return NewShardedSet(DEFAULT_SHARDS, func(item int) uint64 {
+		return mix(uint64(item))
+	})
+}
+
+func NewShardedAddressSet() *ShardedSet[[4]byte] {
+	return NewShardedSet(DEFAULT_SHARDS, func(addr [4]byte) uint64 {
+		return mix(uint64(bytesToUint32(addr)))
+	})
+}
+
+func NewShardedStopKeySet() *ShardedSet[StopKey] {
+	return NewShardedSet(DEFAULT_SHARDS, func(key StopKey) uint64 {
+		return mix(uint64(key))
+	})
+}
+
+// the murmur3 finalizer, so neighbouring ints and addresses land in different shards
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (s *ShardedSet[T]) shardOf(item T) *shard[T] {
+	return s.shards[s.hash(item)&s.mask]
+}
+
+func (s *ShardedSet[T]) Add(item T) {
+	sh := s.shardOf(item)
+	sh.lock.RLock()
+	_, ok := sh.items[item]
+	sh.lock.RUnlock()
+	if ok { //already there, which is the common case for a stop set
+		return
+	}
+	sh.lock.Lock()
+	sh.writable()
+	sh.items[item] = struct{}{}
+	sh.lock.Unlock()
+}
+
+func (s *ShardedSet[T]) Remove(item T) {
+	sh := s.shardOf(item)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	if _, ok := sh.items[item]; ok {
+		sh.writable()
+		delete(sh.items, item)
+	}
+}
+
+func (s *ShardedSet[T]) Contains(item T) bool {
+	sh := s.shardOf(item)
+	sh.lock.RLock()
+	_, ok := sh.items[item]
+	sh.lock.RUnlock()
+	return ok
+}
+
+func (s *ShardedSet[T]) Size() int {
+	total := 0
+	for _, sh := range s.shards {
+		sh.lock.RLock()
+		total += len(sh.items)
+		sh.lock.RUnlock()
+	}
+	return total
+}
+
+func (s *ShardedSet[T]) Wipe() {
+	for _, sh := range s.shards {
+		sh.lock.Lock()
+		sh.items = make(map[T]struct{})
+		sh.shared = false
+		sh.lock.Unlock()
+	}
+}
+
+//expands the set into its union with another set, which must be listable
+func (s *ShardedSet[T]) UnionWith(s2 Container[T]) error {
+	other, ok := s2.(Iterable[T])
+	if !ok {
+		return ErrNotIterable
+	}
+	other.Each(func(key T) bool {
+		s.Add(key)
+		return true
+	})
+	return nil
+}
+
+// hands out the current map of every shard, marking each shared so the next
+// write to it makes a copy. Each shard is locked just long enough to set the
+// mark, so writers are never held up by the caller reading the maps.
+func (s *ShardedSet[T]) freeze() []map[T]struct{} {
+	frozen := make([]map[T]struct{}, len(s.shards))
+	for i, sh := range s.shards {
+		sh.lock.Lock()
+		sh.shared = true
+		frozen[i] = sh.items
+		sh.lock.Unlock()
+	}
+	return frozen
+}
+
+// Snapshot copies the set into a plain Set without blocking writers. Each
+// shard is captured as it was at some moment during the call, so an item
+// added meanwhile may or may not be included.
+func (s *ShardedSet[T]) Snapshot() *Set[T] {
+	frozen := s.freeze()
+	total := 0
+	for _, items := range frozen {
+		total += len(items)
+	}
+	snap := &Set[T]{Mp: make(map[T]struct{}, total)}
+	for _, items := range frozen {
+		for key := range items {
+			snap.Mp[key] = struct{}{}
+		}
+	}
+	return snap
+}
+
+// Each calls fn on every item of a snapshot of the set, until fn returns
+// false. No lock is held while fn runs, so fn may use the set.
+func (s *ShardedSet[T]) Each(fn func(T) bool) {
+	for _, items := range s.freeze() {
+		for key := range items {
+			if !fn(key) {
+				return
+			}
+		}
+	}
+}
+
+//turns the set into a CSV, in sorted order
+func (s *ShardedSet[T]) ToCSV() string {
+	return s.Snapshot().ToCSV()
+}
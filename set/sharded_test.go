@@ -0,0 +1,88 @@
+package set
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestShardedSetConcurrent(t *testing.T) {
+	s := NewShardedStringSet()
+	wg := &sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(g) + "-" + strconv.Itoa(i)
+				s.Add(key)
+				if !s.Contains(key) {
+					t.Errorf("lost %v", key)
+				}
+				if i%2 == 0 {
+					s.Remove(key)
+				}
+			}
+		}(g)
+	}
+	//snapshots taken while the writers run must each be a consistent Set
+	for i := 0; i < 20; i++ {
+		snap := s.Snapshot()
+		snap.Add("only in the snapshot")
+	}
+	wg.Wait()
+	if s.Size() != 8*500 || s.Contains("only in the snapshot") {
+		t.Errorf("expected %d items, got %d", 8*500, s.Size())
+	}
+
+	snap := s.Snapshot()
+	s.Add("after")
+	s.Remove("0-1")
+	if snap.Contains("after") || !snap.Contains("0-1") || snap.Size() != 8*500 {
+		t.Errorf("snapshot changed along with the set")
+	}
+	seen := 0
+	s.Each(func(key string) bool {
+		s.Contains(key) //Each holds no lock, so this must not deadlock
+		seen++
+		return true
+	})
+	if seen != s.Size() {
+		t.Errorf("Each saw %d of %d items", seen, s.Size())
+	}
+}
+
+// the monitor's pattern on its global stop set: every hop of every trace
+// looks up a (hop, destination) pair, and a few are new and get added.
+func benchmarkStopSet(b *testing.B, contains func(string) bool, add func(string)) {
+	keys := make([]string, 4096)
+	for i := range keys {
+		keys[i] = "10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256) + "-192.0.2.1"
+		add(keys[i])
+	}
+	var next int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id := atomic.AddInt64(&next, 1) << 32
+		i := 0
+		for pb.Next() {
+			i++
+			if i%16 == 0 {
+				add(strconv.FormatInt(id+int64(i), 10))
+				continue
+			}
+			contains(keys[i%len(keys)])
+		}
+	})
+}
+
+func BenchmarkSafeSetStopSet(b *testing.B) {
+	s := NewSafeStringSet()
+	benchmarkStopSet(b, s.Contains, s.Add)
+}
+
+func BenchmarkShardedSetStopSet(b *testing.B) {
+	s := NewShardedStringSet()
+	benchmarkStopSet(b, s.Contains, s.Add)
+}
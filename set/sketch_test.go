@@ -0,0 +1,103 @@
+package set
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"sync"
+	"testing"
+)
+
+func within(t *testing.T, what string, got uint64, want int, tolerance float64) {
+	t.Helper()
+	if math.Abs(float64(got)-float64(want)) > tolerance*float64(want)+1 {
+		t.Errorf("%s: estimated %d, expected about %d", what, got, want)
+	}
+}
+
+func TestHyperLogLogCounts(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 200000} {
+		h := NewHyperLogLog[[4]byte](HLL_PRECISION)
+		for i := 0; i < n; i++ {
+			h.Add(uint32ToBytes(uint32(i)))
+			h.Add(uint32ToBytes(uint32(i))) //repeats are not counted
+		}
+		within(t, "distinct addresses", h.Count(), n, 0.03)
+	}
+}
+
+func TestHyperLogLogMergeAndOverlap(t *testing.T) {
+	//two monitors finding 60000 interfaces each, 20000 of them the same
+	a := NewHyperLogLog[[4]byte](HLL_PRECISION)
+	b := NewHyperLogLog[[4]byte](HLL_PRECISION)
+	for i := 0; i < 60000; i++ {
+		a.Add(uint32ToBytes(uint32(i)))
+		b.Add(uint32ToBytes(uint32(i + 40000)))
+	}
+	both, err := Overlap(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	within(t, "overlap", both, 20000, 0.15)
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	within(t, "union", a.Count(), 100000, 0.03)
+	if err := a.Merge(NewHyperLogLog[[4]byte](10)); err != ErrSketchMismatch {
+		t.Errorf("expected a mismatch error, got %v", err)
+	}
+}
+
+func TestCountMinCounts(t *testing.T) {
+	c := NewCountMin[[4]byte](COUNTMIN_WIDTH, COUNTMIN_DEPTH)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				c.Add(uint32ToBytes(uint32(i)), 1)
+			}
+			c.Add([4]byte{192, 0, 2, 1}, 1000)
+		}()
+	}
+	wg.Wait()
+	if got := c.Count([4]byte{192, 0, 2, 1}); got < 4000 || got > 4000+c.Total/500 {
+		t.Errorf("expected about 4000 sightings, got %d", got)
+	}
+	if c.Count([4]byte{198, 51, 100, 1}) > c.Total/500 {
+		t.Errorf("an address never added was counted too high")
+	}
+	other := NewCountMin[[4]byte](COUNTMIN_WIDTH, COUNTMIN_DEPTH)
+	other.Add([4]byte{192, 0, 2, 1}, 5)
+	if err := c.Merge(other); err != nil || c.Count([4]byte{192, 0, 2, 1}) < 4005 {
+		t.Errorf("merge did not add the counts: %v", err)
+	}
+}
+
+func TestSketchesOverGob(t *testing.T) {
+	h := NewHyperLogLog[[4]byte](HLL_PRECISION)
+	c := NewCountMin[[4]byte](COUNTMIN_WIDTH, COUNTMIN_DEPTH)
+	for i := 0; i < 1000; i++ {
+		h.Add(uint32ToBytes(uint32(i)))
+		c.Add(uint32ToBytes(uint32(i%10)), 1)
+	}
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(h); err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(buf).Encode(c); err != nil {
+		t.Fatal(err)
+	}
+	h2 := &HyperLogLog[[4]byte]{}
+	c2 := &CountMin[[4]byte]{}
+	if err := gob.NewDecoder(buf).Decode(h2); err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewDecoder(buf).Decode(c2); err != nil {
+		t.Fatal(err)
+	}
+	if h2.Count() != h.Count() || c2.Count([4]byte{0, 0, 0, 3}) != c.Count([4]byte{0, 0, 0, 3}) {
+		t.Errorf("sketches changed over gob")
+	}
+}
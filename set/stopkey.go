@@ -0,0 +1,138 @@
+//STOP SET KEYS
+//Doubletree's stop sets hold (interface, destination) pairs. A StopKey packs
+//both IPv4 addresses into one uint64, interface in the upper half, so a key
+//costs no allocation to build, hash or compare.
+//Keys can be made at a coarser granularity by masking the destination to a
+//prefix. At /24, one entry stops probes towards every target in the same /24,
+//as suggested by the Doubletree follow-up work, since targets in one prefix
+//nearly always share the routes leading up to it.
+
+package set
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+const EXACT_PREFIX = 32 //a StopKey granularity of one destination
+
+// StopKey is an (interface, destination) pair of IPv4 addresses.
+type StopKey uint64
+
+// NewStopKey packs an interface and the destination it was seen towards.
+func NewStopKey(iface [4]byte, dest [4]byte) StopKey {
+	return StopKey(uint64(bytesToUint32(iface))<<32 | uint64(bytesToUint32(dest)))
+}
+
+// NewPrefixStopKey keys the interface against the prefixLen bits of dest, so
+// every destination in that prefix gets the same key.
+func NewPrefixStopKey(iface [4]byte, dest [4]byte, prefixLen int) StopKey {
+	return NewStopKey(iface, dest).WithPrefix(prefixLen)
+}
+
+// WithPrefix zeroes the destination's bits past prefixLen.
+func (k StopKey) WithPrefix(prefixLen int) StopKey {
+	if prefixLen >= 32 {
+		return k
+	}
+	if prefixLen < 0 {
+		prefixLen = 0
+	}
+	return k &^ StopKey(uint32(0xffffffff)>>prefixLen)
+}
+
+func (k StopKey) Interface() [4]byte {
+	return uint32ToBytes(uint32(k >> 32))
+}
+
+func (k StopKey) Destination() [4]byte {
+	return uint32ToBytes(uint32(k))
+}
+
+// String gives "interface-destination", the form stop set entries had as strings.
+func (k StopKey) String() string {
+	return addressString(k.Interface()) + "-" + addressString(k.Destination())
+}
+
+var errStopKey = errors.New("set: a stop key is two addresses joined by -")
+
+// ParseStopKey reads the output of String.
+func ParseStopKey(s string) (StopKey, error) {
+	iface, dest, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, errStopKey
+	}
+	a, okA := ParseAddress(iface)
+	b, okB := ParseAddress(dest)
+	if !okA || !okB {
+		return 0, errStopKey
+	}
+	return NewStopKey(a, b), nil
+}
+
+// StopKey6 is an (interface, destination) pair of IPv6 addresses. It is too
+// big to pack, but is still comparable and so can be kept in any Set.
+type StopKey6 struct {
+	Interface   [16]byte
+	Destination [16]byte
+}
+
+func NewStopKey6(iface [16]byte, dest [16]byte) StopKey6 {
+	return StopKey6{Interface: iface, Destination: dest}
+}
+
+// WithPrefix zeroes the destination's bits past prefixLen.
+func (k StopKey6) WithPrefix(prefixLen int) StopKey6 {
+	if prefixLen >= 128 {
+		return k
+	}
+	if prefixLen < 0 {
+		prefixLen = 0
+	}
+	mask := net.CIDRMask(prefixLen, 128)
+	for i := range k.Destination {
+		k.Destination[i] &= mask[i]
+	}
+	return k
+}
+
+//the destination is last, so a ':' inside an address does not matter
+func (k StopKey6) String() string {
+	return net.IP(k.Interface[:]).String() + "-" + net.IP(k.Destination[:]).String()
+}
+
+// ParseStopKey6 reads the output of String.
+func ParseStopKey6(s string) (StopKey6, error) {
+	var k StopKey6
+	iface, dest, ok := strings.Cut(s, "-")
+	if !ok {
+		return k, errStopKey
+	}
+	a, b := net.ParseIP(iface).To16(), net.ParseIP(dest).To16()
+	if a == nil || b == nil {
+		return k, errStopKey
+	}
+	copy(k.Interface[:], a)
+	copy(k.Destination[:], b)
+	return k, nil
+}
+
+// the bytes of an item that the Bloom filter hashes
+func itemBytes[T comparable](item T, buf []byte) []byte {
+	switch it := any(item).(type) {
+	case string:
+		return append(buf, it...)
+	case StopKey:
+		return binary.BigEndian.AppendUint64(buf, uint64(it))
+	case StopKey6:
+		buf = append(buf, it.Interface[:]...)
+		return append(buf, it.Destination[:]...)
+	case [4]byte:
+		return append(buf, it[:]...)
+	case int:
+		return binary.BigEndian.AppendUint64(buf, uint64(it))
+	}
+	return append(buf, formatItem(item)...)
+}
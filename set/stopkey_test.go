@@ -0,0 +1,33 @@
+package set
+
+import "testing"
+
+func TestStopKeys(t *testing.T) {
+	k := NewStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 77})
+	if k.Interface() != [4]byte{10, 0, 0, 1} || k.Destination() != [4]byte{192, 0, 2, 77} {
+		t.Errorf("unpacked %v and %v", k.Interface(), k.Destination())
+	}
+	if back, err := ParseStopKey(k.String()); err != nil || back != k || k.String() != "10.0.0.1-192.0.2.77" {
+		t.Errorf("parsing %q gave %v, %v", k.String(), back, err)
+	}
+
+	//every destination in a /24 shares one key, and the interface is untouched
+	other := NewPrefixStopKey([4]byte{10, 0, 0, 1}, [4]byte{192, 0, 2, 200}, 24)
+	if k.WithPrefix(24) != other || other.Destination() != [4]byte{192, 0, 2, 0} {
+		t.Errorf("expected one key for the /24, got %v and %v", k.WithPrefix(24), other)
+	}
+	if k.WithPrefix(EXACT_PREFIX) != k || k.WithPrefix(0).Destination() != [4]byte{} {
+		t.Errorf("wrong keys at /32 or /0")
+	}
+	if _, err := ParseStopKey("10.0.0.1"); err == nil {
+		t.Errorf("expected an error for a key without a destination")
+	}
+
+	k6, err := ParseStopKey6("2001:db8::1-2001:db8:1::abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k6.WithPrefix(48).String() != "2001:db8::1-2001:db8:1::" {
+		t.Errorf("unexpected /48 key %v", k6.WithPrefix(48))
+	}
+}
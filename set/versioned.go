@@ -0,0 +1,131 @@
+//VERSIONED STOP SETS
+//Doubletree expects a stop set transfer to be a few kilobytes. A stop set
+//mostly grows, so once a monitor holds some version of it, the monitor only
+//needs what was added since. A VersionedSet bumps its version each time
+//changes are applied, and keeps the last few change sets so a holder of a
+//recent version can catch up with their union instead of the whole set.
+//When entries expire, everyone starts over from the whole set.
+
+package set
+
+const DEFAULT_HISTORY = 16 //change sets kept by a VersionedSet
+
+// VersionedSet is a set and the changes that made its recent versions.
+// It is not safe for concurrent use.
+type VersionedSet[T comparable] struct {
+	all     Container[T]
+	version int
+	//history[i] is what version version-len(history)+i+1 added
+	history []*Set[T]
+	keep    int
+}
+
+// NewVersionedSet starts at version 1 with everything in all, keeping the
+// changes of up to keep versions. The versions below 1 mean "holds nothing".
+func NewVersionedSet[T comparable](all Container[T], keep int) *VersionedSet[T] {
+	return &VersionedSet[T]{all: all, version: 1, keep: keep}
+}
+
+// RestoreVersionedSet picks up a VersionedSet saved as its whole set and
+// version. The history is gone, so holders of older versions get the whole set.
+func RestoreVersionedSet[T comparable](all Container[T], version int, keep int) *VersionedSet[T] {
+	if version < 1 {
+		version = 1
+	}
+	return &VersionedSet[T]{all: all, version: version, keep: keep}
+}
+
+func (v *VersionedSet[T]) Version() int {
+	return v.version
+}
+
+// the whole set, at the current version
+func (v *VersionedSet[T]) Set() Container[T] {
+	return v.all
+}
+
+// Apply adds the items of changes, and records the ones that are new as the
+// next version. It returns the version the set is now at, which is
+// unchanged if nothing was new. Items already in the set are added again
+// anyway, which confirms them if the set is an ExpiringSet.
+func (v *VersionedSet[T]) Apply(changes Container[T]) (int, error) {
+	other, ok := changes.(Iterable[T])
+	if !ok {
+		return v.version, ErrNotIterable
+	}
+	added := NewSet[T]()
+	other.Each(func(item T) bool {
+		if !v.all.Contains(item) {
+			added.Add(item)
+		}
+		return true
+	})
+	if err := v.all.UnionWith(other); err != nil {
+		return v.version, err
+	}
+	if added.Size() == 0 {
+		return v.version, nil
+	}
+	v.version++
+	v.history = append(v.history, added)
+	if len(v.history) > v.keep {
+		v.history = v.history[len(v.history)-v.keep:]
+	}
+	return v.version, nil
+}
+
+// Compact drops the expired entries of an ExpiringSet, and returns how many
+// there were. Holders cannot learn of removals from a change set, so if any
+// were dropped the history is cleared and the next Since of every holder
+// returns the whole set.
+func (v *VersionedSet[T]) Compact() int {
+	expiring, ok := v.all.(*ExpiringSet[T])
+	if !ok {
+		return 0
+	}
+	dropped := expiring.Compact()
+	if dropped > 0 {
+		v.version++
+		v.history = nil
+	}
+	return dropped
+}
+
+//a copy of c that later changes to c do not reach
+func copyOf[T comparable](c Container[T]) Container[T] {
+	switch s := c.(type) {
+	case *Set[T]:
+		return s.Clone()
+	case *ExpiringSet[T]:
+		return s.Clone()
+	case *BloomFilter[T]:
+		return s.Clone()
+	case Iterable[T]:
+		s2 := NewSet[T]()
+		s.Each(func(item T) bool {
+			s2.Add(item)
+			return true
+		})
+		return s2
+	}
+	return c
+}
+
+// Since returns what a holder of version held is missing. If the changes
+// since then are still kept, they are returned with full false. Otherwise,
+// including for a held version of 0 or one from the future, the whole set
+// is returned with full true. Either way the result is a copy, which can be
+// read, or encoded, after the set changes again.
+func (v *VersionedSet[T]) Since(held int) (changes Container[T], full bool) {
+	oldest := v.version - len(v.history) //the earliest version we can catch up from
+	if held < 1 || held < oldest || held > v.version {
+		return copyOf(v.all), true
+	}
+	missing := NewSet[T]()
+	for _, added := range v.history[held-oldest:] {
+		for item := range added.Mp {
+			missing.Add(item)
+		}
+	}
+	return missing, false
+}
@@ -0,0 +1,70 @@
+package set
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func keysFrom(n int, offset int) *Set[StopKey] {
+	s := NewSet[StopKey]()
+	for i := 0; i < n; i++ {
+		s.Add(NewStopKey(uint32ToBytes(uint32(offset+i)), [4]byte{192, 0, 2, 1}))
+	}
+	return s
+}
+
+func TestVersionedCatchUp(t *testing.T) {
+	v := NewVersionedSet[StopKey](NewSet[StopKey](), 2)
+	if version, _ := v.Apply(keysFrom(3000, 0)); version != 2 {
+		t.Errorf("expected version 2, got %d", version)
+	}
+	if version, _ := v.Apply(keysFrom(10, 0)); version != 2 {
+		t.Errorf("nothing new should not make a version, got %d", version)
+	}
+	v.Apply(keysFrom(20, 3000)) //version 3
+	v.Apply(keysFrom(30, 3020)) //version 4
+
+	changes, full := v.Since(2)
+	if full || changes.Size() != 50 {
+		t.Errorf("expected the 50 changes since version 2, got %d (full %v)", changes.Size(), full)
+	}
+	if changes, full := v.Since(4); full || changes.Size() != 0 {
+		t.Errorf("expected nothing new at the current version")
+	}
+	for _, held := range []int{0, 1, 5} { //never held, too old, and from the future
+		if changes, full := v.Since(held); !full || changes.Size() != 3050 {
+			t.Errorf("holding version %d: expected the whole set, got %d", held, changes.Size())
+		}
+	}
+	before, _ := v.Since(0)
+	v.Apply(keysFrom(5, 4000))
+	if before.Size() != 3050 {
+		t.Errorf("the whole set handed out changed with the set, to %d", before.Size())
+	}
+
+	//the catch up is a small fraction of the whole set on the wire
+	size := func(s Container[StopKey]) int {
+		buf := &bytes.Buffer{}
+		if err := gob.NewEncoder(buf).Encode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Len()
+	}
+	whole, _ := v.Since(0)
+
+	//an empty set arrives without its map, and must still take changes
+	var empty Container[StopKey] = NewSet[StopKey]()
+	buf := &bytes.Buffer{}
+	gob.NewEncoder(buf).Encode(&empty)
+	var received Container[StopKey]
+	if err := gob.NewDecoder(buf).Decode(&received); err != nil {
+		t.Fatal(err)
+	}
+	if err := received.UnionWith(changes); err != nil || received.Size() != 50 {
+		t.Errorf("could not add to a received empty set: %v", err)
+	}
+	if delta := size(changes); delta > 1024 || delta*20 > size(whole) {
+		t.Errorf("catching up took %d bytes against %d for the whole set", delta, size(whole))
+	}
+}
@@ -10,10 +10,11 @@ import (
 )
 
 type ResultArgs struct {
-	NewGSS *set.StringSet
-	News *set.StringSet
+	NewGSS set.Container[set.StopKey]
+	News set.Container[[4]byte]
 	Id string
 	Index int
+	Session string
 }
 
 type ResultReply struct {
@@ -22,10 +23,23 @@ type ResultReply struct {
 
 type IpArgs struct {
 	ProbeId string
+	Session string
 }
 
+type RegisterArgs struct {
+	EnrollToken string
+	Name string
+}
+
+type RegisterReply struct {
+	Id string
+	Session string
+}
+
+var session string //empty unless a token to enroll with is given
+
 type IpReply struct {
-	Ips [][]byte
+	Ips [][4]byte
 	Index int
 }
 
@@ -57,6 +71,7 @@ func dialLeader(address string) (*rpc.Client, error) {
 func getIPRange(leader *rpc.Client, id string) int {
 	arguments := IpArgs {
 		ProbeId:id,
+		Session:session,
 	}
 	reply := IpReply{}
 	err := leader.Call("Leader.GetIPs", arguments, &reply)
@@ -70,14 +85,14 @@ func getIPRange(leader *rpc.Client, id string) int {
 }
 
 func sendIPRange(leader *rpc.Client, index int, id string) {
-	newNodes := set.NewStringSet()
-	newGSS := set.NewSafeStringSet()
-	newNodes.Add("node1_" + id)
-	newNodes.Add("node2_" + id)
-	newGSS.Add("123.22.4.200");
-	newGSS.Add("1.220.43.10");
+	newNodes := set.NewRoaringSet()
+	newGSS := set.NewSafeSet[set.StopKey](set.NewSet[set.StopKey]())
+	newNodes.Add([4]byte{192, 0, 2, 1})
+	newNodes.Add([4]byte{192, 0, 2, byte(len(id))})
+	newGSS.Add(set.NewStopKey([4]byte{123, 22, 4, 200}, [4]byte{1, 220, 43, 10}));
+	newGSS.Add(set.NewStopKey([4]byte{1, 220, 43, 10}, [4]byte{1, 220, 43, 10}));
 	fmt.Println(newGSS.ToCSV())
-	arguments := ResultArgs{NewGSS:newGSS.Set().(*set.StringSet), News:newNodes, Id:id, Index:index}
+	arguments := ResultArgs{NewGSS:newGSS.Set(), News:newNodes, Id:id, Index:index, Session:session}
 	reply := ResultReply{}
 	err := leader.Call("Leader.TransferResults", arguments, &reply)
 	if err != nil {
@@ -88,13 +103,21 @@ func sendIPRange(leader *rpc.Client, index int, id string) {
 	}
 }
 
-func testAll(id string) {
+func testAll(id string, token string) {
 	address := "localhost:4000"
 	leader, err := dialLeader(address)
 	if err != nil {
 		log.Fatal(err)
 	}
 	fmt.Println("connected to:", address)
+	if token != "" {
+		reply := RegisterReply{}
+		if err := leader.Call("Leader.Register", RegisterArgs{EnrollToken:token, Name:id}, &reply); err != nil {
+			log.Fatal(err)
+		}
+		session = reply.Session
+		fmt.Println("enrolled as", reply.Id)
+	}
 
 	index := getIPRange(leader, id)
 	time.Sleep(10 * time.Second) //turn this on to see if range is freed successfully
@@ -103,10 +126,14 @@ func testAll(id string) {
 
 func main() {
 	if len(os.Args) <= 1 {
-		fmt.Println("usage: go run leadertest.go {id}")
+		fmt.Println("usage: go run leadertest.go {id} [enrolment token]")
 	}
 	id := os.Args[1]
-	testAll(id)
+	token := ""
+	if len(os.Args) > 2 {
+		token = os.Args[2]
+	}
+	testAll(id, token)
 	//set.TestNoRoutine()
 	//set.TestRoutines()
 }
\ No newline at end of file
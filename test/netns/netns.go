@@ -0,0 +1,349 @@
+//go:build linux
+
+// Package netns builds small virtual networks out of linux network
+// namespaces joined by veth pairs, so traceroutes can be run against the
+// real kernel stack without touching the host's own network.
+//
+// Every namespace forwards packets and answers with real ICMP time exceeded
+// and port unreachable messages, from the interface the probe came in on,
+// with ICMP rate limiting turned off so back to back probes are all answered.
+// Routers with more than one route ahead balance flows across them by their
+// ports, as ECMP routers do. Building a network needs root and
+// the iproute2 "ip" and "sysctl" commands.
+package netns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Available reports why networks cannot be built here, or nil if they can.
+// Tests should skip when it returns an error.
+func Available() error {
+	if os.Geteuid() != 0 {
+		return errors.New("network namespaces need root")
+	}
+	for _, tool := range []string{"ip", "sysctl"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("%v is not installed", tool)
+		}
+	}
+	return nil
+}
+
+// Network is a set of namespaces and the veth links between them.
+type Network struct {
+	prefix     string
+	namespaces []string
+	links      int
+}
+
+// NewNetwork starts an empty network. Namespace and link names all begin
+// with prefix, which should be short since link names are capped at 15 bytes.
+func NewNetwork(prefix string) *Network {
+	return &Network{prefix: prefix}
+}
+
+func run(args ...string) error {
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (n *Network) exec(ns string, args ...string) error {
+	return run(append([]string{"ip", "netns", "exec", ns}, args...)...)
+}
+
+// AddNamespace creates a namespace acting as a router: loopback up,
+// forwarding on and ICMP rate limits off. It returns the namespace's name.
+func (n *Network) AddNamespace(name string) (string, error) {
+	ns := n.prefix + "-" + name
+	if err := run("ip", "netns", "add", ns); err != nil {
+		return "", err
+	}
+	n.namespaces = append(n.namespaces, ns)
+	steps := [][]string{
+		{"ip", "link", "set", "lo", "up"},
+		{"sysctl", "-qw", "net.ipv4.ip_forward=1"},
+		{"sysctl", "-qw", "net.ipv4.icmp_ratelimit=0"},
+		{"sysctl", "-qw", "net.ipv4.conf.all.rp_filter=0"},
+		{"sysctl", "-qw", "net.ipv4.icmp_errors_use_inbound_ifaddr=1"},
+		{"sysctl", "-qw", "net.ipv4.fib_multipath_hash_policy=1"},
+	}
+	for _, step := range steps {
+		if err := n.exec(ns, step...); err != nil {
+			return ns, err
+		}
+	}
+	return ns, nil
+}
+
+// Link joins namespaces a and b with a veth pair on the /24 subnet
+// "10.<subnet[0]>.<subnet[1]>.0". a gets .1 and b gets .2.
+func (n *Network) Link(a string, b string, subnet [2]byte) (aAddr [4]byte, bAddr [4]byte, err error) {
+	aAddr = [4]byte{10, subnet[0], subnet[1], 1}
+	bAddr = [4]byte{10, subnet[0], subnet[1], 2}
+	aName := fmt.Sprintf("%vl%va", n.prefix, n.links)
+	bName := fmt.Sprintf("%vl%vb", n.prefix, n.links)
+	n.links++
+	steps := [][]string{
+		{"ip", "link", "add", aName, "netns", a, "type", "veth", "peer", "name", bName, "netns", b},
+		{"ip", "netns", "exec", a, "ip", "addr", "add", AddressString(aAddr) + "/24", "dev", aName},
+		{"ip", "netns", "exec", b, "ip", "addr", "add", AddressString(bAddr) + "/24", "dev", bName},
+		{"ip", "netns", "exec", a, "ip", "link", "set", aName, "up"},
+		{"ip", "netns", "exec", b, "ip", "link", "set", bName, "up"},
+	}
+	for _, step := range steps {
+		if err = run(step...); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Route adds a route in ns to prefix (in CIDR form, or "default") via gateway.
+// Given more than one gateway, flows to prefix are balanced across them.
+func (n *Network) Route(ns string, prefix string, via ...[4]byte) error {
+	if len(via) == 1 {
+		return n.exec(ns, "ip", "route", "add", prefix, "via", AddressString(via[0]))
+	}
+	args := []string{"ip", "route", "add", prefix}
+	for _, gateway := range via {
+		args = append(args, "nexthop", "via", AddressString(gateway), "weight", "1")
+	}
+	return n.exec(ns, args...)
+}
+
+// Run calls fn on a thread that has joined namespace ns. Sockets made by fn
+// belong to that namespace. fn must not hand work to other goroutines.
+func (n *Network) Run(ns string, fn func() error) error {
+	runtime.LockOSThread()
+	home, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer home.Close()
+	target, err := os.Open("/var/run/netns/" + ns)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer target.Close()
+
+	if err := setns(target); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	fnErr := fn()
+	if err := setns(home); err != nil {
+		//leave the thread locked so it is thrown away instead of reused
+		return fmt.Errorf("could not return to the host namespace: %v", err)
+	}
+	runtime.UnlockOSThread()
+	return fnErr
+}
+
+func setns(f *os.File) error {
+	return unix.Setns(int(f.Fd()), unix.CLONE_NEWNET)
+}
+
+// Close deletes every namespace, which also removes their links.
+func (n *Network) Close() error {
+	var first error
+	for _, ns := range n.namespaces {
+		if err := run("ip", "netns", "del", ns); err != nil && first == nil {
+			first = err
+		}
+	}
+	n.namespaces = nil
+	return first
+}
+
+// Chain is a line of namespaces: a source, some routers and a destination.
+type Chain struct {
+	*Network
+	Source     string
+	Routers    []string
+	Dest       string
+	SourceAddr [4]byte
+	DestAddr   [4]byte
+	//Hops[i] is the address that answers a probe with TTL i+1: the interface
+	//of router i facing the source, and the destination itself last.
+	Hops [][4]byte
+}
+
+// NewChain builds source -> routers -> destination, with link i on
+// 10.99.i.0/24. Call Close when done, even if an error is returned.
+func NewChain(prefix string, routers int) (*Chain, error) {
+	c := &Chain{Network: NewNetwork(prefix)}
+	names := []string{"src"}
+	for i := 0; i < routers; i++ {
+		names = append(names, fmt.Sprintf("r%d", i+1))
+	}
+	names = append(names, "dst")
+
+	nodes := make([]string, len(names))
+	for i, name := range names {
+		ns, err := c.AddNamespace(name)
+		if err != nil {
+			return c, err
+		}
+		nodes[i] = ns
+	}
+	c.Source, c.Routers, c.Dest = nodes[0], nodes[1:len(nodes)-1], nodes[len(nodes)-1]
+
+	//near[i] and far[i] are the ends of link i, nearest and furthest from the source
+	near := make([][4]byte, len(nodes)-1)
+	far := make([][4]byte, len(nodes)-1)
+	for i := 0; i+1 < len(nodes); i++ {
+		a, b, err := c.Link(nodes[i], nodes[i+1], [2]byte{99, byte(i)})
+		if err != nil {
+			return c, err
+		}
+		near[i], far[i] = a, b
+	}
+	c.SourceAddr = near[0]
+	c.DestAddr = far[len(far)-1]
+	c.Hops = far
+
+	for k, ns := range nodes {
+		if k+1 < len(nodes) { //everything ahead is reached through the next node
+			if err := c.Route(ns, "default", far[k]); err != nil {
+				return c, err
+			}
+		}
+		if k == 0 {
+			continue
+		}
+		for j := 0; j < k-1; j++ { //subnets behind the previous node
+			if k+1 < len(nodes) {
+				subnet := fmt.Sprintf("10.99.%d.0/24", j)
+				if err := c.Route(ns, subnet, near[k-1]); err != nil {
+					return c, err
+				}
+			}
+		}
+		if k+1 == len(nodes) { //the destination sends everything back
+			if err := c.Route(ns, "default", near[k-1]); err != nil {
+				return c, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// Diamond is a network with parallel paths: a source, a router that
+// balances flows across some branch routers, a router where the branches
+// join, and a destination.
+type Diamond struct {
+	*Network
+	Source     string
+	Split      string
+	Branches   []string
+	Join       string
+	Dest       string
+	SourceAddr [4]byte
+	DestAddr   [4]byte
+	//Hops[i] is every address that can answer a probe with TTL i+1: the
+	//split, each branch, the join as reached from each branch, then the
+	//destination.
+	Hops [][][4]byte
+}
+
+// NewDiamond builds source -> split -> branches -> join -> destination with
+// width branches, on subnets of 10.98.0.0/16. Call Close when done, even if
+// an error is returned.
+func NewDiamond(prefix string, width int) (*Diamond, error) {
+	d := &Diamond{Network: NewNetwork(prefix)}
+	add := func(name string) (string, error) { return d.AddNamespace(name) }
+	var err error
+	if d.Source, err = add("src"); err != nil {
+		return d, err
+	}
+	if d.Split, err = add("split"); err != nil {
+		return d, err
+	}
+	for i := 0; i < width; i++ {
+		branch, err := add(fmt.Sprintf("b%d", i+1))
+		if err != nil {
+			return d, err
+		}
+		d.Branches = append(d.Branches, branch)
+	}
+	if d.Join, err = add("join"); err != nil {
+		return d, err
+	}
+	if d.Dest, err = add("dst"); err != nil {
+		return d, err
+	}
+
+	subnet := byte(0)
+	link := func(a string, b string) ([4]byte, [4]byte, error) {
+		subnet++
+		return d.Link(a, b, [2]byte{98, subnet - 1})
+	}
+	srcAddr, splitIn, err := link(d.Source, d.Split)
+	if err != nil {
+		return d, err
+	}
+	splitOut := make([][4]byte, width) //the split's end of the link to each branch
+	branchIn := make([][4]byte, width)
+	branchOut := make([][4]byte, width)
+	joinIn := make([][4]byte, width)
+	for i, branch := range d.Branches {
+		if splitOut[i], branchIn[i], err = link(d.Split, branch); err != nil {
+			return d, err
+		}
+	}
+	for i, branch := range d.Branches {
+		if branchOut[i], joinIn[i], err = link(branch, d.Join); err != nil {
+			return d, err
+		}
+	}
+	joinOut, dstAddr, err := link(d.Join, d.Dest)
+	if err != nil {
+		return d, err
+	}
+	d.SourceAddr, d.DestAddr = srcAddr, dstAddr
+	d.Hops = [][][4]byte{{splitIn}, branchIn, joinIn, {dstAddr}}
+
+	//forward along every branch, and back to the source the same way
+	type route struct {
+		ns     string
+		prefix string
+		via    [][4]byte
+	}
+	routes := []route{
+		{d.Source, "default", [][4]byte{splitIn}},
+		{d.Split, "default", branchIn},
+		{d.Join, "default", [][4]byte{dstAddr}},
+		{d.Join, "10.98.0.0/24", branchOut},
+		{d.Dest, "default", [][4]byte{joinOut}},
+	}
+	for i, branch := range d.Branches {
+		routes = append(routes,
+			route{branch, "default", [][4]byte{joinIn[i]}},
+			route{branch, "10.98.0.0/24", [][4]byte{splitOut[i]}})
+	}
+	for _, r := range routes {
+		if err := d.Route(r.ns, r.prefix, r.via...); err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+// AddressString formats an address as a dotted quad.
+func AddressString(add [4]byte) string {
+	return fmt.Sprintf("%v.%v.%v.%v", add[0], add[1], add[2], add[3])
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/arieltraver/ari_traceroute/tlsconf"
+)
+
+//writes a file only readable by its owner, refusing to overwrite one
+func writeNew(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+//the pin of a PEM certificate
+func pinOf(certPEM []byte) string {
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "?"
+	}
+	return tlsconf.Pin(cert)
+}
+
+//makes the campaign CA in dir, or loads the one already there
+func authority(dir string, validity time.Duration) (*tlsconf.Authority, error) {
+	certFile := filepath.Join(dir, "ca.pem")
+	keyFile := filepath.Join(dir, "ca-key.pem")
+	if _, err := os.Stat(certFile); err == nil {
+		fmt.Println("using the CA in", certFile)
+		return tlsconf.LoadAuthority(certFile, keyFile)
+	}
+	ca, certPEM, keyPEM, err := tlsconf.NewAuthority("traceroute campaign CA", validity)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeNew(certFile, certPEM); err != nil {
+		return nil, err
+	}
+	if err := writeNew(keyFile, keyPEM); err != nil {
+		return nil, err
+	}
+	fmt.Println("made the CA", certFile)
+	return ca, nil
+}
+
+//issues name.pem and name-key.pem in dir
+func issue(ca *tlsconf.Authority, dir string, name string, hosts []string, validity time.Duration) error {
+	certPEM, keyPEM, err := ca.Issue(name, hosts, validity)
+	if err != nil {
+		return err
+	}
+	certFile := filepath.Join(dir, name+".pem")
+	if err := writeNew(certFile, certPEM); err != nil {
+		return err
+	}
+	if err := writeNew(filepath.Join(dir, name+"-key.pem"), keyPEM); err != nil {
+		return err
+	}
+	fmt.Printf("made %s, pin %s\n", certFile, pinOf(certPEM))
+	return nil
+}
+
+//makes a campaign CA and certificates for the leader and monitors, for testing.
+//run it again with the same -dir to add monitors under the same CA.
+func main() {
+	dir := flag.String("dir", "certs", "directory to write the certificates and keys to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1", "comma separated names and addresses the leader is reached at, for its certificate. empty makes none")
+	valid := flag.Duration("valid", 90*24*time.Hour, "how long the certificates are valid")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: go run ./tlsconf/cmd/mkcerts [-dir certs] [-hosts names] [-valid duration] monitor...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	ca, err := authority(*dir, *valid)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(filepath.Join(*dir, "leader.pem")); err == nil {
+		fmt.Println("keeping the leader certificate already in", *dir)
+	} else if *hosts != "" {
+		if err := issue(ca, *dir, "leader", strings.Split(*hosts, ","), *valid); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, name := range flag.Args() {
+		if err := issue(ca, *dir, name, nil, *valid); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
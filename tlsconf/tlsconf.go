@@ -0,0 +1,230 @@
+// Package tlsconf builds the TLS configurations the leader and the monitors
+// talk over, and dials the leader's RPC server through them.
+//
+// The leader serves TLS with its own certificate. Given a campaign CA, it also
+// asks every monitor for a certificate signed by that CA, so only monitors the
+// campaign issued certificates to can connect (mutual TLS). A monitor checks
+// the leader against the campaign CA, or the system roots without one, and
+// can additionally pin the leader's public key, which holds even if the CA is
+// compromised. With a pin and no CA, the leader's certificate may be
+// self-signed.
+//
+// Certificates for local testing can be made with the mkcerts command.
+package tlsconf
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"strings"
+	"time"
+)
+
+// DIAL_TIMEOUT bounds how long DialRPC waits for the connection and handshake.
+const DIAL_TIMEOUT = 10 * time.Second
+
+// Config is what the -tls flags of the leader and the monitors set.
+type Config struct {
+	Cert string // PEM certificate file of this end
+	Key  string // PEM private key file of Cert
+	CA   string // PEM file of the campaign CA, which must have signed the other end's certificate
+
+	// Pins are base64 SHA-256 hashes of public keys, as made by Pin. If any
+	// are given, the other end's certificate must hold one of those keys.
+	Pins []string
+
+	MinVersion string // lowest TLS version allowed, "1.2" or "1.3", 1.2 if empty
+	Ciphers    string // comma separated TLS 1.2 cipher suites allowed, Go's defaults if empty
+}
+
+// AddFlags adds -tlscert, -tlskey, -tlsca, -tlspin, -tlsmin and -tlsciphers,
+// which set c, to a flag set. peer names the other end in their help.
+func (c *Config) AddFlags(flags *flag.FlagSet, peer string) {
+	flags.StringVar(&c.Cert, "tlscert", "", "PEM certificate to use TLS with")
+	flags.StringVar(&c.Key, "tlskey", "", "PEM private key of -tlscert")
+	flags.StringVar(&c.CA, "tlsca", "", "PEM campaign CA that must have signed the "+peer+"'s certificate")
+	flags.Func("tlspin", "base64 SHA-256 of a public key the "+peer+"'s certificate must hold, may be given more than once", func(pin string) error {
+		c.Pins = append(c.Pins, pin)
+		return nil
+	})
+	flags.StringVar(&c.MinVersion, "tlsmin", "1.2", "lowest TLS version to accept, 1.2 or 1.3")
+	flags.StringVar(&c.Ciphers, "tlsciphers", "", "comma separated TLS 1.2 cipher suites to allow, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. Go's defaults if empty")
+}
+
+// Pin returns the pin of a certificate: the base64 SHA-256 hash of its public key.
+// It stays the same when the certificate is reissued for the same key.
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ParseVersion returns the TLS version named by "1.2" or "1.3".
+func ParseVersion(name string) (uint16, error) {
+	switch name {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, use 1.2 or 1.3", name)
+}
+
+// ParseCiphers returns the cipher suites named in a comma separated list, as
+// named by crypto/tls, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. Only
+// secure suites are accepted. An empty list gives nil, which leaves the choice to Go.
+func ParseCiphers(list string) ([]uint16, error) {
+	if list == "" {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Enabled reports whether anything asks for TLS.
+func (c Config) Enabled() bool {
+	return c.Cert != "" || c.CA != "" || len(c.Pins) > 0
+}
+
+//the settings both ends share
+func (c Config) base() (*tls.Config, error) {
+	version, err := ParseVersion(c.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	ciphers, err := ParseCiphers(c.Ciphers)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: version, CipherSuites: ciphers}
+	if (c.Cert == "") != (c.Key == "") {
+		return nil, errors.New("a certificate and its key must be given together")
+	}
+	if c.Cert != "" {
+		pair, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	for _, pin := range c.Pins {
+		if raw, err := base64.StdEncoding.DecodeString(pin); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("bad pin %q, expected the base64 SHA-256 of a public key", pin)
+		}
+	}
+	return config, nil
+}
+
+//reads the CA certificates in a PEM file
+func loadPool(path string) (*x509.CertPool, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(text) {
+		return nil, fmt.Errorf("%s holds no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+//fails unless the leaf certificate holds a pinned key
+func (c Config) checkPins(state tls.ConnectionState) error {
+	if len(c.Pins) == 0 {
+		return nil
+	}
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("tlsconf: no certificate to check the pins against")
+	}
+	got := Pin(state.PeerCertificates[0])
+	for _, pin := range c.Pins {
+		if pin == got {
+			return nil
+		}
+	}
+	return fmt.Errorf("tlsconf: certificate key %s is not pinned", got)
+}
+
+// Server returns the configuration the leader listens with. A certificate is
+// required. With a CA, clients must present a certificate the CA signed.
+func (c Config) Server() (*tls.Config, error) {
+	if c.Cert == "" {
+		return nil, errors.New("serving TLS needs a certificate and key")
+	}
+	config, err := c.base()
+	if err != nil {
+		return nil, err
+	}
+	if c.CA != "" {
+		if config.ClientCAs, err = loadPool(c.CA); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	} else if len(c.Pins) > 0 {
+		config.ClientAuth = tls.RequireAnyClientCert
+	}
+	config.VerifyConnection = c.checkPins
+	return config, nil
+}
+
+// Client returns the configuration a monitor connects to the leader at
+// serverName with. The certificate, if given, is presented for mutual TLS.
+func (c Config) Client(serverName string) (*tls.Config, error) {
+	config, err := c.base()
+	if err != nil {
+		return nil, err
+	}
+	config.ServerName = serverName
+	if c.CA != "" {
+		if config.RootCAs, err = loadPool(c.CA); err != nil {
+			return nil, err
+		}
+	}
+	if c.CA == "" && len(c.Pins) > 0 {
+		//the pin alone decides, so a self-signed leader can be used
+		config.InsecureSkipVerify = true
+	}
+	config.VerifyConnection = c.checkPins
+	return config, nil
+}
+
+// DialRPC connects to an RPC server served over HTTP at address, as
+// rpc.DialHTTP does, but over TLS.
+func DialRPC(address string, config *tls.Config) (*rpc.Client, error) {
+	dialer := &net.Dialer{Timeout: DIAL_TIMEOUT}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status == "200 Connected to Go RPC" {
+		return rpc.NewClient(conn), nil
+	}
+	if err == nil {
+		err = errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	conn.Close()
+	return nil, &net.OpError{Op: "dial-http", Net: "tcp " + address, Addr: nil, Err: err}
+}
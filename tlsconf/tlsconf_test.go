@@ -0,0 +1,140 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type Echo int
+
+func (*Echo) Say(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+//writes a CA, a leader certificate for 127.0.0.1 and a monitor certificate to dir
+func campaign(t *testing.T, dir string) (leaderPin string) {
+	t.Helper()
+	ca, certPEM, keyPEM, err := NewAuthority("test CA", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{"ca.pem": certPEM, "ca-key.pem": keyPEM}
+	for name, hosts := range map[string][]string{"leader": {"127.0.0.1"}, "mon1": nil} {
+		certPEM, keyPEM, err := ca.Issue(name, hosts, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name+".pem"] = certPEM
+		files[name+"-key.pem"] = keyPEM
+		if name == "leader" {
+			block, _ := pem.Decode(certPEM)
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaderPin = Pin(cert)
+		}
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := LoadAuthority(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")); err != nil {
+		t.Errorf("could not load the CA back: %v", err)
+	}
+	return leaderPin
+}
+
+//serves an Echo over TLS as the leader serves its rpc
+func serve(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	server := rpc.NewServer()
+	if err := server.Register(new(Echo)); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, server)
+	ts := httptest.NewUnstartedServer(mux)
+	ts.TLS = config
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts.Listener.Addr().String()
+}
+
+func call(address string, config Config) error {
+	clientConfig, err := config.Client("127.0.0.1")
+	if err != nil {
+		return err
+	}
+	client, err := DialRPC(address, clientConfig)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	reply := ""
+	return client.Call("Echo.Say", "hello", &reply)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string) string { return filepath.Join(dir, name) }
+	leaderPin := campaign(t, dir)
+	server, err := Config{Cert: file("leader.pem"), Key: file("leader-key.pem"), CA: file("ca.pem"), MinVersion: "1.3"}.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := serve(t, server)
+
+	monitor := Config{Cert: file("mon1.pem"), Key: file("mon1-key.pem"), CA: file("ca.pem")}
+	if err := call(address, monitor); err != nil {
+		t.Errorf("an issued monitor could not call: %v", err)
+	}
+	if err := call(address, Config{CA: file("ca.pem")}); err == nil {
+		t.Errorf("a monitor without a certificate could call")
+	}
+	if err := call(address, Config{Cert: file("mon1.pem"), Key: file("mon1-key.pem")}); err == nil {
+		t.Errorf("the leader was trusted without the CA or a pin")
+	}
+
+	pinned := Config{Cert: file("mon1.pem"), Key: file("mon1-key.pem"), Pins: []string{leaderPin}}
+	if err := call(address, pinned); err != nil {
+		t.Errorf("a pinned leader was refused: %v", err)
+	}
+	pinned.CA = file("ca.pem")
+	if err := call(address, pinned); err != nil {
+		t.Errorf("a pinned leader was refused with the CA: %v", err)
+	}
+	wrong := Config{Cert: file("mon1.pem"), Key: file("mon1-key.pem"), CA: file("ca.pem"), Pins: []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}}
+	if err := call(address, wrong); err == nil {
+		t.Errorf("a leader with an unpinned key was trusted")
+	}
+	if err := call(address, Config{Cert: file("mon1.pem"), Key: file("mon1-key.pem"), CA: file("ca.pem"), Pins: []string{"nope"}}); err == nil {
+		t.Errorf("a malformed pin was accepted")
+	}
+}
+
+func TestVersionsAndCiphers(t *testing.T) {
+	if v, err := ParseVersion("1.3"); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("1.3 gave %x %v", v, err)
+	}
+	if _, err := ParseVersion("1.0"); err == nil {
+		t.Errorf("TLS 1.0 was accepted")
+	}
+	ids, err := ParseCiphers("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256")
+	if err != nil || len(ids) != 2 || ids[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected suites %v %v", ids, err)
+	}
+	if _, err := ParseCiphers("TLS_RSA_WITH_RC4_128_SHA"); err == nil {
+		t.Errorf("an insecure suite was accepted")
+	}
+}
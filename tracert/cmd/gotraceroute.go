@@ -3,8 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
-	"github.com/aeden/traceroute"
+	"github.com/arieltraver/ari_traceroute/tracert"
 	"net"
+	"os"
 )
 
 func printHop(hop traceroute.TracerouteHop) {
@@ -28,6 +29,8 @@ func main() {
 	var m = flag.Int("m", traceroute.DEFAULT_MAX_HOPS, `Set the max time-to-live (max number of hops) used in outgoing probe packets (default is 64)`)
 	var f = flag.Int("f", traceroute.DEFAULT_FIRST_HOP, `Set the first used time-to-live, e.g. the first hop (default is 1)`)
 	var q = flag.Int("q", 1, `Set the number of probes per "ttl" to nqueries (default is one probe).`)
+	var n = flag.Bool("n", false, `Print hop addresses numerically rather than looking up host names.`)
+	var w = flag.String("w", "", `Write every probe and reply to a pcap file, readable by tcpdump and the replay tool.`)
 
 	flag.Parse()
 	host := flag.Arg(0)
@@ -35,6 +38,21 @@ func main() {
 	options.SetRetries(*q - 1)
 	options.SetMaxHops(*m + 1)
 	options.SetFirstHop(*f)
+	options.SetNoLookup(*n)
+	if *w != "" {
+		file, err := os.Create(*w)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer file.Close()
+		capture, err := traceroute.NewPcapWriter(file)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		options.SetCapture(capture)
+	}
 
 	ipAddr, err := net.ResolveIPAddr("ip", host)
 	if err != nil {
@@ -57,6 +75,6 @@ func main() {
 
 	_, err = traceroute.Traceroute(host, &options, c)
 	if err != nil {
-		fmt.Printf("Error: ", err)
+		fmt.Printf("Error: %v\n", err)
 	}
 }
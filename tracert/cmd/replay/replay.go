@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"github.com/arieltraver/ari_traceroute/tracert"
+	"os"
+)
+
+func address(address [4]byte) string {
+	return fmt.Sprintf("%v.%v.%v.%v", address[0], address[1], address[2], address[3])
+}
+
+//rebuilds and prints the traceroutes stored in a capture made with -w or -pcap
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("usage: go run replay.go capture.pcap")
+		return
+	}
+	file, err := os.Open(os.Args[1])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	results, err := traceroute.Replay(file)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	for _, result := range results {
+		fmt.Printf("traceroute to %v\n", address(result.DestinationAddress))
+		for _, hop := range result.Hops {
+			fmt.Printf("%-3d %v  %v\n", hop.TTL, hop.AddressString(), hop.ElapsedTime)
+		}
+		fmt.Println()
+	}
+}
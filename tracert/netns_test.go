@@ -0,0 +1,87 @@
+//go:build linux
+
+package traceroute
+
+import (
+	"testing"
+
+	"github.com/arieltraver/ari_traceroute/test/netns"
+)
+
+func TestTracerouteNetns(t *testing.T) {
+	if err := netns.Available(); err != nil {
+		t.Skip(err)
+	}
+	chain, err := netns.NewChain("trt", 3)
+	defer chain.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out TracerouteResult
+	err = chain.Run(chain.Source, func() error {
+		options := &TracerouteOptions{}
+		options.SetMaxHops(len(chain.Hops) + 2)
+		options.SetRetries(1)
+		options.SetNoLookup(true) //no DNS inside the namespaces
+		var err error
+		out, err = Traceroute(netns.AddressString(chain.DestAddr), options)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Hops) != len(chain.Hops) {
+		t.Fatalf("expected %d hops, got %v", len(chain.Hops), out.Hops)
+	}
+	for i, hop := range out.Hops {
+		if hop.Address != chain.Hops[i] || hop.TTL != i+1 {
+			t.Errorf("hop %d: expected %v, got %v ttl %d", i, chain.Hops[i], hop.Address, hop.TTL)
+		}
+	}
+}
+
+func TestTracerouteDiamond(t *testing.T) {
+	if err := netns.Available(); err != nil {
+		t.Skip(err)
+	}
+	diamond, err := netns.NewDiamond("trd", 2)
+	defer diamond.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//every probe has a socket of its own, so a new source port and flow
+	branches := map[[4]byte]bool{}
+	for run := 0; run < 8; run++ {
+		var out TracerouteResult
+		err = diamond.Run(diamond.Source, func() error {
+			options := &TracerouteOptions{}
+			options.SetMaxHops(len(diamond.Hops) + 2)
+			options.SetRetries(1)
+			options.SetNoLookup(true)
+			var err error
+			out, err = Traceroute(netns.AddressString(diamond.DestAddr), options)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Hops) != len(diamond.Hops) {
+			t.Fatalf("expected %d hops, got %v", len(diamond.Hops), out.Hops)
+		}
+		for i, hop := range out.Hops {
+			found := false
+			for _, addr := range diamond.Hops[i] {
+				found = found || hop.Address == addr
+			}
+			if !found {
+				t.Errorf("hop %d: %v is none of %v", i, hop.Address, diamond.Hops[i])
+			}
+		}
+		branches[out.Hops[1].Address] = true
+	}
+	if len(branches) < 2 {
+		t.Errorf("every flow took the same branch, %v", branches)
+	}
+}
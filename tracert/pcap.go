@@ -0,0 +1,151 @@
+package traceroute
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// pcap file constants. Packets are stored as raw IPv4 with no link layer
+// header, and timestamps are stored with nanosecond resolution.
+const PCAP_MAGIC_NANO = 0xa1b23c4d
+const PCAP_MAGIC_MICRO = 0xa1b2c3d4
+const PCAP_SNAPLEN = 65535
+const LINKTYPE_RAW = 101
+
+// PcapWriter records sent probes and received ICMP replies to a pcap file
+// that tcpdump, wireshark or Replay can read back.
+type PcapWriter struct {
+	w    io.Writer
+	lock sync.Mutex
+}
+
+// NewPcapWriter writes the pcap file header to w and returns a writer for it.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], PCAP_MAGIC_NANO)
+	binary.LittleEndian.PutUint16(header[4:], 2) //version 2.4
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], PCAP_SNAPLEN)
+	binary.LittleEndian.PutUint32(header[20:], LINKTYPE_RAW)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+// WritePacket writes a single raw IPv4 packet captured at the given time.
+func (pw *PcapWriter) WritePacket(at time.Time, pkt []byte) error {
+	if len(pkt) > PCAP_SNAPLEN {
+		pkt = pkt[:PCAP_SNAPLEN]
+	}
+	record := make([]byte, 16, 16+len(pkt))
+	binary.LittleEndian.PutUint32(record[0:], uint32(at.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(at.Nanosecond()))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(pkt)))
+	record = append(record, pkt...)
+
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+	_, err := pw.w.Write(record)
+	return err
+}
+
+// WriteProbe records an outgoing UDP probe. The kernel builds the real
+// headers, so an equivalent IPv4 and UDP header is rebuilt here.
+func (pw *PcapWriter) WriteProbe(at time.Time, src [4]byte, srcPort int, dst [4]byte, dstPort int, ttl int, payload []byte) error {
+	return pw.WritePacket(at, udpPacket(src, srcPort, dst, dstPort, ttl, payload))
+}
+
+// WriteReply records a received ICMP reply, including its IPv4 header.
+func (pw *PcapWriter) WriteReply(at time.Time, pkt []byte) error {
+	return pw.WritePacket(at, pkt)
+}
+
+// builds an IPv4 packet holding a UDP datagram. The UDP checksum is left at
+// zero, which IPv4 allows.
+func udpPacket(src [4]byte, srcPort int, dst [4]byte, dstPort int, ttl int, payload []byte) []byte {
+	total := 20 + 8 + len(payload)
+	pkt := make([]byte, total)
+	pkt[0] = 0x45 //version 4, 5 word header
+	binary.BigEndian.PutUint16(pkt[2:], uint16(total))
+	pkt[8] = byte(ttl)
+	pkt[9] = 17 //UDP
+	copy(pkt[12:16], src[:])
+	copy(pkt[16:20], dst[:])
+	binary.BigEndian.PutUint16(pkt[10:], ipChecksum(pkt[:20]))
+	binary.BigEndian.PutUint16(pkt[20:], uint16(srcPort))
+	binary.BigEndian.PutUint16(pkt[22:], uint16(dstPort))
+	binary.BigEndian.PutUint16(pkt[24:], uint16(8+len(payload)))
+	copy(pkt[28:], payload)
+	return pkt
+}
+
+func ipChecksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// PcapReader reads packets back out of a pcap file written by PcapWriter
+// or by tcpdump with a raw IP link type.
+type PcapReader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	nano  bool
+}
+
+// NewPcapReader reads and checks the pcap file header.
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	pr := &PcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(header) == PCAP_MAGIC_NANO:
+		pr.order, pr.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == PCAP_MAGIC_NANO:
+		pr.order, pr.nano = binary.BigEndian, true
+	case binary.LittleEndian.Uint32(header) == PCAP_MAGIC_MICRO:
+		pr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == PCAP_MAGIC_MICRO:
+		pr.order = binary.BigEndian
+	default:
+		return nil, errors.New("not a pcap file")
+	}
+	if pr.order.Uint32(header[20:]) != LINKTYPE_RAW {
+		return nil, errors.New("pcap link type is not raw IP")
+	}
+	return pr, nil
+}
+
+// Next returns the next packet and its capture time, or io.EOF at the end
+// of the file.
+func (pr *PcapReader) Next() (time.Time, []byte, error) {
+	record := make([]byte, 16)
+	if _, err := io.ReadFull(pr.r, record); err != nil {
+		return time.Time{}, nil, err
+	}
+	sec := int64(pr.order.Uint32(record[0:]))
+	frac := int64(pr.order.Uint32(record[4:]))
+	if !pr.nano {
+		frac *= 1000
+	}
+	pkt := make([]byte, pr.order.Uint32(record[8:]))
+	if _, err := io.ReadFull(pr.r, pkt); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return time.Time{}, nil, err
+	}
+	return time.Unix(sec, frac), pkt, nil
+}
@@ -0,0 +1,74 @@
+package traceroute
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// builds an ICMP time exceeded reply from router, quoting the probe that
+// triggered it the way the kernel hands it to a raw socket.
+func timeExceeded(router [4]byte, source [4]byte, probe []byte) []byte {
+	icmp := append([]byte{11, 0, 0, 0, 0, 0, 0, 0}, probe...)
+	return ipPacket(router, source, 1, icmp)
+}
+
+func ipPacket(src [4]byte, dst [4]byte, proto byte, body []byte) []byte {
+	pkt := make([]byte, 20, 20+len(body))
+	pkt[0] = 0x45
+	pkt[8] = 64
+	pkt[9] = proto
+	copy(pkt[12:16], src[:])
+	copy(pkt[16:20], dst[:])
+	return append(pkt, body...)
+}
+
+func TestPcapReplay(t *testing.T) {
+	source := [4]byte{10, 0, 0, 1}
+	dest := [4]byte{192, 0, 2, 7}
+	routers := [][4]byte{{10, 0, 0, 254}, {172, 16, 0, 1}, dest}
+	start := time.Unix(1700000000, 123456789)
+
+	buf := &bytes.Buffer{}
+	w, err := NewPcapWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, router := range routers {
+		sent := start.Add(time.Duration(i) * time.Second)
+		probe := udpPacket(source, 40000, dest, DEFAULT_PORT, i+1, []byte{0x0})
+		if err := w.WriteProbe(sent, source, 40000, dest, DEFAULT_PORT, i+1, []byte{0x0}); err != nil {
+			t.Fatal(err)
+		}
+		rtt := time.Duration(i+1) * time.Millisecond
+		if err := w.WriteReply(sent.Add(rtt), timeExceeded(router, source, probe[:24])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := Replay(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].DestinationAddress != dest {
+		t.Fatalf("expected one result for %v, got %v", dest, results)
+	}
+	hops := results[0].Hops
+	if len(hops) != len(routers) {
+		t.Fatalf("expected %d hops, got %d", len(routers), len(hops))
+	}
+	for i, hop := range hops {
+		if hop.Address != routers[i] || hop.TTL != i+1 {
+			t.Errorf("hop %d: got %v ttl %d", i, hop.Address, hop.TTL)
+		}
+		if hop.ElapsedTime != time.Duration(i+1)*time.Millisecond {
+			t.Errorf("hop %d: elapsed time %v", i, hop.ElapsedTime)
+		}
+	}
+}
+
+func TestPcapReaderRejectsGarbage(t *testing.T) {
+	if _, err := NewPcapReader(bytes.NewReader(make([]byte, 24))); err == nil {
+		t.Error("expected an error for a file with no pcap magic")
+	}
+}
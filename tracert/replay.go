@@ -0,0 +1,78 @@
+package traceroute
+
+import (
+	"io"
+	"time"
+)
+
+// a probe that has been sent but not yet answered
+type pendingProbe struct {
+	ttl  int
+	sent time.Time
+}
+
+// Replay rebuilds TracerouteResults from a capture written with
+// SetCapture. Each ICMP reply is matched to the latest unanswered probe sent
+// to the destination quoted inside it. Results are returned in the order
+// their first probe appears in the capture. Hosts are not looked up again.
+func Replay(r io.Reader) ([]TracerouteResult, error) {
+	pr, err := NewPcapReader(r)
+	if err != nil {
+		return nil, err
+	}
+	results := []TracerouteResult{}
+	index := make(map[[4]byte]int)
+	pending := make(map[[4]byte]pendingProbe)
+	for {
+		at, pkt, err := pr.Next()
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return results, err
+		}
+		src, dst, proto, ttl, body, ok := parseIPv4(pkt)
+		if !ok {
+			continue
+		}
+		switch proto {
+		case 17: //outgoing UDP probe
+			if _, seen := index[dst]; !seen {
+				index[dst] = len(results)
+				results = append(results, TracerouteResult{DestinationAddress: dst, Hops: []TracerouteHop{}})
+			}
+			pending[dst] = pendingProbe{ttl: ttl, sent: at}
+		case 1: //ICMP reply, quoting the probe's IPv4 header after 8 bytes
+			if len(body) < 8 {
+				continue
+			}
+			_, dest, _, _, _, ok := parseIPv4(body[8:])
+			if !ok {
+				continue
+			}
+			probe, ok := pending[dest]
+			if !ok {
+				continue
+			}
+			delete(pending, dest)
+			hop := TracerouteHop{Success: true, Address: src, N: len(pkt), ElapsedTime: at.Sub(probe.sent), TTL: probe.ttl, SentAt: probe.sent, ReceivedAt: at}
+			result := &results[index[dest]]
+			result.Hops = append(result.Hops, hop)
+		}
+	}
+}
+
+// reads the addresses, protocol and TTL out of an IPv4 header. Quoted headers
+// inside ICMP errors are often truncated, so only the header itself must be whole.
+func parseIPv4(pkt []byte) (src [4]byte, dst [4]byte, proto int, ttl int, body []byte, ok bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 {
+		return
+	}
+	headerLen := int(pkt[0]&0x0f) * 4
+	if headerLen < 20 || len(pkt) < headerLen {
+		return
+	}
+	copy(src[:], pkt[12:16])
+	copy(dst[:], pkt[16:20])
+	return src, dst, int(pkt[9]), int(pkt[8]), pkt[headerLen:], true
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package traceroute
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// EnableRecvTimestamps asks the kernel to stamp each packet received on fd
+// with the time it arrived, so RecvTimestamped does not count the time the
+// packet sat in the socket queue waiting for this goroutine to run.
+func EnableRecvTimestamps(fd int) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+}
+
+// RecvTimestamped works like syscall.Recvfrom, and also returns the time the
+// kernel received the packet. If the socket has no timestamps turned on, the
+// time after the read returns is used instead.
+func RecvTimestamped(fd int, p []byte) (n int, from syscall.Sockaddr, at time.Time, err error) {
+	var ts syscall.Timespec
+	oob := make([]byte, syscall.CmsgSpace(int(unsafe.Sizeof(ts))))
+	n, oobn, _, from, err := syscall.Recvmsg(fd, p, oob, 0)
+	at = time.Now()
+	if err != nil {
+		return
+	}
+	msgs, perr := syscall.ParseSocketControlMessage(oob[:oobn])
+	if perr != nil {
+		return
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == syscall.SOL_SOCKET && msg.Header.Type == syscall.SCM_TIMESTAMPNS && len(msg.Data) >= int(unsafe.Sizeof(ts)) {
+			ts = *(*syscall.Timespec)(unsafe.Pointer(&msg.Data[0]))
+			at = time.Unix(ts.Unix())
+		}
+	}
+	return
+}
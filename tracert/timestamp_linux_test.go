@@ -0,0 +1,43 @@
+package traceroute
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRecvTimestamped(t *testing.T) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	loopback := [4]byte{127, 0, 0, 1}
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: loopback}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableRecvTimestamps(fd); err != nil {
+		t.Fatal(err)
+	}
+	self, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := time.Now()
+	if err := syscall.Sendto(fd, []byte{0x0}, 0, self); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond) //the kernel stamp should not include this wait
+	p := make([]byte, 8)
+	n, _, at, err := RecvTimestamped(fd, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 byte, got %d", n)
+	}
+	if at.Before(sent) || at.Sub(sent) >= 20*time.Millisecond {
+		t.Errorf("receive time %v is not the kernel's arrival time (sent at %v)", at, sent)
+	}
+}
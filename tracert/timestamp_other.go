@@ -0,0 +1,21 @@
+//go:build !linux
+
+package traceroute
+
+import (
+	"syscall"
+	"time"
+)
+
+// EnableRecvTimestamps does nothing off linux, where SO_TIMESTAMPNS is missing.
+func EnableRecvTimestamps(fd int) error {
+	return nil
+}
+
+// RecvTimestamped works like syscall.Recvfrom, and also returns the time the
+// read returned.
+func RecvTimestamped(fd int, p []byte) (n int, from syscall.Sockaddr, at time.Time, err error) {
+	n, from, err = syscall.Recvfrom(fd, p, 0)
+	at = time.Now()
+	return
+}
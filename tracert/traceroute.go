@@ -61,6 +61,8 @@ type TracerouteOptions struct {
 	timeoutMs  int
 	retries    int
 	packetSize int
+	noLookup   bool
+	capture    *PcapWriter
 }
 
 func (options *TracerouteOptions) Port() int {
@@ -129,7 +131,28 @@ func (options *TracerouteOptions) SetPacketSize(packetSize int) {
 	options.packetSize = packetSize
 }
 
+// NoLookup reports whether reverse DNS lookups of hop addresses are skipped.
+func (options *TracerouteOptions) NoLookup() bool {
+	return options.noLookup
+}
+
+func (options *TracerouteOptions) SetNoLookup(noLookup bool) {
+	options.noLookup = noLookup
+}
+
+// Capture returns the writer that probes and replies are recorded to, or nil.
+func (options *TracerouteOptions) Capture() *PcapWriter {
+	return options.capture
+}
+
+func (options *TracerouteOptions) SetCapture(capture *PcapWriter) {
+	options.capture = capture
+}
+
 // TracerouteHop type
+//
+// SentAt and ReceivedAt are absolute, so hops seen by different monitors
+// can be lined up in time. ElapsedTime is the difference between them.
 type TracerouteHop struct {
 	Success     bool
 	Address     [4]byte
@@ -137,6 +160,8 @@ type TracerouteHop struct {
 	N           int
 	ElapsedTime time.Duration
 	TTL         int
+	SentAt      time.Time
+	ReceivedAt  time.Time
 }
 
 func (hop *TracerouteHop) AddressString() string {
@@ -169,6 +194,30 @@ func closeNotify(channels []chan TracerouteHop) {
 	}
 }
 
+// CaptureProbe records a probe just sent on sendSocket to port on dest, if
+// capture is not nil. The source port is the one the kernel picked for the
+// socket.
+func CaptureProbe(capture *PcapWriter, sendSocket int, sent time.Time, src [4]byte, dest [4]byte, port int, ttl int, payload []byte) error {
+	if capture == nil {
+		return nil
+	}
+	srcPort := 0
+	if sa, err := syscall.Getsockname(sendSocket); err == nil {
+		if sa4, ok := sa.(*syscall.SockaddrInet4); ok {
+			srcPort = sa4.Port
+		}
+	}
+	return capture.WriteProbe(sent, src, srcPort, dest, port, ttl, payload)
+}
+
+// CaptureReply records a received ICMP reply, if capture is not nil.
+func CaptureReply(capture *PcapWriter, at time.Time, pkt []byte) error {
+	if capture == nil {
+		return nil
+	}
+	return capture.WriteReply(at, pkt)
+}
+
 // Traceroute uses the given dest (hostname) and options to execute a traceroute
 // from your machine to the remote host.
 //
@@ -192,7 +241,6 @@ func Traceroute(dest string, options *TracerouteOptions, c ...chan TracerouteHop
 	retry := 0
 	for {
 		//log.Println("TTL: ", ttl)
-		start := time.Now()
 
 		// Set up the socket to receive inbound packets
 		recvSocket, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
@@ -215,6 +263,8 @@ func Traceroute(dest string, options *TracerouteOptions, c ...chan TracerouteHop
 		syscall.SetsockoptInt(sendSocket, 0x0, syscall.IP_TTL, ttl)
 		// This sets the timeout to wait for a response from the remote host
 		syscall.SetsockoptTimeval(recvSocket, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+		// Have the kernel stamp replies as they arrive
+		EnableRecvTimestamps(recvSocket)
 
 		defer syscall.Close(recvSocket)
 		defer syscall.Close(sendSocket)
@@ -230,21 +280,30 @@ func Traceroute(dest string, options *TracerouteOptions, c ...chan TracerouteHop
 			  print out the checksum each time
 		*/
 		// Send a single null byte UDP packet
-		syscall.Sendto(sendSocket, []byte{0x0}, 0, &syscall.SockaddrInet4{Port: options.Port(), Addr: destAddr})
+		payload := []byte{0x0}
+		sent := time.Now()
+		syscall.Sendto(sendSocket, payload, 0, &syscall.SockaddrInet4{Port: options.Port(), Addr: destAddr})
+		if err := CaptureProbe(options.Capture(), sendSocket, sent, socketAddr, destAddr, options.Port(), ttl, payload); err != nil {
+			return result, err
+		}
 
 		var p = make([]byte, options.PacketSize())
-		n, from, err := syscall.Recvfrom(recvSocket, p, 0)
-		elapsed := time.Since(start)
+		n, from, received, err := RecvTimestamped(recvSocket, p)
 		if err == nil {
 			currAddr := from.(*syscall.SockaddrInet4).Addr
+			if err := CaptureReply(options.Capture(), received, p[:n]); err != nil {
+				return result, err
+			}
 
-			hop := TracerouteHop{Success: true, Address: currAddr, N: n, ElapsedTime: elapsed, TTL: ttl}
+			hop := TracerouteHop{Success: true, Address: currAddr, N: n, ElapsedTime: received.Sub(sent), TTL: ttl, SentAt: sent, ReceivedAt: received}
 
 			// TODO: this reverse lookup appears to have some standard timeout that is relatively
 			// high. Consider switching to something where there is greater control.
-			currHost, err := net.LookupAddr(hop.AddressString())
-			if err == nil {
-				hop.Host = currHost[0]
+			if !options.NoLookup() {
+				currHost, err := net.LookupAddr(hop.AddressString())
+				if err == nil {
+					hop.Host = currHost[0]
+				}
 			}
 
 			notify(hop, c)